@@ -19,6 +19,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/golang/glog"
@@ -47,6 +48,13 @@ import (
 
 const controllerAgentName = "apprepository-controller"
 
+const (
+	// credentialsVolumeName is the name of the volume containing the credentials of the credential provider of the sync job
+	credentialsVolumeName = "credentials"
+	// credentialsMountPath is the directory of the credentials in the sync job, one file per key of the secret
+	credentialsMountPath = "/etc/credentials"
+)
+
 const (
 	// SuccessSynced is used as part of the Event 'reason' when an AppRepository
 	// is synced
@@ -443,6 +451,21 @@ func syncJobSpec(apprepo *apprepov1alpha1.AppRepository) batchv1.JobSpec {
 			MountPath: "/usr/local/share/ca-certificates",
 		})
 	}
+	if apprepo.Spec.Auth.CredentialProvider != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: credentialsVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: apprepo.Spec.Auth.CredentialProvider.SecretRef.Name,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      credentialsVolumeName,
+			ReadOnly:  true,
+			MountPath: credentialsMountPath,
+		})
+	}
 	// Get the predefined pod spec for the apprepo definition if exists
 	podTemplateSpec := apprepo.Spec.SyncJobPodTemplate
 	// Add labels
@@ -583,6 +606,60 @@ func apprepoSyncJobEnvVars(apprepo *apprepov1alpha1.AppRepository) []corev1.EnvV
 			},
 		})
 	}
+	if apprepo.Spec.Auth.CredentialProvider != nil {
+		envVars = append(envVars,
+			corev1.EnvVar{Name: "CREDENTIAL_PROVIDER", Value: apprepo.Spec.Auth.CredentialProvider.Name},
+			corev1.EnvVar{Name: "CREDENTIALS_DIR", Value: credentialsMountPath},
+		)
+	}
+	if apprepo.Spec.Transport != nil {
+		envVars = append(envVars, apprepoTransportEnvVars(apprepo.Spec.Transport)...)
+	}
+	return envVars
+}
+
+// apprepoTransportEnvVars returns the env vars for the transport settings of an apprepo. The proxy
+// settings use the standard variables, which replace the ones of the sync job pod template.
+func apprepoTransportEnvVars(transport *apprepov1alpha1.AppRepositoryTransport) []corev1.EnvVar {
+	var envVars []corev1.EnvVar
+	envVar := func(value string, names ...string) {
+		for _, name := range names {
+			envVars = append(envVars, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+
+	if transport.ProxyURL != "" {
+		envVar(transport.ProxyURL, "HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy")
+	}
+	if transport.DisableProxy {
+		envVar("*", "NO_PROXY", "no_proxy")
+	} else if transport.NoProxy != "" {
+		envVar(transport.NoProxy, "NO_PROXY", "no_proxy")
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int64
+	}{
+		{"HTTP_TIMEOUT_SECONDS", transport.TimeoutSeconds},
+		{"HTTP_TLS_HANDSHAKE_TIMEOUT_SECONDS", transport.TLSHandshakeTimeoutSeconds},
+		{"HTTP_RESPONSE_HEADER_TIMEOUT_SECONDS", transport.ResponseHeaderTimeoutSeconds},
+		{"HTTP_IDLE_CONN_TIMEOUT_SECONDS", transport.IdleConnTimeoutSeconds},
+		{"HTTP_MAX_IDLE_CONNS", int64(transport.MaxIdleConns)},
+		{"HTTP_MAX_IDLE_CONNS_PER_HOST", int64(transport.MaxIdleConnsPerHost)},
+	} {
+		if setting.value > 0 {
+			envVar(strconv.FormatInt(setting.value, 10), setting.name)
+		}
+	}
+
+	if transport.EnableHTTP2 != nil {
+		envVar(strconv.FormatBool(*transport.EnableHTTP2), "HTTP_ENABLE_HTTP2")
+		if !*transport.EnableHTTP2 {
+			// Disables HTTP/2 of the default transport of go programs
+			envVar("http2client=0", "GODEBUG")
+		}
+	}
 	return envVars
 }
 
@@ -462,6 +462,174 @@ func Test_newSyncJob(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"my-charts with a client certificate",
+			&apprepov1alpha1.AppRepository{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "AppRepository",
+					APIVersion: "kubeapps.com/v1alpha1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-charts",
+					Namespace: "kubeapps",
+					Labels: map[string]string{
+						"name":       "my-charts",
+						"created-by": "kubeapps",
+					},
+				},
+				Spec: apprepov1alpha1.AppRepositorySpec{
+					Type: "helm",
+					URL:  "https://charts.acme.com/my-charts",
+					Auth: apprepov1alpha1.AppRepositoryAuth{
+						ClientCert: &apprepov1alpha1.AppRepositoryClientCert{
+							SecretRef: corev1.LocalObjectReference{Name: "client-cert-test"},
+						},
+					},
+				},
+			},
+			batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "apprepo-sync-my-charts-",
+					Namespace:    "kubeapps",
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(
+							&apprepov1alpha1.AppRepository{ObjectMeta: metav1.ObjectMeta{Name: "my-charts"}},
+							schema.GroupVersionKind{
+								Group:   apprepov1alpha1.SchemeGroupVersion.Group,
+								Version: apprepov1alpha1.SchemeGroupVersion.Version,
+								Kind:    "AppRepository",
+							},
+						),
+					},
+				},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{"apprepositories.kubeapps.com/repo-name": "my-charts"},
+						},
+						Spec: corev1.PodSpec{
+							RestartPolicy: "OnFailure",
+							Containers: []corev1.Container{
+								{
+									Name:  "sync",
+									Image: repoSyncImage,
+									// Here our token butler is supposed to be executed
+									Command: []string{"/hub-token-butler"},
+									Args: []string{
+										"sync",
+										"--mongo-url=mongodb.kubeapps",
+										"--mongo-user=root",
+										"my-charts",
+										"https://charts.acme.com/my-charts",
+									},
+									Env: []corev1.EnvVar{
+										{
+											Name: "MONGO_PASSWORD",
+											ValueFrom: &corev1.EnvVarSource{
+												SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mongodb"}, Key: "mongodb-root-password"}},
+										},
+									},
+									VolumeMounts: nil,
+								},
+							},
+							Volumes: nil,
+						},
+					},
+				},
+			},
+			"",
+		},
+		{
+			"my-charts with a credential provider",
+			&apprepov1alpha1.AppRepository{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "AppRepository",
+					APIVersion: "kubeapps.com/v1alpha1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-charts",
+					Namespace: "kubeapps",
+					Labels: map[string]string{
+						"name":       "my-charts",
+						"created-by": "kubeapps",
+					},
+				},
+				Spec: apprepov1alpha1.AppRepositorySpec{
+					Type: "helm",
+					URL:  "https://charts.acme.com/my-charts",
+					Auth: apprepov1alpha1.AppRepositoryAuth{
+						CredentialProvider: &apprepov1alpha1.AppRepositoryCredentialProvider{
+							Name:      "oauth2",
+							SecretRef: corev1.LocalObjectReference{Name: "credentials-test"},
+						},
+					},
+				},
+			},
+			batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "apprepo-sync-my-charts-",
+					Namespace:    "kubeapps",
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(
+							&apprepov1alpha1.AppRepository{ObjectMeta: metav1.ObjectMeta{Name: "my-charts"}},
+							schema.GroupVersionKind{
+								Group:   apprepov1alpha1.SchemeGroupVersion.Group,
+								Version: apprepov1alpha1.SchemeGroupVersion.Version,
+								Kind:    "AppRepository",
+							},
+						),
+					},
+				},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{"apprepositories.kubeapps.com/repo-name": "my-charts"},
+						},
+						Spec: corev1.PodSpec{
+							RestartPolicy: "OnFailure",
+							Containers: []corev1.Container{
+								{
+									Name:  "sync",
+									Image: repoSyncImage,
+									// Here our token butler is supposed to be executed
+									Command: []string{"/hub-token-butler"},
+									Args: []string{
+										"sync",
+										"--mongo-url=mongodb.kubeapps",
+										"--mongo-user=root",
+										"my-charts",
+										"https://charts.acme.com/my-charts",
+									},
+									Env: []corev1.EnvVar{
+										{
+											Name: "MONGO_PASSWORD",
+											ValueFrom: &corev1.EnvVarSource{
+												SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mongodb"}, Key: "mongodb-root-password"}},
+										},
+										{Name: "CREDENTIAL_PROVIDER", Value: "oauth2"},
+										{Name: "CREDENTIALS_DIR", Value: "/etc/credentials"},
+									},
+									VolumeMounts: []corev1.VolumeMount{{
+										Name:      "credentials",
+										ReadOnly:  true,
+										MountPath: "/etc/credentials",
+									}},
+								},
+							},
+							Volumes: []corev1.Volume{{
+								Name: "credentials",
+								VolumeSource: corev1.VolumeSource{
+									Secret: &corev1.SecretVolumeSource{
+										SecretName: "credentials-test",
+									},
+								},
+							}},
+						},
+					},
+				},
+			},
+			"",
+		},
 		{
 			"my-charts with a customCA and auth header",
 			&apprepov1alpha1.AppRepository{
@@ -755,3 +923,53 @@ func Test_newCleanupJob(t *testing.T) {
 		})
 	}
 }
+
+func Test_apprepoTransportEnvVars(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name      string
+		transport *apprepov1alpha1.AppRepositoryTransport
+		expected  []corev1.EnvVar
+	}{
+		{
+			"proxy",
+			&apprepov1alpha1.AppRepositoryTransport{ProxyURL: "http://proxy.acme.com:3128", NoProxy: ".acme.com"},
+			[]corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "http://proxy.acme.com:3128"},
+				{Name: "http_proxy", Value: "http://proxy.acme.com:3128"},
+				{Name: "HTTPS_PROXY", Value: "http://proxy.acme.com:3128"},
+				{Name: "https_proxy", Value: "http://proxy.acme.com:3128"},
+				{Name: "NO_PROXY", Value: ".acme.com"},
+				{Name: "no_proxy", Value: ".acme.com"},
+			},
+		},
+		{
+			"disabled proxy",
+			&apprepov1alpha1.AppRepositoryTransport{DisableProxy: true, NoProxy: ".acme.com"},
+			[]corev1.EnvVar{
+				{Name: "NO_PROXY", Value: "*"},
+				{Name: "no_proxy", Value: "*"},
+			},
+		},
+		{
+			"connections",
+			&apprepov1alpha1.AppRepositoryTransport{TimeoutSeconds: 60, IdleConnTimeoutSeconds: 30, MaxIdleConnsPerHost: 4, EnableHTTP2: &disabled},
+			[]corev1.EnvVar{
+				{Name: "HTTP_TIMEOUT_SECONDS", Value: "60"},
+				{Name: "HTTP_IDLE_CONN_TIMEOUT_SECONDS", Value: "30"},
+				{Name: "HTTP_MAX_IDLE_CONNS_PER_HOST", Value: "4"},
+				{Name: "HTTP_ENABLE_HTTP2", Value: "false"},
+				{Name: "GODEBUG", Value: "http2client=0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := apprepoTransportEnvVars(tt.transport)
+			if diff := deep.Equal(tt.expected, result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
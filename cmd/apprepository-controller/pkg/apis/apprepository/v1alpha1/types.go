@@ -36,17 +36,36 @@ type AppRepository struct {
 
 // AppRepositorySpec is the spec for an AppRepository resource
 type AppRepositorySpec struct {
-	Type               string                 `json:"type"`
-	URL                string                 `json:"url"`
+	// Type of the repository, either "helm" (default), "oci" or "git"
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Mirrors are URLs of repositories with the same content as URL. They are used
+	// if URL or one of the hosts serving the charts is not reachable.
+	Mirrors            []string               `json:"mirrors,omitempty"`
 	Auth               AppRepositoryAuth      `json:"auth,omitempty"`
 	ResyncRequests     uint                   `json:"resyncRequests"`
 	SyncJobPodTemplate corev1.PodTemplateSpec `json:"syncJobPodTemplate"`
+	// Verification enables the verification of downloaded charts if set, it is not supported for
+	// repositories of type "git"
+	Verification *AppRepositoryVerification `json:"verification,omitempty"`
+	// Git configures the checkout of repositories of type "git"
+	Git *AppRepositoryGit `json:"git,omitempty"`
+	// Policy restricts the charts which can be installed from the repository
+	Policy *AppRepositoryPolicy `json:"policy,omitempty"`
+	// Transport configures the proxy and the HTTP connections of requests to the repository
+	Transport *AppRepositoryTransport `json:"transport,omitempty"`
 }
 
 // AppRepositoryAuth is the auth for an AppRepository resource
 type AppRepositoryAuth struct {
 	Header   *AppRepositoryAuthHeader `json:"header,omitempty"`
 	CustomCA *AppRepositoryCustomCA   `json:"customCA,omitempty"`
+	// ClientCert is the client certificate for repositories requiring mutual TLS. It is only presented
+	// by the ui-backend, the chart-repo sync job does not support client certificates.
+	ClientCert *AppRepositoryClientCert `json:"clientCert,omitempty"`
+	// CredentialProvider creates the authorization header, e.g. from short-lived access tokens.
+	// It takes precedence over Header.
+	CredentialProvider *AppRepositoryCredentialProvider `json:"credentialProvider,omitempty"`
 }
 
 type AppRepositoryAuthHeader struct {
@@ -59,6 +78,93 @@ type AppRepositoryCustomCA struct {
 	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
 }
 
+type AppRepositoryClientCert struct {
+	// Selects a secret in the pod's namespace containing the PEM encoded certificate as "tls.crt"
+	// and its private key as "tls.key", e.g. a secret of type kubernetes.io/tls
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+type AppRepositoryCredentialProvider struct {
+	// Name of the provider, e.g. "static", "gcp" or "oauth2"
+	Name string `json:"name"`
+	// Selects a secret in the pod's namespace containing the credentials, the keys depend on the provider
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// AppRepositoryGit configures which revision of a git repository is checked out and where
+// the charts are located in it.
+type AppRepositoryGit struct {
+	// Ref is a branch, tag or commit, the default branch of the repository if empty
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory containing the chart directories, the root of the repository if empty
+	Path string `json:"path,omitempty"`
+}
+
+// AppRepositoryPolicy restricts the charts and chart versions which can be installed from an AppRepository.
+// Chart name patterns use the syntax of path.Match, e.g. "postgres*".
+type AppRepositoryPolicy struct {
+	// AllowedCharts are the patterns of the chart names which can be installed, all charts if empty
+	AllowedCharts []string `json:"allowedCharts,omitempty"`
+	// DeniedCharts are the patterns of the chart names which cannot be installed, they take precedence over AllowedCharts
+	DeniedCharts []string `json:"deniedCharts,omitempty"`
+	// MinVersion is the lowest chart version which can be installed
+	MinVersion string `json:"minVersion,omitempty"`
+	// MaxVersion is the highest chart version which can be installed
+	MaxVersion string `json:"maxVersion,omitempty"`
+	// DeniedVersions are chart versions which cannot be installed, e.g. because of known bugs
+	DeniedVersions []AppRepositoryDeniedVersion `json:"deniedVersions,omitempty"`
+	// BlockDeprecated denies the installation of charts which are marked as deprecated
+	BlockDeprecated bool `json:"blockDeprecated,omitempty"`
+}
+
+type AppRepositoryDeniedVersion struct {
+	// Chart is the pattern of the chart names the versions are denied for
+	Chart string `json:"chart"`
+	// Versions is a semver constraint like "8.2.0" or ">=8.2.0 <8.2.3". Pre-releases are only
+	// matched by constraints which contain a pre-release, e.g. ">=8.2.0-0 <8.2.3".
+	Versions string `json:"versions"`
+	// Reason is shown to users if an installation is denied
+	Reason string `json:"reason,omitempty"`
+}
+
+// AppRepositoryTransport configures the HTTP connections to an AppRepository. Settings which are
+// not set keep their defaults, e.g. the proxy of the environment and a timeout of 180 seconds.
+type AppRepositoryTransport struct {
+	// ProxyURL is the proxy for all requests to the repository, e.g. "http://proxy.acme.com:3128".
+	// The proxy of the environment is used if it is empty.
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// NoProxy is a comma separated list of hosts, domains and CIDRs which are requested without proxy.
+	// It replaces the no proxy setting of the environment if it is set.
+	NoProxy string `json:"noProxy,omitempty"`
+	// DisableProxy requests the repository without proxy, even if a proxy is set in the environment
+	DisableProxy bool `json:"disableProxy,omitempty"`
+	// TimeoutSeconds limits the duration of a request including reading the response
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+	// TLSHandshakeTimeoutSeconds limits the duration of TLS handshakes
+	TLSHandshakeTimeoutSeconds int64 `json:"tlsHandshakeTimeoutSeconds,omitempty"`
+	// ResponseHeaderTimeoutSeconds limits the time waiting for the response headers after sending a request
+	ResponseHeaderTimeoutSeconds int64 `json:"responseHeaderTimeoutSeconds,omitempty"`
+	// IdleConnTimeoutSeconds is the time after which idle connections are closed
+	IdleConnTimeoutSeconds int64 `json:"idleConnTimeoutSeconds,omitempty"`
+	// MaxIdleConns limits the number of idle connections
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+	// MaxIdleConnsPerHost limits the number of idle connections to each host
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty"`
+	// EnableHTTP2 enables or disables HTTP/2, only HTTP/1.1 is used by default
+	EnableHTTP2 *bool `json:"enableHTTP2,omitempty"`
+}
+
+// AppRepositoryVerification configures the verification of charts downloaded from an AppRepository.
+// Charts must have a provenance file signed by a key of the keyring and must match the digest of the repo index.
+type AppRepositoryVerification struct {
+	Keyring AppRepositoryKeyring `json:"keyring"`
+}
+
+type AppRepositoryKeyring struct {
+	// Selects a key of a secret in the pod's namespace containing the public PGP keyring
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
 // AppRepositoryStatus is the status for an AppRepository resource
 type AppRepositoryStatus struct {
 	Status string `json:"status"`
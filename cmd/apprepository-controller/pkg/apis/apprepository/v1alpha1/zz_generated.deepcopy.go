@@ -73,6 +73,24 @@ func (in *AppRepositoryAuth) DeepCopyInto(out *AppRepositoryAuth) {
 			(*in).DeepCopyInto(*out)
 		}
 	}
+	if in.ClientCert != nil {
+		in, out := &in.ClientCert, &out.ClientCert
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryClientCert)
+			**out = **in
+		}
+	}
+	if in.CredentialProvider != nil {
+		in, out := &in.CredentialProvider, &out.CredentialProvider
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryCredentialProvider)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -103,6 +121,40 @@ func (in *AppRepositoryAuthHeader) DeepCopy() *AppRepositoryAuthHeader {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryClientCert) DeepCopyInto(out *AppRepositoryClientCert) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryClientCert.
+func (in *AppRepositoryClientCert) DeepCopy() *AppRepositoryClientCert {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryClientCert)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryCredentialProvider) DeepCopyInto(out *AppRepositoryCredentialProvider) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryCredentialProvider.
+func (in *AppRepositoryCredentialProvider) DeepCopy() *AppRepositoryCredentialProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryCredentialProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositoryCustomCA) DeepCopyInto(out *AppRepositoryCustomCA) {
 	*out = *in
@@ -120,6 +172,55 @@ func (in *AppRepositoryCustomCA) DeepCopy() *AppRepositoryCustomCA {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryDeniedVersion) DeepCopyInto(out *AppRepositoryDeniedVersion) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryDeniedVersion.
+func (in *AppRepositoryDeniedVersion) DeepCopy() *AppRepositoryDeniedVersion {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryDeniedVersion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryGit) DeepCopyInto(out *AppRepositoryGit) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryGit.
+func (in *AppRepositoryGit) DeepCopy() *AppRepositoryGit {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryGit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryKeyring) DeepCopyInto(out *AppRepositoryKeyring) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryKeyring.
+func (in *AppRepositoryKeyring) DeepCopy() *AppRepositoryKeyring {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryKeyring)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositoryList) DeepCopyInto(out *AppRepositoryList) {
 	*out = *in
@@ -153,10 +254,83 @@ func (in *AppRepositoryList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryPolicy) DeepCopyInto(out *AppRepositoryPolicy) {
+	*out = *in
+	if in.AllowedCharts != nil {
+		in, out := &in.AllowedCharts, &out.AllowedCharts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedCharts != nil {
+		in, out := &in.DeniedCharts, &out.DeniedCharts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedVersions != nil {
+		in, out := &in.DeniedVersions, &out.DeniedVersions
+		*out = make([]AppRepositoryDeniedVersion, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryPolicy.
+func (in *AppRepositoryPolicy) DeepCopy() *AppRepositoryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositorySpec) DeepCopyInto(out *AppRepositorySpec) {
 	*out = *in
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.Auth.DeepCopyInto(&out.Auth)
+	in.SyncJobPodTemplate.DeepCopyInto(&out.SyncJobPodTemplate)
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryVerification)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryGit)
+			**out = **in
+		}
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryPolicy)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Transport != nil {
+		in, out := &in.Transport, &out.Transport
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(AppRepositoryTransport)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -185,3 +359,45 @@ func (in *AppRepositoryStatus) DeepCopy() *AppRepositoryStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryTransport) DeepCopyInto(out *AppRepositoryTransport) {
+	*out = *in
+	if in.EnableHTTP2 != nil {
+		in, out := &in.EnableHTTP2, &out.EnableHTTP2
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(bool)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryTransport.
+func (in *AppRepositoryTransport) DeepCopy() *AppRepositoryTransport {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryTransport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryVerification) DeepCopyInto(out *AppRepositoryVerification) {
+	*out = *in
+	in.Keyring.DeepCopyInto(&out.Keyring)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryVerification.
+func (in *AppRepositoryVerification) DeepCopy() *AppRepositoryVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryVerification)
+	in.DeepCopyInto(out)
+	return out
+}
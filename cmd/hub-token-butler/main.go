@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/golang/glog"
 
-	"github.com/gardener/potter-hub/pkg/util"
+	"github.com/gardener/potter-hub/pkg/credentials"
 )
 
-const authHeaderKey = "AUTHORIZATION_HEADER"
+const (
+	authHeaderKey         = "AUTHORIZATION_HEADER"
+	credentialProviderKey = "CREDENTIAL_PROVIDER"
+	credentialsDirKey     = "CREDENTIALS_DIR"
+)
 
 func main() {
 	glog.Infoln("starting hub-chart-repo")
@@ -34,31 +41,54 @@ func main() {
 	glog.Infoln("hub-chart-repo finished successfully")
 }
 
+// prepareAuthorizationHeader sets the authorization header of chart-repo. It is created by the
+// credential provider if one is selected, otherwise the configured header is passed to the
+// provider of the header, e.g. to exchange a GCP service account key for an access token.
 func prepareAuthorizationHeader() {
-	authorizationHeader := os.Getenv(authHeaderKey)
-	if len(authorizationHeader) > 0 {
-		splittedAuthorizationHeader := strings.Fields(authorizationHeader)
-		if len(splittedAuthorizationHeader) != 2 {
+	var provider credentials.Provider
+	var err error
+
+	if providerName := os.Getenv(credentialProviderKey); providerName != "" {
+		glog.Infof("Using credential provider %s", providerName)
+		provider, err = newCredentialProvider(providerName, os.Getenv(credentialsDirKey))
+	} else if authorizationHeader := os.Getenv(authHeaderKey); len(authorizationHeader) > 0 {
+		if len(strings.Fields(authorizationHeader)) != 2 {
 			glog.Fatalln("invalid authorization header. expected \"<type> <credentials>\"")
 		}
+		provider, err = credentials.FromAuthorizationHeader(authorizationHeader)
+	} else {
+		glog.Infoln("Authorization header not set")
+		return
+	}
+	if err != nil {
+		glog.Fatalln("Invalid credentials. ", err)
+	}
 
-		if splittedAuthorizationHeader[0] == "Basic" {
-			username, password, err := util.DecodeBasicAuthCredentials(splittedAuthorizationHeader[1])
-			if err != nil {
-				glog.Fatalln("Invalid authorization header. ", err)
-			}
+	authorizationHeader, err := provider.AuthorizationHeader(context.Background())
+	if err != nil {
+		glog.Fatalln("Could not create authorization header. ", err)
+	}
+	os.Setenv(authHeaderKey, authorizationHeader)
+	glog.Infoln("Successfully set authorization header")
+}
 
-			if username == "_json_key" {
-				glog.Infoln("Starting gcloud oauth flow")
-				accessToken, err := util.GetGCloudAccessToken(password)
-				if err != nil {
-					glog.Fatal("Gcloud oauth flow failed. ", err)
-				}
-				os.Setenv(authHeaderKey, "Bearer "+accessToken)
-				glog.Infoln("Successfully performed gcloud oauth flow and set access token in authorization header")
-			}
+// newCredentialProvider creates the provider from the credentials in the directory, one file per key
+func newCredentialProvider(name, dir string) (credentials.Provider, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{}
+	for _, file := range files {
+		// Skip the hidden files and directories of mounted secrets
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
 		}
-	} else {
-		glog.Infoln("Authorization header not set")
+		value, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		data[file.Name()] = value
 	}
+	return credentials.New(name, data)
 }
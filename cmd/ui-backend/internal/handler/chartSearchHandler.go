@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kubeapps/common/response"
+
+	chartUtils "github.com/gardener/potter-hub/pkg/chart"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	utils "github.com/gardener/potter-hub/pkg/util"
+)
+
+// ChartSearchHandler searches the charts of the app repositories
+type ChartSearchHandler struct {
+	ChartClient chartUtils.Searcher
+}
+
+// searchMeta contains the number of pages of paginated search results
+type searchMeta struct {
+	TotalPages int `json:"totalPages"`
+}
+
+// SearchCharts returns the charts matching the query params "query", "repo" and "keyword". The
+// charts are paginated if the query param "size" is set, "page" selects a page starting at 1.
+func (h *ChartSearchHandler) SearchCharts(w http.ResponseWriter, req *http.Request) {
+	query := chartUtils.SearchQuery{
+		Query:   req.URL.Query().Get("query"),
+		Repo:    req.URL.Query().Get("repo"),
+		Keyword: req.URL.Query().Get("keyword"),
+	}
+	page, err := positiveQueryParam(req, "page", 1)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+	size, err := positiveQueryParam(req, "size", 0)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+
+	results, err := h.ChartClient.SearchCharts(req.Context(), query)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	if size == 0 {
+		response.NewDataResponse(results).Write(w)
+		return
+	}
+
+	total := len(results.Charts)
+	start := total
+	if page-1 <= total/size {
+		start = (page - 1) * size
+	}
+	end := start + size
+	if end > total {
+		end = total
+	}
+	results.Charts = results.Charts[start:end]
+	response.NewDataResponseWithMeta(results, searchMeta{TotalPages: (total + size - 1) / size}).Write(w)
+}
+
+// positiveQueryParam returns the positive integer of the query param or the default if it is not set
+func positiveQueryParam(req *http.Request, name string, defaultValue int) (int, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		return 0, errorUtils.BadRequest.NewErrorf("Invalid %s %q", name, value)
+	}
+	return i, nil
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrusTest "github.com/sirupsen/logrus/hooks/test"
+
+	chartUtils "github.com/gardener/potter-hub/pkg/chart"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+// fakeSearcher returns the results or the error and records the query of the latest search
+type fakeSearcher struct {
+	results *chartUtils.SearchResults
+	err     error
+	query   chartUtils.SearchQuery
+}
+
+func (s *fakeSearcher) SearchCharts(ctx context.Context, query chartUtils.SearchQuery) (*chartUtils.SearchResults, error) {
+	s.query = query
+	if s.err != nil {
+		return nil, s.err
+	}
+	// The handler may paginate the charts, the results of the searcher are not changed
+	charts := append([]chartUtils.SearchResult{}, s.results.Charts...)
+	return &chartUtils.SearchResults{Charts: charts, UnavailableRepositories: s.results.UnavailableRepositories}, nil
+}
+
+func TestSearchCharts(t *testing.T) {
+	searchResults := &chartUtils.SearchResults{
+		Charts: []chartUtils.SearchResult{
+			{Repository: "stable", Name: "foo", Version: "1.0.0", Versions: []string{"1.0.0"}, Score: 3},
+			{Repository: "stable", Name: "bar", Version: "2.0.0", Versions: []string{"2.0.0"}, Score: 2},
+			{Repository: "incubator", Name: "baz", Version: "0.1.0", Versions: []string{"0.1.0"}, Score: 1},
+		},
+		UnavailableRepositories: []string{"broken"},
+	}
+
+	testCases := []struct {
+		name          string
+		requestQuery  string
+		searchErr     error
+		expectedQuery chartUtils.SearchQuery
+		statusCode    int
+		responseBody  string
+	}{
+		{
+			name:          "query params",
+			requestQuery:  "?query=foo+bar&repo=stable&keyword=database",
+			expectedQuery: chartUtils.SearchQuery{Query: "foo bar", Repo: "stable", Keyword: "database"},
+			statusCode:    200,
+			responseBody:  `{"data":{"charts":[{"repository":"stable","name":"foo","version":"1.0.0","versions":["1.0.0"],"score":3},{"repository":"stable","name":"bar","version":"2.0.0","versions":["2.0.0"],"score":2},{"repository":"incubator","name":"baz","version":"0.1.0","versions":["0.1.0"],"score":1}],"unavailableRepositories":["broken"]}}`,
+		},
+		{
+			name:         "first page",
+			requestQuery: "?size=2",
+			statusCode:   200,
+			responseBody: `{"data":{"charts":[{"repository":"stable","name":"foo","version":"1.0.0","versions":["1.0.0"],"score":3},{"repository":"stable","name":"bar","version":"2.0.0","versions":["2.0.0"],"score":2}],"unavailableRepositories":["broken"]},"meta":{"totalPages":2}}`,
+		},
+		{
+			name:         "last page",
+			requestQuery: "?page=2&size=2",
+			statusCode:   200,
+			responseBody: `{"data":{"charts":[{"repository":"incubator","name":"baz","version":"0.1.0","versions":["0.1.0"],"score":1}],"unavailableRepositories":["broken"]},"meta":{"totalPages":2}}`,
+		},
+		{
+			name:         "page after the last page",
+			requestQuery: "?page=9223372036854775807&size=2",
+			statusCode:   200,
+			responseBody: `{"data":{"charts":[],"unavailableRepositories":["broken"]},"meta":{"totalPages":2}}`,
+		},
+		{
+			name:         "page without size",
+			requestQuery: "?page=2",
+			statusCode:   200,
+			responseBody: `{"data":{"charts":[{"repository":"stable","name":"foo","version":"1.0.0","versions":["1.0.0"],"score":3},{"repository":"stable","name":"bar","version":"2.0.0","versions":["2.0.0"],"score":2},{"repository":"incubator","name":"baz","version":"0.1.0","versions":["0.1.0"],"score":1}],"unavailableRepositories":["broken"]}}`,
+		},
+		{
+			name:         "invalid page",
+			requestQuery: "?page=0&size=2",
+			statusCode:   400,
+		},
+		{
+			name:         "invalid size",
+			requestQuery: "?size=many",
+			statusCode:   400,
+		},
+		{
+			name:       "unauthorized search",
+			searchErr:  errors.New("Unable to list app repositories in namespace hub: Unauthorized"),
+			statusCode: 403,
+		},
+		{
+			name:          "app repository not found",
+			requestQuery:  "?repo=missing",
+			searchErr:     errors.New("app repository missing not found"),
+			expectedQuery: chartUtils.SearchQuery{Repo: "missing"},
+			statusCode:    404,
+		},
+		{
+			name:       "failed search",
+			searchErr:  errors.New("Unable to list app repositories in namespace hub: connection refused"),
+			statusCode: 500,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			searcher := &fakeSearcher{results: searchResults, err: tc.searchErr}
+			handler := ChartSearchHandler{ChartClient: searcher}
+
+			req := httptest.NewRequest("GET", "http://foo.bar/charts"+tc.requestQuery, nil)
+			nullLogger, _ := logrusTest.NewNullLogger()
+			req = req.WithContext(context.WithValue(req.Context(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)}))
+			response := httptest.NewRecorder()
+
+			handler.SearchCharts(response, req)
+
+			if response.Code != tc.statusCode {
+				t.Errorf("got: %d, want: %d", response.Code, tc.statusCode)
+			}
+			if tc.responseBody != "" && response.Body.String() != tc.responseBody {
+				t.Errorf("got: %s, want: %s", response.Body.String(), tc.responseBody)
+			}
+			if !reflect.DeepEqual(searcher.query, tc.expectedQuery) {
+				t.Errorf("got: %+v, want: %+v", searcher.query, tc.expectedQuery)
+			}
+		})
+	}
+}
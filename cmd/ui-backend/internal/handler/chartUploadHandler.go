@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/yaml"
+
+	chartUtils "github.com/gardener/potter-hub/pkg/chart"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+	"github.com/gardener/potter-hub/pkg/proxy"
+	utils "github.com/gardener/potter-hub/pkg/util"
+)
+
+const (
+	// DefaultChartUploadMaxBytes is the default size limit of chart upload requests
+	DefaultChartUploadMaxBytes = 10 * 1024 * 1024
+	// DefaultChartUploadMaxDecompressedBytes is the default size limit of decompressed uploaded chart archives
+	DefaultChartUploadMaxDecompressedBytes = 100 * 1024 * 1024
+
+	chartUploadChartField       = "chart"
+	chartUploadReleaseNameField = "releaseName"
+	chartUploadValuesField      = "values"
+	// The values layers and overrides can be repeated, they are applied in order
+	chartUploadValuesLayersField = "valuesLayers"
+	chartUploadSetField          = "set"
+	chartUploadSetStringField    = "setString"
+
+	// Form fields of the release options
+	chartUploadWaitField           = "wait"
+	chartUploadAtomicField         = "atomic"
+	chartUploadTimeoutSecondsField = "timeoutSeconds"
+	chartUploadDisableHooksField   = "disableHooks"
+	chartUploadForceField          = "force"
+	chartUploadPatchesField        = "patches"
+	chartUploadReuseValuesField    = "reuseValues"
+	chartUploadResetValuesField    = "resetValues"
+
+	// chartUploadMaxMemory is the part of an upload kept in memory, the rest is buffered on disk
+	chartUploadMaxMemory = 1024 * 1024
+)
+
+// parseChartUpload reads a multipart request with the chart archive in the form field "chart"
+// and the values as YAML in the form field "values", followed by the layers in the form fields "valuesLayers" and the
+// overrides in the form fields "set" and "setString". The release options are read from the form fields "wait",
+// "atomic", "timeoutSeconds", "disableHooks", "force", "reuseValues" and "resetValues", the patches as YAML from
+// the form field "patches".
+func (h *HelmProxy) parseChartUpload(w http.ResponseWriter, req *http.Request) (*chartUtils.Details, *proxy.ReleaseOptions, *chart.Chart, error) {
+	if !h.ChartUploadEnabled {
+		return nil, nil, nil, errorUtils.Forbidden.NewError("Installing uploaded charts is disabled")
+	}
+
+	body := &limitedBody{ReadCloser: req.Body, remaining: h.ChartUploadMaxBytes}
+	req.Body = body
+	if err := req.ParseMultipartForm(chartUploadMaxMemory); err != nil {
+		if body.exceeded {
+			// Do not read the rest of the upload
+			w.Header().Set("Connection", "close")
+			return nil, nil, nil, errorUtils.RequestEntityTooLarge.NewErrorf("Chart upload exceeds the limit of %d bytes", h.ChartUploadMaxBytes)
+		}
+		return nil, nil, nil, errorUtils.BadRequest.New(errors.Wrap(err, "Could not parse chart upload"))
+	}
+	defer func() {
+		_ = req.MultipartForm.RemoveAll()
+	}()
+
+	file, _, err := req.FormFile(chartUploadChartField)
+	if err != nil {
+		return nil, nil, nil, errorUtils.BadRequest.New(errors.Wrapf(err, "A chart archive is required in the form field %q", chartUploadChartField))
+	}
+	defer file.Close()
+
+	if err := checkDecompressedSize(file, h.ChartUploadMaxDecompressedBytes); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "Could not read uploaded chart archive")
+	}
+	ch, err := loader.LoadArchive(file)
+	if err != nil {
+		return nil, nil, nil, errorUtils.UnprocessableEntity.New(errors.Wrap(err, "Could not load uploaded chart archive"))
+	}
+
+	options, err := parseReleaseOptionsForm(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	details := &chartUtils.Details{
+		ChartName:    ch.Name(),
+		ReleaseName:  req.FormValue(chartUploadReleaseNameField),
+		Version:      ch.Metadata.Version,
+		Values:       req.FormValue(chartUploadValuesField),
+		ValuesLayers: req.MultipartForm.Value[chartUploadValuesLayersField],
+		Set:          req.MultipartForm.Value[chartUploadSetField],
+		SetString:    req.MultipartForm.Value[chartUploadSetStringField],
+	}
+	if err := details.MergeValues(); err != nil {
+		return nil, nil, nil, err
+	}
+	return details, options, ch, nil
+}
+
+// limitedBody limits the size of a request body and records if the limit was exceeded
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errors.New("request body exceeds the size limit")
+	}
+	// Read one byte more than allowed to detect bodies exceeding the limit
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.exceeded = true
+	return n, errors.New("request body exceeds the size limit")
+}
+
+// checkDecompressedSize returns an error if the gzip compressed chart archive is larger than the
+// limit when decompressed. The helm loader reads archives without any limit.
+func checkDecompressedSize(archive io.Reader, maxBytes int64) error {
+	decompressed, err := gzip.NewReader(archive)
+	if err != nil {
+		return errorUtils.UnprocessableEntity.New(errors.Wrap(err, "Could not load uploaded chart archive"))
+	}
+	defer decompressed.Close()
+
+	size, err := io.Copy(ioutil.Discard, io.LimitReader(decompressed, maxBytes+1))
+	if err != nil {
+		return errorUtils.UnprocessableEntity.New(errors.Wrap(err, "Could not load uploaded chart archive"))
+	}
+	if size > maxBytes {
+		return errorUtils.RequestEntityTooLarge.NewErrorf("Uploaded chart archive exceeds the limit of %d bytes when decompressed", maxBytes)
+	}
+	return nil
+}
+
+// parseReleaseOptionsForm reads the release options from the form fields of a chart upload
+func parseReleaseOptionsForm(req *http.Request) (*proxy.ReleaseOptions, error) {
+	options := &proxy.ReleaseOptions{}
+	flags := []struct {
+		field string
+		value *bool
+	}{
+		{chartUploadWaitField, &options.Wait},
+		{chartUploadAtomicField, &options.Atomic},
+		{chartUploadDisableHooksField, &options.DisableHooks},
+		{chartUploadForceField, &options.Force},
+		{chartUploadReuseValuesField, &options.ReuseValues},
+		{chartUploadResetValuesField, &options.ResetValues},
+	}
+	for _, flag := range flags {
+		value := req.FormValue(flag.field)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errorUtils.BadRequest.NewErrorf("Invalid value %q of the form field %q", value, flag.field)
+		}
+		*flag.value = parsed
+	}
+
+	if value := req.FormValue(chartUploadTimeoutSecondsField); value != "" {
+		timeoutSeconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errorUtils.BadRequest.NewErrorf("Invalid value %q of the form field %q", value, chartUploadTimeoutSecondsField)
+		}
+		options.TimeoutSeconds = timeoutSeconds
+	}
+
+	if value := req.FormValue(chartUploadPatchesField); value != "" {
+		if err := yaml.Unmarshal([]byte(value), &options.Patches); err != nil {
+			return nil, errorUtils.BadRequest.New(errors.Wrapf(err, "Invalid patches in the form field %q", chartUploadPatchesField))
+		}
+	}
+	return options, nil
+}
+
+// CreateReleaseFromUpload creates a new release in the namespace given as Param from an uploaded chart archive
+func (h *HelmProxy) CreateReleaseFromUpload(w http.ResponseWriter, req *http.Request, params Params) {
+	chartDetails, options, ch, err := h.parseChartUpload(w, req)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+	if chartDetails.ReleaseName == "" {
+		err = errorUtils.BadRequest.NewErrorf("A release name is required in the form field %q", chartUploadReleaseNameField)
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+
+	logUtils.GetLogger(req.Context()).Infof("Installing uploaded chart %s %s", chartDetails.ChartName, chartDetails.Version)
+	h.installRelease(w, req, params, chartDetails, options, ch)
+}
+
+// UpgradeReleaseFromUpload upgrades a release in the namespace given as Param to an uploaded chart archive
+func (h *HelmProxy) UpgradeReleaseFromUpload(w http.ResponseWriter, req *http.Request, params Params) {
+	chartDetails, options, ch, err := h.parseChartUpload(w, req)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+	chartDetails.ReleaseName = params["releaseName"]
+
+	logUtils.GetLogger(req.Context()).Infof("Upgrading Helm Release to uploaded chart %s %s", chartDetails.ChartName, chartDetails.Version)
+	h.upgradeRelease(w, req, params, chartDetails, options, ch)
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrusTest "github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/gardener/potter-hub/pkg/auth"
+	authFake "github.com/gardener/potter-hub/pkg/auth/fake"
+	chartFake "github.com/gardener/potter-hub/pkg/chart/fake"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+	proxy2 "github.com/gardener/potter-hub/pkg/proxy"
+	proxyFake "github.com/gardener/potter-hub/pkg/proxy/fake"
+)
+
+func chartArchive(t *testing.T) []byte {
+	dir, err := ioutil.TempDir("", "chart-upload")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "foo", Version: "1.0.0"}}
+	path, err := chartutil.Save(ch, dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return data
+}
+
+func chartUploadBody(t *testing.T, fields map[string]string, archive []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if archive != nil {
+		part, err := writer.CreateFormFile(chartUploadChartField, "foo-1.0.0.tgz")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := part.Write(archive); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return body, writer.FormDataContentType()
+}
+
+// gzipBomb returns a small gzip compressed archive which is large when decompressed
+func gzipBomb(t *testing.T, size int) []byte {
+	data := &bytes.Buffer{}
+	writer := gzip.NewWriter(data)
+	if _, err := writer.Write(make([]byte, size)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return data.Bytes()
+}
+
+func TestChartUpload(t *testing.T) {
+	archive := chartArchive(t)
+
+	testCases := []struct {
+		name              string
+		action            string
+		uploadDisabled    bool
+		maxBytes          int64
+		maxDecompressed   int64
+		forbiddenActions  []auth.Action
+		fields            map[string]string
+		archive           []byte
+		existingReleases  []release.Release
+		statusCode        int
+		remainingReleases []release.Release
+	}{
+		{
+			name:              "install",
+			action:            "create",
+			fields:            map[string]string{"releaseName": "foobar", "values": "foo: bar"},
+			archive:           archive,
+			statusCode:        200,
+			remainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		},
+		{
+			name:              "upgrade",
+			action:            "upgrade",
+			archive:           archive,
+			existingReleases:  []release.Release{{Name: "foobar", Namespace: "default"}},
+			statusCode:        200,
+			remainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		},
+		{
+			name:              "upload disabled",
+			action:            "create",
+			uploadDisabled:    true,
+			fields:            map[string]string{"releaseName": "foobar"},
+			archive:           archive,
+			statusCode:        403,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "upload too large",
+			action:            "create",
+			maxBytes:          int64(len(archive) / 2),
+			fields:            map[string]string{"releaseName": "foobar"},
+			archive:           archive,
+			statusCode:        413,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "decompressed archive too large",
+			action:            "create",
+			maxDecompressed:   1024 * 1024,
+			fields:            map[string]string{"releaseName": "foobar"},
+			archive:           gzipBomb(t, 2*1024*1024),
+			statusCode:        413,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:   "forbidden actions",
+			action: "create",
+			forbiddenActions: []auth.Action{
+				{APIVersion: "v1", Resource: "pods", Namespace: "default", ClusterWide: false, Verbs: []string{"create"}},
+			},
+			fields:            map[string]string{"releaseName": "foobar"},
+			archive:           archive,
+			statusCode:        403,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "missing chart archive",
+			action:            "create",
+			fields:            map[string]string{"releaseName": "foobar"},
+			statusCode:        400,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "missing release name",
+			action:            "create",
+			archive:           archive,
+			statusCode:        400,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "invalid chart archive",
+			action:            "create",
+			fields:            map[string]string{"releaseName": "foobar"},
+			archive:           []byte("not a chart"),
+			statusCode:        422,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "install with options",
+			action:            "create",
+			fields:            map[string]string{"releaseName": "foobar", "wait": "true", "atomic": "true", "timeoutSeconds": "600"},
+			archive:           archive,
+			statusCode:        200,
+			remainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		},
+		{
+			name:              "invalid option",
+			action:            "create",
+			fields:            map[string]string{"releaseName": "foobar", "wait": "sometimes"},
+			archive:           archive,
+			statusCode:        400,
+			remainingReleases: []release.Release{},
+		},
+		{
+			name:              "timeout exceeding the maximum",
+			action:            "upgrade",
+			fields:            map[string]string{"timeoutSeconds": "86400"},
+			archive:           archive,
+			existingReleases:  []release.Release{{Name: "foobar", Namespace: "default"}},
+			statusCode:        422,
+			remainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.existingReleases == nil {
+				tc.existingReleases = []release.Release{}
+			}
+			if tc.maxBytes == 0 {
+				tc.maxBytes = DefaultChartUploadMaxBytes
+			}
+			if tc.maxDecompressed == 0 {
+				tc.maxDecompressed = DefaultChartUploadMaxDecompressedBytes
+			}
+			proxy := &proxyFake.Proxy{Releases: tc.existingReleases}
+			handler := HelmProxy{
+				ListLimit:                       255,
+				ChartClient:                     &chartFake.Chart{},
+				ProxyClient:                     proxy,
+				ChartUploadEnabled:              !tc.uploadDisabled,
+				ChartUploadMaxBytes:             tc.maxBytes,
+				MaxReleaseTimeout:               proxy2.DefaultMaxReleaseTimeout,
+				ChartUploadMaxDecompressedBytes: tc.maxDecompressed,
+			}
+
+			body, contentType := chartUploadBody(t, tc.fields, tc.archive)
+			req := httptest.NewRequest("POST", "http://foo.bar", body)
+			req.Header.Set("Content-Type", contentType)
+			nullLogger, _ := logrusTest.NewNullLogger()
+			ctx := context.WithValue(req.Context(), validationObjectKey{}, &proxy2.TokenValidation{Token: "desu"})
+			ctx = context.WithValue(ctx, logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+			ctx = context.WithValue(ctx, userKey{}, &authFake.Auth{ForbiddenActions: tc.forbiddenActions})
+			req = req.WithContext(ctx)
+
+			response := httptest.NewRecorder()
+			params := map[string]string{"namespace": "default", "releaseName": "foobar"}
+			if tc.action == "create" {
+				handler.CreateReleaseFromUpload(response, req, params)
+			} else {
+				handler.UpgradeReleaseFromUpload(response, req, params)
+			}
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d, body: %s", got, want, response.Body)
+			}
+			if got, want := proxy.Releases, tc.remainingReleases; !reflect.DeepEqual(got, want) {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	testCases := []struct {
+		name     string
+		size     int
+		limit    int64
+		exceeded bool
+	}{
+		{"below the limit", 9, 10, false},
+		{"at the limit", 10, 10, false},
+		{"above the limit", 11, 10, true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			body := &limitedBody{ReadCloser: ioutil.NopCloser(bytes.NewReader(make([]byte, tc.size))), remaining: tc.limit}
+			data, err := ioutil.ReadAll(body)
+			if got, want := body.exceeded, tc.exceeded; got != want {
+				t.Errorf("got: %t, want: %t", got, want)
+			}
+			if tc.exceeded {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if len(data) != tc.size {
+				t.Errorf("got: %d bytes, want: %d", len(data), tc.size)
+			}
+		})
+	}
+}
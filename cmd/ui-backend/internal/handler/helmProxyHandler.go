@@ -25,16 +25,20 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kubeapps/common/response"
 	"github.com/pkg/errors"
 	"github.com/urfave/negroni"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
 
 	"github.com/gardener/potter-hub/pkg/auth"
 	chartUtils "github.com/gardener/potter-hub/pkg/chart"
 	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+	"github.com/gardener/potter-hub/pkg/health"
 	"github.com/gardener/potter-hub/pkg/kubeval"
 	logUtils "github.com/gardener/potter-hub/pkg/log"
 	"github.com/gardener/potter-hub/pkg/proxy"
@@ -195,30 +199,38 @@ func errorCodeWithDefault(err error, defaultCode errorUtils.HTTPErrorType) error
 	return httperr
 }
 
-func getChart(req *http.Request, cu chartUtils.Resolver) (*chartUtils.Details, *chart.Chart, error) {
+// getChart parses the chart details and the release options of the request and retrieves the chart
+func getChart(req *http.Request, cu chartUtils.Resolver) (*chartUtils.Details, *proxy.ReleaseOptions, *chart.Chart, error) {
 	defer req.Body.Close()
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Could not read response body")
+		return nil, nil, nil, errors.Wrap(err, "Could not read response body")
 	}
 
 	chartDetails, err := cu.ParseDetails(body)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	if err := chartDetails.MergeValues(); err != nil {
+		return nil, nil, nil, err
+	}
+	options := &proxy.ReleaseOptions{}
+	if err := json.Unmarshal(body, options); err != nil {
+		return nil, nil, nil, errorUtils.BadRequest.New(errors.Wrap(err, "Could not parse release options"))
 	}
 
 	netClient, err := cu.InitNetClient(req.Context(), chartDetails)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	ch, err := cu.GetChart(chartDetails, netClient)
+	ch, err := cu.GetChart(req.Context(), chartDetails, netClient)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	return chartDetails, ch, nil
+	return chartDetails, options, ch, nil
 }
 
 func returnForbiddenActions(ctx context.Context, w http.ResponseWriter, forbiddenActions []auth.Action) {
@@ -237,6 +249,14 @@ type HelmProxy struct {
 	ListLimit   int
 	ChartClient chartUtils.Resolver
 	ProxyClient proxy.TillerClient
+	// ChartUploadEnabled allows to install and upgrade releases from uploaded chart archives
+	ChartUploadEnabled bool
+	// ChartUploadMaxBytes limits the size of chart upload requests
+	ChartUploadMaxBytes int64
+	// ChartUploadMaxDecompressedBytes limits the size of uploaded chart archives when decompressed
+	ChartUploadMaxDecompressedBytes int64
+	// MaxReleaseTimeout limits the timeout of installs and upgrades, there is no limit if it is 0
+	MaxReleaseTimeout time.Duration
 }
 
 func (h *HelmProxy) logStatus(ctx context.Context, namespace, name string, vo proxy.ValidationObject) {
@@ -251,23 +271,33 @@ func (h *HelmProxy) logStatus(ctx context.Context, namespace, name string, vo pr
 
 // CreateRelease creates a new release in the namespace given as Param
 func (h *HelmProxy) CreateRelease(w http.ResponseWriter, req *http.Request, params Params) {
+	chartDetails, options, ch, err := getChart(req, h.ChartClient)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	h.installRelease(w, req, params, chartDetails, options, ch)
+}
+
+// installRelease installs the chart if the user is allowed to create its resources
+func (h *HelmProxy) installRelease(w http.ResponseWriter, req *http.Request, params Params, chartDetails *chartUtils.Details, options *proxy.ReleaseOptions, ch *chart.Chart) {
 	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
 	log := logUtils.GetLogger(req.Context())
 
-	chartDetails, ch, err := getChart(req, h.ChartClient)
-	if err != nil {
-		utils.SendErrResponse(req.Context(), w, errorCode(err))
+	if err := options.Validate(h.MaxReleaseTimeout); err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
 		return
 	}
 
 	if !h.DisableAuth {
-		manifest, manifestErr := h.ProxyClient.ResolveManifest(req.Context(), params["namespace"], chartDetails.Values, ch, vo)
-		if manifestErr != nil {
-			utils.SendErrResponse(req.Context(), w, errorCode(manifestErr))
+		// The manifest is rendered with the patches and values the release is installed with
+		preview, previewErr := h.ProxyClient.PreviewRelease(req.Context(), chartDetails.ReleaseName, params["namespace"], chartDetails.Values, ch, *options, vo)
+		if previewErr != nil {
+			utils.SendErrResponse(req.Context(), w, errorCodeWithDefault(previewErr, errorUtils.UnprocessableEntity))
 			return
 		}
 		userAuth := req.Context().Value(userKey{}).(auth.Checker)
-		forbiddenActions, actionsErr := userAuth.GetForbiddenActions(params["namespace"], "create", manifest)
+		forbiddenActions, actionsErr := userAuth.GetForbiddenActions(params["namespace"], "create", preview.Manifest)
 		if actionsErr != nil {
 			utils.SendErrResponse(req.Context(), w, errorCode(actionsErr))
 			return
@@ -278,7 +308,14 @@ func (h *HelmProxy) CreateRelease(w http.ResponseWriter, req *http.Request, para
 		}
 	}
 
-	rel, err := h.ProxyClient.CreateRelease(req.Context(), chartDetails.ReleaseName, params["namespace"], chartDetails.Values, ch, vo)
+	if isAsync(req) {
+		h.startOperation(w, req, proxy.OperationInstall, chartDetails.ReleaseName, params["namespace"], func(ctx context.Context) (*release.Release, error) {
+			return h.ProxyClient.CreateRelease(ctx, chartDetails.ReleaseName, params["namespace"], chartDetails.Values, ch, *options, vo)
+		})
+		return
+	}
+
+	rel, err := h.ProxyClient.CreateRelease(req.Context(), chartDetails.ReleaseName, params["namespace"], chartDetails.Values, ch, *options, vo)
 	if err != nil {
 		utils.SendErrResponse(req.Context(), w, errorCodeWithDefault(err, errorUtils.UnprocessableEntity))
 		return
@@ -286,7 +323,39 @@ func (h *HelmProxy) CreateRelease(w http.ResponseWriter, req *http.Request, para
 
 	log.Infof("Installed release %s", rel.Name)
 	h.logStatus(req.Context(), params["namespace"], rel.Name, vo)
-	response.NewDataResponse(*rel).Write(w)
+	writeReleaseWithValues(req.Context(), w, rel, ch)
+}
+
+// isAsync returns if the release operation of the request runs in the background
+func isAsync(req *http.Request) bool {
+	async := req.URL.Query().Get("async")
+	return async == "1" || async == utils.StrTrue
+}
+
+// startOperation runs the release operation in the background and responds with its record
+func (h *HelmProxy) startOperation(w http.ResponseWriter, req *http.Request, operationType, name, namespace string, run proxy.OperationFunc) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+	log := logUtils.GetLogger(req.Context())
+
+	operation, err := h.ProxyClient.StartOperation(req.Context(), operationType, name, namespace, vo, run)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	log.Infof("Started %s of release %s as operation %s", operationType, name, operation.ID)
+	response.NewDataResponse(operation).WithCode(http.StatusAccepted).Write(w)
+}
+
+// GetOperation returns the status of an asynchronous release operation
+func (h *HelmProxy) GetOperation(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	operation, err := h.ProxyClient.GetOperation(req.Context(), params["operationID"], vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	response.NewDataResponse(operation).Write(w)
 }
 
 // OperateRelease decides which method to call depending in the "action" query param
@@ -354,21 +423,33 @@ func (h *HelmProxy) RollbackRelease(w http.ResponseWriter, req *http.Request, pa
 func (h *HelmProxy) UpgradeRelease(w http.ResponseWriter, req *http.Request, params Params) {
 	log := logUtils.GetLogger(req.Context())
 	log.Infof("Upgrading Helm Release")
-	chartDetails, ch, err := getChart(req, h.ChartClient)
+	chartDetails, options, ch, err := getChart(req, h.ChartClient)
 	if err != nil {
 		utils.SendErrResponse(req.Context(), w, errorCode(err))
 		return
 	}
+	h.upgradeRelease(w, req, params, chartDetails, options, ch)
+}
+
+// upgradeRelease upgrades the release to the chart if the user is allowed to upgrade its resources
+func (h *HelmProxy) upgradeRelease(w http.ResponseWriter, req *http.Request, params Params, chartDetails *chartUtils.Details, options *proxy.ReleaseOptions, ch *chart.Chart) {
 	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+	log := logUtils.GetLogger(req.Context())
+
+	if err := options.Validate(h.MaxReleaseTimeout); err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
 
 	if !h.DisableAuth {
-		manifest, manifestErr := h.ProxyClient.ResolveManifest(req.Context(), params["namespace"], chartDetails.Values, ch, vo)
-		if manifestErr != nil {
-			utils.SendErrResponse(req.Context(), w, errorCode(manifestErr))
+		// The manifest is rendered with the patches and values the release is upgraded with
+		preview, previewErr := h.ProxyClient.PreviewRelease(req.Context(), params["releaseName"], params["namespace"], chartDetails.Values, ch, *options, vo)
+		if previewErr != nil {
+			utils.SendErrResponse(req.Context(), w, errorCodeWithDefault(previewErr, errorUtils.UnprocessableEntity))
 			return
 		}
 		userAuth := req.Context().Value(userKey{}).(auth.Checker)
-		forbiddenActions, actionsErr := userAuth.GetForbiddenActions(params["namespace"], "upgrade", manifest)
+		forbiddenActions, actionsErr := userAuth.GetForbiddenActions(params["namespace"], "upgrade", preview.Manifest)
 		if actionsErr != nil {
 			utils.SendErrResponse(req.Context(), w, errorCode(actionsErr))
 			return
@@ -379,14 +460,86 @@ func (h *HelmProxy) UpgradeRelease(w http.ResponseWriter, req *http.Request, par
 		}
 	}
 
-	rel, err := h.ProxyClient.UpdateRelease(req.Context(), params["releaseName"], params["namespace"], chartDetails.Values, ch, vo)
+	if isAsync(req) {
+		h.startOperation(w, req, proxy.OperationUpgrade, params["releaseName"], params["namespace"], func(ctx context.Context) (*release.Release, error) {
+			return h.ProxyClient.UpdateRelease(ctx, params["releaseName"], params["namespace"], chartDetails.Values, ch, *options, vo)
+		})
+		return
+	}
+
+	rel, err := h.ProxyClient.UpdateRelease(req.Context(), params["releaseName"], params["namespace"], chartDetails.Values, ch, *options, vo)
 	if err != nil {
 		utils.SendErrResponse(req.Context(), w, errorCodeWithDefault(err, errorUtils.UnprocessableEntity))
 		return
 	}
 	log.Infof("Upgraded release %s", rel.Name)
 	h.logStatus(req.Context(), params["namespace"], rel.Name, vo)
-	response.NewDataResponse(*rel).Write(w)
+	writeReleaseWithValues(req.Context(), w, rel, ch)
+}
+
+// releaseWithValues is the response of an install or upgrade with the values the release is rendered with
+// and the chart version the requested version or constraint was resolved to
+type releaseWithValues struct {
+	*release.Release
+	EffectiveValues map[string]interface{} `json:"effectiveValues"`
+	ResolvedVersion string                 `json:"resolvedVersion,omitempty"`
+}
+
+func writeReleaseWithValues(ctx context.Context, w http.ResponseWriter, rel *release.Release, ch *chart.Chart) {
+	effectiveValues, err := proxy.EffectiveValues(rel)
+	if err != nil {
+		utils.SendErrResponse(ctx, w, errorCode(err))
+		return
+	}
+	resolvedVersion := ""
+	if ch.Metadata != nil {
+		resolvedVersion = ch.Metadata.Version
+	}
+	response.NewDataResponse(releaseWithValues{Release: rel, EffectiveValues: effectiveValues, ResolvedVersion: resolvedVersion}).Write(w)
+}
+
+// releasePreview is the response of a preview with the actions the user is not allowed to perform
+type releasePreview struct {
+	*proxy.ReleasePreview
+	ForbiddenActions []auth.Action `json:"forbiddenActions"`
+}
+
+// PreviewRelease renders the chart with the values of the request as the release in the namespace
+// given as Param would be installed or upgraded. Upgrades contain the diff to the current revision.
+func (h *HelmProxy) PreviewRelease(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	chartDetails, options, ch, err := getChart(req, h.ChartClient)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	if err := options.Validate(h.MaxReleaseTimeout); err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+
+	preview, err := h.ProxyClient.PreviewRelease(req.Context(), params["releaseName"], params["namespace"], chartDetails.Values, ch, *options, vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCodeWithDefault(err, errorUtils.UnprocessableEntity))
+		return
+	}
+
+	forbiddenActions := []auth.Action{}
+	if !h.DisableAuth {
+		verb := "create"
+		if preview.Action == "upgrade" {
+			verb = "upgrade"
+		}
+		userAuth := req.Context().Value(userKey{}).(auth.Checker)
+		forbiddenActions, err = userAuth.GetForbiddenActions(params["namespace"], verb, preview.Manifest)
+		if err != nil {
+			utils.SendErrResponse(req.Context(), w, errorCode(err))
+			return
+		}
+	}
+
+	response.NewDataResponse(releasePreview{ReleasePreview: preview, ForbiddenActions: forbiddenActions}).Write(w)
 }
 
 // ListAllReleases list all releases that Tiller stores
@@ -412,9 +565,20 @@ func (h *HelmProxy) ListReleases(w http.ResponseWriter, req *http.Request, param
 	response.NewDataResponse(apps).Write(w)
 }
 
-// GetRelease returns the release info
+// GetRelease returns the release info with its health. Ready requirements like those of the hub can be
+// given as JSON with the query parameter "readyRequirements".
 func (h *HelmProxy) GetRelease(w http.ResponseWriter, req *http.Request, params Params) {
 	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	requirements := hubv1.ReadyRequirements{}
+	if value := req.URL.Query().Get("readyRequirements"); value != "" {
+		if err := json.Unmarshal([]byte(value), &requirements); err != nil {
+			err = errorUtils.BadRequest.NewErrorf("Invalid ready requirements %q", value)
+			utils.SendErrResponse(req.Context(), w, err)
+			return
+		}
+	}
+
 	rel, err := h.ProxyClient.GetRelease(req.Context(), params["releaseName"], params["namespace"], vo)
 	if err != nil {
 		utils.SendErrResponse(req.Context(), w, errorCode(err))
@@ -439,9 +603,174 @@ func (h *HelmProxy) GetRelease(w http.ResponseWriter, req *http.Request, params
 		}
 	}
 
+	// The release is returned even if the health of its resources cannot be determined
+	releaseHealth, err := h.ProxyClient.GetReleaseHealth(req.Context(), rel, requirements, vo)
+	if err != nil {
+		logUtils.GetLogger(req.Context()).Warnf("Could not determine health of release %s: %v", rel.Name, err)
+		releaseHealth = &health.ReleaseHealth{State: health.StateUnknown, Resources: []health.ResourceHealth{}}
+	}
+
 	var myRelease = chartUtils.KubeappsRelease(*rel)
 
-	response.NewDataResponse(&myRelease).Write(w)
+	response.NewDataResponse(&chartUtils.KubeappsReleaseWithHealth{Release: &myRelease, Health: releaseHealth}).Write(w)
+}
+
+// checkReleaseAccess returns false and sends the forbidden actions if the user is not allowed to
+// perform the action on the resources of the release given as Param
+func (h *HelmProxy) checkReleaseAccess(w http.ResponseWriter, req *http.Request, params Params, action string) bool {
+	if h.DisableAuth {
+		return true
+	}
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	rel, err := h.ProxyClient.GetRelease(req.Context(), params["releaseName"], params["namespace"], vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return false
+	}
+	return checkManifestAccess(w, req, params["namespace"], action, rel.Manifest)
+}
+
+// checkManifestAccess responds with the forbidden actions if the user may not perform the action
+// on the resources of the manifest
+func checkManifestAccess(w http.ResponseWriter, req *http.Request, namespace, action, manifest string) bool {
+	userAuth := req.Context().Value(userKey{}).(auth.Checker)
+	forbiddenActions, err := userAuth.GetForbiddenActions(namespace, action, manifest)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return false
+	}
+	if len(forbiddenActions) > 0 {
+		returnForbiddenActions(req.Context(), w, forbiddenActions)
+		return false
+	}
+	return true
+}
+
+// RunReleaseTests runs the test hooks of a release and returns their results with the logs of the
+// test pods. The timeout can be set with the query parameter "timeoutSeconds".
+func (h *HelmProxy) RunReleaseTests(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	options := &proxy.ReleaseOptions{}
+	if value := req.FormValue("timeoutSeconds"); value != "" {
+		timeoutSeconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			err = errorUtils.BadRequest.NewErrorf("Invalid timeout %q", value)
+			utils.SendErrResponse(req.Context(), w, err)
+			return
+		}
+		options.TimeoutSeconds = timeoutSeconds
+	}
+	if err := options.Validate(h.MaxReleaseTimeout); err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+
+	if !h.DisableAuth {
+		rel, err := h.ProxyClient.GetRelease(req.Context(), params["releaseName"], params["namespace"], vo)
+		if err != nil {
+			utils.SendErrResponse(req.Context(), w, errorCode(err))
+			return
+		}
+		// The test hooks are created in the namespace of the release
+		testManifests := []string{}
+		for _, hook := range rel.Hooks {
+			for _, event := range hook.Events {
+				if event == release.HookTest {
+					testManifests = append(testManifests, hook.Manifest)
+					break
+				}
+			}
+		}
+		if !checkManifestAccess(w, req, params["namespace"], "create", strings.Join(testManifests, "\n---\n")) {
+			return
+		}
+	}
+
+	run, err := h.ProxyClient.RunReleaseTests(req.Context(), params["releaseName"], params["namespace"], options.Timeout(), vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	response.NewDataResponse(run).Write(w)
+}
+
+// GetReleaseTestRun returns the results of the most recent test run of a release
+func (h *HelmProxy) GetReleaseTestRun(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	if !h.checkReleaseAccess(w, req, params, "get") {
+		return
+	}
+
+	run, err := h.ProxyClient.GetReleaseTestRun(req.Context(), params["releaseName"], params["namespace"], vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	response.NewDataResponse(run).Write(w)
+}
+
+// GetReleaseHistory returns the revisions of a release
+func (h *HelmProxy) GetReleaseHistory(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	if !h.checkReleaseAccess(w, req, params, "get") {
+		return
+	}
+
+	history, err := h.ProxyClient.GetReleaseHistory(req.Context(), params["releaseName"], params["namespace"], vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	response.NewDataResponse(history).Write(w)
+}
+
+// DiffReleaseRevisions returns the differences between the revisions "from" and "to" of a release.
+// The current revision is used if "to" is not set.
+func (h *HelmProxy) DiffReleaseRevisions(w http.ResponseWriter, req *http.Request, params Params) {
+	vo := req.Context().Value(validationObjectKey{}).(proxy.ValidationObject)
+
+	if req.FormValue("from") == "" {
+		err := errorUtils.BadRequest.New(errors.New("Missing revision \"from\" in request"))
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+	from, err := parseRevision(req.FormValue("from"))
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+	to, err := parseRevision(req.FormValue("to"))
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, err)
+		return
+	}
+
+	if !h.checkReleaseAccess(w, req, params, "get") {
+		return
+	}
+
+	diff, err := h.ProxyClient.DiffReleaseRevisions(req.Context(), params["releaseName"], params["namespace"], from, to, vo)
+	if err != nil {
+		utils.SendErrResponse(req.Context(), w, errorCode(err))
+		return
+	}
+	response.NewDataResponse(diff).Write(w)
+}
+
+// parseRevision parses a revision query param, 0 if it is empty
+func parseRevision(revision string) (int, error) {
+	if revision == "" {
+		return 0, nil
+	}
+	revisionInt, err := strconv.Atoi(revision)
+	if err != nil || revisionInt < 1 {
+		return 0, errorUtils.BadRequest.NewErrorf("Invalid revision %q", revision)
+	}
+	return revisionInt, nil
 }
 
 // DeleteRelease removes a release from a namespace
@@ -472,9 +801,11 @@ func (h *HelmProxy) DeleteRelease(w http.ResponseWriter, req *http.Request, para
 		}
 	}
 
-	keepHistory := false
-	if req.URL.Query().Get("keepHistory") == "1" || req.URL.Query().Get("keepHistory") == utils.StrTrue {
-		keepHistory = true
+	// Without keepHistory the retention policy of the namespace decides
+	var keepHistory *bool
+	if value := req.URL.Query().Get("keepHistory"); value != "" {
+		keep := value == "1" || value == utils.StrTrue
+		keepHistory = &keep
 	}
 	err := h.ProxyClient.DeleteRelease(req.Context(), params["releaseName"], params["namespace"], keepHistory, vo)
 	if err != nil {
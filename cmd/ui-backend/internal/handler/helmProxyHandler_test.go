@@ -50,6 +50,7 @@ func TestErrorCodeWithDefault(t *testing.T) {
 		{fmt.Errorf("release \"Foo \" failed"), errorUtils.InternalServerError, errorUtils.UnprocessableEntity},
 		{fmt.Errorf("this is an unexpected error"), errorUtils.InternalServerError, errorUtils.InternalServerError},
 		{fmt.Errorf("this is an unexpected error"), errorUtils.UnprocessableEntity, errorUtils.UnprocessableEntity},
+		{errorUtils.UnprocessableEntity.NewError("Chart verification failed"), errorUtils.InternalServerError, errorUtils.UnprocessableEntity},
 	}
 	for _, s := range tests {
 		err := errorCodeWithDefault(s.err, s.defaultCode)
@@ -66,6 +67,8 @@ type helmProxyTestScenario struct {
 	ExistingReleases []release.Release
 	DisableAuth      bool
 	ForbiddenActions []auth.Action
+	ForbiddenIn      string
+	ChartVersions    []string
 	// Request params
 	RequestBody  string
 	RequestQuery string
@@ -123,6 +126,186 @@ func TestCreateWithAuth(t *testing.T) {
 	executeHelmProxyTest(test, t)
 }
 
+func TestCreateWithOptions(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release which waits for its resources",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "wait": true, "atomic": true, "timeoutSeconds": 600}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode: 200,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestCreateWithExceedingTimeout(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release with a timeout above the maximum",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "timeoutSeconds": 999999}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode:        422,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestCreateWithEmptyPatch(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release with an empty patch",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "patches": [{"patch": "", "target": {"kind": "Deployment"}}]}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode:        400,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestUpgradeWithNegativeTimeout(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Upgrade a release with a negative timeout",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "timeoutSeconds": -1}`,
+		RequestQuery: "",
+		Action:       "upgrade",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode: 400,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestUpgradeWithReusedAndResetValues(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Upgrade a release reusing and resetting its values",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "reuseValues": true, "resetValues": true}`,
+		RequestQuery: "",
+		Action:       "upgrade",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode: 400,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestCreateWithVersionConstraint(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release with a chart version constraint",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		ChartVersions:    []string{"1.1.0", "1.2.0", "1.2.5", "1.3.0"},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "~1.2"}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode: 200,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: `{"data":{"name":"foobar","namespace":"default","effectiveValues":null,"resolvedVersion":"1.2.5"}}`,
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestUpgradeWithVersionConstraint(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Upgrade a release with a chart version constraint",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		ChartVersions:    []string{"1.1.0", "1.2.0", "1.2.5", "1.3.0"},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "~1.2"}`,
+		RequestQuery: "",
+		Action:       "upgrade",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode: 200,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: `{"data":{"name":"foobar","namespace":"default","effectiveValues":null,"resolvedVersion":"1.2.5"}}`,
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestCreateWithInvalidSetValue(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release with an invalid set value",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "valuesLayers": ["replicas: 2"], "set": ["image[=1"]}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode:        400,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      "",
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
 func TestConflictingCreate(t *testing.T) {
 	test := &helmProxyTestScenario{
 		// Scenario params
@@ -169,6 +352,54 @@ func TestCreateWithForbiddenActions(t *testing.T) {
 	executeHelmProxyTest(test, t)
 }
 
+func TestCreateWithPatchedKind(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release whose patch changes a resource to a forbidden kind",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{
+			{APIVersion: "rbac.authorization.k8s.io/v1", Resource: "clusterroles", ClusterWide: true, Verbs: []string{"create"}},
+		},
+		ForbiddenIn: "ClusterRole",
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "patches": [{"patch": "- op: replace\n  path: /kind\n  value: ClusterRole", "target": {"kind": "Role"}}]}`,
+		RequestQuery: "",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode:        403,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      `{"code":403,"message":"[{\"apiGroup\":\"rbac.authorization.k8s.io/v1\",\"resource\":\"clusterroles\",\"namespace\":\"\",\"clusterWide\":true,\"verbs\":[\"create\"]}]"}`,
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestUpgradeWithPatchedNamespace(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Upgrade a release whose patch moves a resource to a forbidden namespace",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{
+			{APIVersion: "v1", Resource: "secrets", Namespace: "kube-system", ClusterWide: false, Verbs: []string{"update"}},
+		},
+		ForbiddenIn: "kube-system",
+		// Request params
+		RequestBody:  `{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "patches": [{"patch": "- op: replace\n  path: /metadata/namespace\n  value: kube-system", "target": {"kind": "Secret"}}]}`,
+		RequestQuery: "",
+		Action:       "upgrade",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        403,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		ResponseBody:      `{"code":403,"message":"[{\"apiGroup\":\"v1\",\"resource\":\"secrets\",\"namespace\":\"kube-system\",\"clusterWide\":false,\"verbs\":[\"update\"]}]"}`,
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
 func TestSimpleUpgrade(t *testing.T) {
 	test := &helmProxyTestScenario{
 		// Scenario params
@@ -331,7 +562,7 @@ func TestGetRelease(t *testing.T) {
 		// Expected result
 		StatusCode:        200,
 		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
-		ResponseBody:      `{"data":{"name":"foobar","config":{},"namespace":"default"}}`,
+		ResponseBody:      `{"data":{"name":"foobar","info":{"resources":"KIND   NAMESPACE   NAME   STATE   MESSAGE\n","health":{"state":"ok","resources":[]}},"config":{},"namespace":"default"}}`,
 	}
 	executeHelmProxyTest(test, t)
 }
@@ -378,6 +609,26 @@ func TestGetWithForbiddenActions(t *testing.T) {
 	executeHelmProxyTest(test, t)
 }
 
+func TestGetReleaseWithInvalidReadyRequirements(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Get a release with invalid ready requirements",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?readyRequirements=%7Bjobs",
+		Action:       "get",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        400,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default"}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
 func TestListAllReleases(t *testing.T) {
 	test := &helmProxyTestScenario{
 		// Scenario params
@@ -521,6 +772,295 @@ func TestRollbackWithoutRevision(t *testing.T) {
 	executeHelmProxyTest(test, t)
 }
 
+func TestPreviewInstall(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Preview the installation of a release with forbidden actions",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{
+			{APIVersion: "v1", Resource: "pods", Namespace: "default", ClusterWide: false, Verbs: []string{"create"}},
+		},
+		// Request params
+		RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0"}`,
+		RequestQuery: "",
+		Action:       "preview",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        200,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      `{"data":{"action":"install","manifest":"","forbiddenActions":[{"apiGroup":"v1","resource":"pods","namespace":"default","clusterWide":false,"verbs":["create"]}]}}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestPreviewUpgrade(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Preview the upgrade of a release",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 2}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0"}`,
+		RequestQuery: "",
+		Action:       "preview",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        200,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 2}},
+		ResponseBody:      `{"data":{"action":"upgrade","manifest":"","currentRevision":2,"forbiddenActions":[]}}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestReleaseHistory(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Get the history of a release",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "",
+		Action:       "history",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        200,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		ResponseBody:      `{"data":[{"revision":1,"status":"","chart":"","chartVersion":"","description":"","firstDeployed":"0001-01-01T00:00:00Z","lastDeployed":"0001-01-01T00:00:00Z"}]}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestRunReleaseTests(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Run the tests of a release",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?timeoutSeconds=60",
+		Action:       "test",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        200,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		ResponseBody:      `{"data":{"releaseName":"foobar","namespace":"default","revision":1,"phase":"succeeded","startedAt":"0001-01-01T00:00:00Z","completedAt":"0001-01-01T00:00:00Z","hooks":[]}}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestRunReleaseTestsForbidden(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Run the tests of a release without permissions to create the test pods",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{
+			{APIVersion: "v1", Resource: "pods", Namespace: "default", ClusterWide: false, Verbs: []string{"create"}},
+		},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "",
+		Action:       "test",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        403,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestRunReleaseTestsWithInvalidTimeout(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Run the tests of a release with an invalid timeout",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?timeoutSeconds=soon",
+		Action:       "test",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        400,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestGetMissingReleaseTestRun(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Get the test run of a release which was not tested",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "",
+		Action:       "testrun",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        404,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 1}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestHistoryOfMissingRelease(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Get the history of a missing release",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "",
+		Action:       "history",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        404,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestReleaseDiff(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Diff two revisions of a release",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?from=1&to=3",
+		Action:       "diff",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        200,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		ResponseBody:      `{"data":{"from":1,"to":3,"manifest":[],"values":""}}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestReleaseDiffWithInvalidRevision(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Diff a revision which is not a number",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?from=latest",
+		Action:       "diff",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        400,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestReleaseDiffWithForbiddenActions(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Diff the revisions of a release with forbidden actions",
+		ExistingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{
+			{APIVersion: "v1", Resource: "pods", Namespace: "default", ClusterWide: false, Verbs: []string{"get"}},
+		},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "?from=1",
+		Action:       "diff",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        403,
+		RemainingReleases: []release.Release{{Name: "foobar", Namespace: "default", Version: 3}},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestAsyncCreate(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Create a release asynchronously",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0"}`,
+		RequestQuery: "?async=true",
+		Action:       "create",
+		Params:       map[string]string{"namespace": "default"},
+		// Expected result
+		StatusCode: 202,
+		RemainingReleases: []release.Release{
+			{Name: "foobar", Namespace: "default"},
+		},
+		ResponseBody: `{"data":{"id":"default.0","type":"install","namespace":"default","releaseName":"foobar","phase":"succeeded","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","logs":[]}}`,
+	}
+
+	executeHelmProxyTest(test, t)
+}
+
+func TestAsyncUpgradeOfMissingRelease(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Upgrade a missing release asynchronously",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      true,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0"}`,
+		RequestQuery: "?async=1",
+		Action:       "upgrade",
+		Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+		// Expected result
+		StatusCode:        202,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      `{"data":{"id":"default.0","type":"upgrade","namespace":"default","releaseName":"foobar","phase":"failed","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","error":"release foobar not found","logs":[]}}`,
+	}
+	executeHelmProxyTest(test, t)
+}
+
+func TestGetMissingOperation(t *testing.T) {
+	test := &helmProxyTestScenario{
+		// Scenario params
+		Description:      "Get a missing operation",
+		ExistingReleases: []release.Release{},
+		DisableAuth:      false,
+		ForbiddenActions: []auth.Action{},
+		// Request params
+		RequestBody:  "",
+		RequestQuery: "",
+		Action:       "operation",
+		Params:       map[string]string{"operationID": "default.0"},
+		// Expected result
+		StatusCode:        404,
+		RemainingReleases: []release.Release{},
+		ResponseBody:      "",
+	}
+	executeHelmProxyTest(test, t)
+}
+
 func executeHelmProxyTest(test *helmProxyTestScenario, t *testing.T) {
 	// Prepare environment
 	proxy := &proxyFake.Proxy{
@@ -528,9 +1068,10 @@ func executeHelmProxyTest(test *helmProxyTestScenario, t *testing.T) {
 	}
 	handler := HelmProxy{
 		DisableAuth: test.DisableAuth,
-		ListLimit:   255,
-		ChartClient: &chartFake.Chart{},
-		ProxyClient: proxy,
+		ListLimit:         255,
+		ChartClient:       &chartFake.Chart{Versions: test.ChartVersions},
+		ProxyClient:       proxy,
+		MaxReleaseTimeout: proxy2.DefaultMaxReleaseTimeout,
 	}
 	req := httptest.NewRequest("GET", fmt.Sprintf("http://foo.bar%s", test.RequestQuery), strings.NewReader(test.RequestBody))
 	ctx := context.WithValue(req.Context(), validationObjectKey{}, &proxy2.TokenValidation{Token: "desu"})
@@ -540,6 +1081,7 @@ func executeHelmProxyTest(test *helmProxyTestScenario, t *testing.T) {
 	if !test.DisableAuth {
 		fauth := &authFake.Auth{
 			ForbiddenActions: test.ForbiddenActions,
+			ForbiddenIn:      test.ForbiddenIn,
 		}
 		ctx := context.WithValue(req.Context(), userKey{}, fauth)
 		ctx = context.WithValue(ctx, logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(logrus.New())})
@@ -559,6 +1101,18 @@ func executeHelmProxyTest(test *helmProxyTestScenario, t *testing.T) {
 		handler.GetRelease(response, req, test.Params)
 	case "rollback":
 		handler.RollbackRelease(response, req, test.Params)
+	case "preview":
+		handler.PreviewRelease(response, req, test.Params)
+	case "history":
+		handler.GetReleaseHistory(response, req, test.Params)
+	case "diff":
+		handler.DiffReleaseRevisions(response, req, test.Params)
+	case "test":
+		handler.RunReleaseTests(response, req, test.Params)
+	case "testrun":
+		handler.GetReleaseTestRun(response, req, test.Params)
+	case "operation":
+		handler.GetOperation(response, req, test.Params)
 	case "list":
 		handler.ListReleases(response, req, test.Params)
 	case "listall":
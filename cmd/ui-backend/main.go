@@ -20,7 +20,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	_ "expvar"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -54,8 +54,37 @@ func main() {
 	hostURL := pflag.String("host-url", "", "URL of the current host address")
 	oidcCA := pflag.String("oidc-cluster-ca", "", "CA of the oidc cluster which contains kubeconfig information")
 	oidcClusterURL := pflag.String("oidc-cluster-url", "", "URL of the cluster which contains the kubeconfig information")
+	indexCacheMaxBytes := pflag.Int64("index-cache-max-bytes", chartUtils.DefaultIndexCacheMaxBytes, "maximum size of the cached chart repository indexes")
+	indexCacheTTL := pflag.Duration("index-cache-ttl", chartUtils.DefaultIndexCacheTTL, "duration after which a cached chart repository index is revalidated")
+	debugAddr := pflag.String("debug-address", "127.0.0.1:8081", "internal address serving the cache statistics on /debug/vars, disabled if empty")
+	chartCacheDir := pflag.String("chart-cache-dir", "", "directory to cache downloaded chart archives in, the cache is disabled if empty")
+	chartCacheMaxBytes := pflag.Int64("chart-cache-max-bytes", 1024*1024*1024, "maximum size of the cached chart archives")
+	enableChartUpload := pflag.Bool("enable-chart-upload", false, "Allow to install and upgrade releases from uploaded chart archives")
+	chartUploadMaxBytes := pflag.Int64("chart-upload-max-bytes", handler.DefaultChartUploadMaxBytes, "maximum size of chart upload requests")
+	chartUploadMaxDecompressedBytes := pflag.Int64("chart-upload-max-decompressed-bytes", handler.DefaultChartUploadMaxDecompressedBytes, "maximum size of uploaded chart archives when decompressed")
+	releaseLockHolderIdentity := pflag.String("release-lock-holder-identity", "", "identity of this instance in the locks of releases, defaults to the hostname")
+	releaseLockWaitTimeout := pflag.Duration("release-lock-wait-timeout", helmProxy.DefaultReleaseLockWaitTimeout, "time an operation waits for the lock of a release before it fails with a conflict")
+	maxReleaseTimeout := pflag.Duration("max-release-timeout", helmProxy.DefaultMaxReleaseTimeout, "maximum timeout which can be requested for installs and upgrades, 0 for no limit")
+	releaseMaxHistory := pflag.Int("release-max-history", helmProxy.DefaultReleaseMaxHistory, "maximum number of revisions kept of a release, 0 for no limit")
+	releaseMaxAge := pflag.Duration("release-max-age", 0, "age after which superseded revisions and uninstalled releases are pruned, 0 for no limit")
+	keepUninstalledReleases := pflag.Bool("keep-uninstalled-releases", false, "keep the history of uninstalled releases unless the uninstall requests otherwise")
+	releasePruneInterval := pflag.Duration("release-prune-interval", time.Hour, "interval in which the histories of the releases in the namespaces of recent operations are pruned, 0 to disable")
+	targetNamespacesMax := pflag.Int("target-namespaces-max", helmProxy.DefaultMaxTargetNamespaces, "maximum number of namespaces of recent operations which are pruned and to which registry credentials are propagated")
+	registryCredentialsNamespace := pflag.String("registry-credentials-namespace", "", "namespace of the hub with the registry credentials copied into the namespaces of releases, image pull secrets are disabled if empty")
+	imagePullSecretSyncInterval := pflag.Duration("image-pull-secret-sync-interval", 5*time.Minute, "interval in which changed registry credentials are propagated to the namespaces of recent operations, 0 to disable")
 	pflag.Parse()
 
+	chartUtils.ConfigureIndexCache(*indexCacheMaxBytes, *indexCacheTTL)
+	expvar.Publish("repoIndexCache", expvar.Func(func() interface{} {
+		return chartUtils.GetIndexCacheStats()
+	}))
+	if err := chartUtils.ConfigureChartCache(*chartCacheDir, *chartCacheMaxBytes); err != nil {
+		logUtils.StandardLogger().Fatalf("Unable to configure chart cache: %v", err)
+	}
+	expvar.Publish("chartCache", expvar.Func(func() interface{} {
+		return chartUtils.GetChartCacheStats()
+	}))
+
 	decodedClusterCAData, decodeErr := base64.StdEncoding.DecodeString(*oidcCA)
 	if decodeErr != nil {
 		logUtils.StandardLogger().Fatalf("Unable to decode oidc cluster CA: %v", decodeErr)
@@ -75,9 +104,40 @@ func main() {
 		ClientFactory:  handler.K8sClientFromConfig,
 	}
 
-	hp := initHelmProxy(disableAuth, userAgentComment, version, listLimit)
+	chartClient := initChartClient(userAgentComment, version)
+	proxyClient := &helmProxy.Proxy{
+		LockHolderIdentity: *releaseLockHolderIdentity,
+		LockWaitTimeout:    *releaseLockWaitTimeout,
+		Retention: helmProxy.RetentionPolicy{
+			MaxHistory:      *releaseMaxHistory,
+			MaxAge:          *releaseMaxAge,
+			KeepUninstalled: *keepUninstalledReleases,
+		},
+	}
+	if *registryCredentialsNamespace != "" {
+		proxyClient.ImagePullSecrets = initImagePullSecretManager(*registryCredentialsNamespace)
+	}
+	if *releasePruneInterval > 0 || (proxyClient.ImagePullSecrets != nil && *imagePullSecretSyncInterval > 0) {
+		proxyClient.Targets = helmProxy.NewTargetNamespaces(*targetNamespacesMax, helmProxy.DefaultTargetNamespaceTTL)
+	}
+	if *releasePruneInterval > 0 {
+		logger := &logUtils.Logger{Entry: logUtils.StandardLogger().WithField(logUtils.LogKeyLoggerName, "ReleasePruner")}
+		pruneCtx := context.WithValue(context.Background(), logUtils.LoggerKey{}, logger)
+		go proxyClient.StartReleasePruner(pruneCtx, *releasePruneInterval)
+	}
+	if proxyClient.ImagePullSecrets != nil && *imagePullSecretSyncInterval > 0 {
+		logger := &logUtils.Logger{Entry: logUtils.StandardLogger().WithField(logUtils.LogKeyLoggerName, "ImagePullSecretPropagation")}
+		syncCtx := context.WithValue(context.Background(), logUtils.LoggerKey{}, logger)
+		go proxyClient.StartImagePullSecretPropagation(syncCtx, *imagePullSecretSyncInterval)
+	}
+	hp := initHelmProxy(disableAuth, chartClient, proxyClient, listLimit)
+	hp.ChartUploadEnabled = *enableChartUpload
+	hp.ChartUploadMaxBytes = *chartUploadMaxBytes
+	hp.ChartUploadMaxDecompressedBytes = *chartUploadMaxDecompressedBytes
+	hp.MaxReleaseTimeout = *maxReleaseTimeout
 	k8sReverseProxy := handler.NewK8sReverseProxy(*oidcClusterURL, *hostURL, decodedClusterCAData)
 	appRepoHandler := initAppRepoHandler()
+	chartSearchHandler := &handler.ChartSearchHandler{ChartClient: chartClient}
 	systemInfoHandler := initSystemInfoHandler()
 
 	port := os.Getenv("PORT")
@@ -99,6 +159,7 @@ func main() {
 	addClusterBomRoutes(r, bomHandler)
 	addAvailabilityRoutes(r, avcheckConfig.PathPrefix, helmProxyChecker, chartServiceChecker, dashboardChecker)
 	addAppRepoRoutes(r, appRepoHandler)
+	addChartSearchRoutes(r, chartSearchHandler)
 	addSystemInfoRoutes(r, systemInfoHandler)
 	addK8sReverseProxyRoutes(r, k8sReverseProxy)
 
@@ -110,6 +171,10 @@ func main() {
 		Handler: n,
 	}
 
+	if *debugAddr != "" {
+		go serveDebugRoutes(*debugAddr)
+	}
+
 	go func() {
 		logUtils.StandardLogger().WithField("addr", addr).Info("Started Helm Proxy")
 		err := srv.ListenAndServe()
@@ -232,6 +297,13 @@ func addHelmProxyRoutes(r *mux.Router, hp *handler.HelmProxy, authGate negroni.H
 		negroni.Wrap(handler.WithParams(hp.CreateRelease)),
 	))
 
+	apiv1.Methods("POST").Path("/namespaces/{namespace}/releases/upload").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.CreateReleaseFromUpload)),
+	))
+
 	apiv1.Methods("GET").Path("/namespaces/{namespace}/releases/{releaseName}").Handler(negroni.New(
 		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
 		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
@@ -246,6 +318,55 @@ func addHelmProxyRoutes(r *mux.Router, hp *handler.HelmProxy, authGate negroni.H
 		negroni.Wrap(handler.WithParams(hp.OperateRelease)),
 	))
 
+	apiv1.Methods("PUT").Path("/namespaces/{namespace}/releases/{releaseName}/upload").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.UpgradeReleaseFromUpload)),
+	))
+
+	apiv1.Methods("POST").Path("/namespaces/{namespace}/releases/{releaseName}/preview").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.PreviewRelease)),
+	))
+
+	apiv1.Methods("GET").Path("/namespaces/{namespace}/releases/{releaseName}/history").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.GetReleaseHistory)),
+	))
+
+	apiv1.Methods("GET").Path("/namespaces/{namespace}/releases/{releaseName}/diff").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.DiffReleaseRevisions)),
+	))
+
+	apiv1.Methods("POST").Path("/namespaces/{namespace}/releases/{releaseName}/test").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.RunReleaseTests)),
+	))
+
+	apiv1.Methods("GET").Path("/namespaces/{namespace}/releases/{releaseName}/test").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.GetReleaseTestRun)),
+	))
+
+	apiv1.Methods("GET").Path("/operations/{operationID}").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		authGate,
+		negroni.Wrap(handler.WithParams(hp.GetOperation)),
+	))
+
 	apiv1.Methods("DELETE").Path("/namespaces/{namespace}/releases/{releaseName}").Handler(negroni.New(
 		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
 		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
@@ -268,6 +389,14 @@ func addAppRepoRoutes(r *mux.Router, appRepoHandler *handler.AppRepositoryHandle
 	))
 }
 
+func addChartSearchRoutes(r *mux.Router, chartSearchHandler *handler.ChartSearchHandler) {
+	r.Path("/charts").Methods("GET").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		negroni.Wrap(handler.WithoutParams(chartSearchHandler.SearchCharts)),
+	))
+}
+
 func addSystemInfoRoutes(r *mux.Router, systemInfoHandler *handler.SystemInfoHandler) {
 	r.Path("/controller-version").Methods("GET").Handler(negroni.New(
 		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
@@ -300,6 +429,22 @@ func addK8sReverseProxyRoutes(r *mux.Router, k8sReverseProxy *handler.K8sReverse
 	))
 }
 
+// serveDebugRoutes exposes the expvar statistics, e.g. of the repo index cache, on a separate
+// listener since they contain the command line of the ui-backend and must not be public
+func serveDebugRoutes(addr string) {
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/debug/vars").Handler(negroni.New(
+		negroni.HandlerFunc(logUtils.PrepareLoggerHandler),
+		negroni.HandlerFunc(logUtils.RequestResponseLogHandler),
+		negroni.Wrap(expvar.Handler()),
+	))
+
+	logUtils.StandardLogger().WithField("addr", addr).Info("Started debug server")
+	if err := http.ListenAndServe(addr, r); err != nil {
+		logUtils.StandardLogger().Errorf("Debug server failed: %v", err)
+	}
+}
+
 func addAvailabilityRoutes(r *mux.Router, pathPrefix string, uiBackendChecker avcheck.IUIBackendChecker, chartServiceChecker avcheck.IChartServiceChecker, dashboardChecker avcheck.IDashboardChecker) {
 	r.Methods("GET").Path("/live").Handler(
 		negroni.New(
@@ -355,14 +500,8 @@ func getHubClusterConfig() (*rest.Config, bool) {
 	return config, isRemoteClusterConfig
 }
 
-func initHelmProxy(disableAuth *bool, userAgentComment, version *string, listLimit *int) *handler.HelmProxy {
-	var config *rest.Config
-	var err error
-
-	config, isRemoteClusterConfig := getHubClusterConfig()
-	if isRemoteClusterConfig {
-		*disableAuth = true
-	}
+func initChartClient(userAgentComment, version *string) *chartUtils.Client {
+	config, _ := getHubClusterConfig()
 
 	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -374,14 +513,32 @@ func initHelmProxy(disableAuth *bool, userAgentComment, version *string, listLim
 		logUtils.StandardLogger().Fatalf("Unable to create an app repository client: %v", err)
 	}
 
-	chartClient := chartUtils.NewClient(kubeClient, appRepoClient, loader.LoadArchive, userAgent(*userAgentComment, *version))
+	return chartUtils.NewClient(kubeClient, appRepoClient, loader.LoadArchive, userAgent(*userAgentComment, *version))
+}
+
+func initHelmProxy(disableAuth *bool, chartClient *chartUtils.Client, proxyClient *helmProxy.Proxy, listLimit *int) *handler.HelmProxy {
+	_, isRemoteClusterConfig := getHubClusterConfig()
+	if isRemoteClusterConfig {
+		*disableAuth = true
+	}
 
 	return &handler.HelmProxy{
 		DisableAuth: *disableAuth,
 		ListLimit:   *listLimit,
 		ChartClient: chartClient,
-		ProxyClient: &helmProxy.Proxy{},
+		ProxyClient: proxyClient,
+	}
+}
+
+func initImagePullSecretManager(namespace string) *helmProxy.ImagePullSecretManager {
+	config, _ := getHubClusterConfig()
+
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		logUtils.StandardLogger().Fatalf("Unable to create a kubernetes client: %v", err)
 	}
+
+	return helmProxy.NewImagePullSecretManager(kubeClient.CoreV1().Secrets(namespace))
 }
 
 func initAppRepoHandler() *handler.AppRepositoryHandler {
@@ -17,11 +17,15 @@ limitations under the License.
 package fake
 
 import (
+	"strings"
+
 	authUtils "github.com/gardener/potter-hub/pkg/auth"
 )
 
 type Auth struct {
 	ForbiddenActions []authUtils.Action
+	// ForbiddenIn restricts the forbidden actions to manifests containing it, if it is set
+	ForbiddenIn string
 }
 
 func (f *Auth) Validate() error {
@@ -29,5 +33,8 @@ func (f *Auth) Validate() error {
 }
 
 func (f *Auth) GetForbiddenActions(namespace, action, manifest string) ([]authUtils.Action, error) {
+	if f.ForbiddenIn != "" && !strings.Contains(manifest, f.ForbiddenIn) {
+		return []authUtils.Action{}, nil
+	}
 	return f.ForbiddenActions, nil
 }
@@ -30,17 +30,19 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
 	appRepoClientSet "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/client/clientset/versioned"
+	"github.com/gardener/potter-hub/pkg/credentials"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
 	logUtils "github.com/gardener/potter-hub/pkg/log"
 	"github.com/gardener/potter-hub/pkg/util"
 )
@@ -49,19 +51,6 @@ const (
 	defaultTimeoutSeconds = 180
 )
 
-type repoIndex struct {
-	checksum string
-	index    *repo.IndexFile
-}
-
-// nolint
-var repoIndexes map[string]*repoIndex
-
-// nolint
-func init() {
-	repoIndexes = map[string]*repoIndex{}
-}
-
 // Details contains the information to retrieve a Chart
 type Details struct {
 	// AppRepositoryResourceName specifies an app repository resource to use
@@ -71,10 +60,18 @@ type Details struct {
 	ChartName string `json:"chartName"`
 	// ReleaseName is the Name of the release given to Tiller.
 	ReleaseName string `json:"releaseName"`
-	// Version is the chart version.
+	// Version is the chart version. It can also be a semver constraint like "~1.4",
+	// "^2.0.0" or ">=3.1 <4" which is resolved to the highest matching version.
+	// Pre-releases only match constraints which contain a pre-release, e.g. "^2.0.0-0".
 	Version string `json:"version"`
 	// Values is a string containing (unparsed) YAML values.
 	Values string `json:"values,omitempty"`
+	// ValuesLayers are YAML values applied in order on top of Values.
+	ValuesLayers []string `json:"valuesLayers,omitempty"`
+	// Set are overrides in the format of helm's --set flag, e.g. "image.tag=1.2.3".
+	Set []string `json:"set,omitempty"`
+	// SetString are overrides in the format of helm's --set-string flag, their values are strings.
+	SetString []string `json:"setString,omitempty"`
 }
 
 // HTTPClient Interface to perform HTTP requests
@@ -88,7 +85,7 @@ type LoadChart func(in io.Reader) (*chart.Chart, error)
 // Resolver for exposed funcs
 type Resolver interface {
 	ParseDetails(data []byte) (*Details, error)
-	GetChart(details *Details, netClient HTTPClient) (*chart.Chart, error)
+	GetChart(ctx context.Context, details *Details, netClient HTTPClient) (*chart.Chart, error)
 	InitNetClient(ctx context.Context, details *Details) (HTTPClient, error)
 }
 
@@ -132,7 +129,7 @@ func readResponseBody(res *http.Response) ([]byte, error) {
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.New("chart download request failed")
+		return nil, errors.WithStack(&statusError{statusCode: res.StatusCode})
 	}
 
 	body, err := ioutil.ReadAll(res.Body)
@@ -148,21 +145,6 @@ func checksum(data []byte) string {
 	return string(hasher.Sum(nil))
 }
 
-// Cache the result of parsing the repo index since parsing this YAML
-// is an expensive operation. See https://github.com/kubeapps/kubeapps/issues/1052
-func getIndexFromCache(repoURL string, data []byte) (*repo.IndexFile, string) {
-	sha := checksum(data)
-	if repoIndexes[repoURL] == nil || repoIndexes[repoURL].checksum != sha {
-		// The repository is not in the cache or the content changed
-		return nil, sha
-	}
-	return repoIndexes[repoURL].index, sha
-}
-
-func storeIndexInCache(repoURL string, index *repo.IndexFile, sha string) {
-	repoIndexes[repoURL] = &repoIndex{sha, index}
-}
-
 func parseIndex(data []byte) (*repo.IndexFile, error) {
 	index := &repo.IndexFile{}
 	err := yaml.Unmarshal(data, index)
@@ -173,32 +155,28 @@ func parseIndex(data []byte) (*repo.IndexFile, error) {
 	return index, nil
 }
 
-// fetchRepoIndex returns a Helm repository
-func fetchRepoIndex(netClient HTTPClient, repoURL string) (*repo.IndexFile, error) {
-	req, err := getReq(repoURL)
-	if err != nil {
-		return nil, err
+// repoIndexURLs returns the URLs of the index.yaml of the repository URLs
+func repoIndexURLs(repoURLs []string) []string {
+	indexURLs := make([]string, len(repoURLs))
+	for i, repoURL := range repoURLs {
+		indexURLs[i] = strings.TrimSuffix(strings.TrimSpace(repoURL), "/") + "/index.yaml"
 	}
+	return indexURLs
+}
 
-	res, err := (netClient).Do(req)
+// fetchRepoIndex returns a Helm repository from the first of the (mirrored) index URLs
+// which can be fetched and the URL which served it
+func fetchRepoIndex(ctx context.Context, netClient HTTPClient, indexURLs []string) (*repo.IndexFile, string, error) {
+	var index *repo.IndexFile
+	indexURL, err := downloadFailover.do(ctx, indexURLs, func(indexURL string) error {
+		var err error
+		index, err = repoIndexes.fetch(netClient, indexURL)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "Request failed")
-	}
-	data, err := readResponseBody(res)
-	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	index, sha := getIndexFromCache(repoURL, data)
-	if index == nil {
-		// index not found in the cache, parse it
-		index, err = parseIndex(data)
-		if err != nil {
-			return nil, err
-		}
-		storeIndexInCache(repoURL, index, sha)
-	}
-	return index, nil
+	return index, indexURL, nil
 }
 
 func resolveChartURL(index, chartName string) (string, error) {
@@ -213,24 +191,55 @@ func resolveChartURL(index, chartName string) (string, error) {
 	return chartURL.String(), nil
 }
 
-// findChartInRepoIndex returns the URL of a chart given a Helm repository and its name and version
-func findChartInRepoIndex(repoIndex *repo.IndexFile, repoURL, chartName, chartVersion string) (string, error) {
+// findChartInRepoIndex returns the index entry and the URLs of a chart given a Helm repository and its name and version.
+// The version can be a semver constraint in which case the highest matching version is returned.
+// Relative URLs of the index entry are resolved against all index URLs of the repository and its mirrors.
+func findChartInRepoIndex(repoIndex *repo.IndexFile, indexURLs []string, chartName, chartVersion string) (*repo.ChartVersion, []string, error) {
 	errMsg := fmt.Sprintf("chart %q", chartName)
 	if chartVersion != "" {
 		errMsg = fmt.Sprintf("%s version %q", errMsg, chartVersion)
 	}
-	cv, err := repoIndex.Get(chartName, chartVersion)
-	if err != nil {
-		return "", errors.Errorf("%s not found in repository", errMsg)
+
+	chartVersions := repoIndex.Entries[chartName]
+	versions := make([]string, len(chartVersions))
+	for i, cv := range chartVersions {
+		versions[i] = cv.Version
+	}
+	resolvedVersion, ok := resolveVersion(versions, chartVersion)
+	if !ok {
+		return nil, nil, errors.Errorf("%s not found in repository", errMsg)
+	}
+
+	var cv *repo.ChartVersion
+	for _, entry := range chartVersions {
+		if entry.Version == resolvedVersion {
+			cv = entry
+			break
+		}
 	}
 	if len(cv.URLs) == 0 {
-		return "", errors.Errorf("%s has no downloadable URLs", errMsg)
+		return nil, nil, errors.Errorf("%s has no downloadable URLs", errMsg)
+	}
+
+	chartURLs := []string{}
+	resolved := map[string]bool{}
+	for _, u := range cv.URLs {
+		for _, indexURL := range indexURLs {
+			chartURL, err := resolveChartURL(indexURL, u)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !resolved[chartURL] {
+				resolved[chartURL] = true
+				chartURLs = append(chartURLs, chartURL)
+			}
+		}
 	}
-	return resolveChartURL(repoURL, cv.URLs[0])
+	return cv, chartURLs, nil
 }
 
-// fetchChart returns the Chart content given an URL
-func fetchChart(netClient HTTPClient, chartURL string, load LoadChart) (*chart.Chart, error) {
+// downloadChart returns the content of the chart archive (or its provenance file) given an URL
+func downloadChart(netClient HTTPClient, chartURL string) ([]byte, error) {
 	req, err := getReq(chartURL)
 	if err != nil {
 		return nil, err
@@ -240,11 +249,15 @@ func fetchChart(netClient HTTPClient, chartURL string, load LoadChart) (*chart.C
 	if err != nil {
 		return nil, errors.Wrap(err, "Request failed")
 	}
-	data, err := readResponseBody(res)
-	if err != nil {
-		return nil, err
+	return readResponseBody(res)
+}
+
+// cacheChart stores the chart archive in the chart cache. The chart can be installed
+// even if it could not be cached.
+func cacheChart(ctx context.Context, cacheKey string, data []byte) {
+	if err := chartCache.put(cacheKey, data); err != nil {
+		logUtils.GetLogger(ctx).Warnf("could not cache chart: %v", err)
 	}
-	return load(bytes.NewReader(data))
 }
 
 // ParseDetails return Chart details
@@ -259,6 +272,10 @@ func (c *Client) ParseDetails(data []byte) (*Details, error) {
 		return nil, errors.New("An AppRepositoryResourceName is required")
 	}
 
+	if err := validateVersion(details.Version); err != nil {
+		return nil, err
+	}
+
 	return details, nil
 }
 
@@ -281,11 +298,79 @@ func (c *clientWithDefaultHeaders) Do(req *http.Request) (*http.Response, error)
 	return c.client.Do(req)
 }
 
+// getAuthorizationHeader returns the authorization header of an app repository, empty if it has none.
+// The header is created by the credential provider of the app repository if one is selected, otherwise
+// the configured header is used.
+func (c *Client) getAuthorizationHeader(ctx context.Context, namespace string, auth appRepov1.AppRepositoryAuth, client *http.Client) (string, error) {
+	var provider credentials.Provider
+	switch {
+	case auth.CredentialProvider != nil:
+		secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), auth.CredentialProvider.SecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "Unable to read secret %s in namespace %s", auth.CredentialProvider.SecretRef.Name, namespace)
+		}
+		provider, err = credentials.New(auth.CredentialProvider.Name, secret.Data)
+		if err != nil {
+			return "", err
+		}
+	case auth.Header != nil:
+		secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), auth.Header.SecretKeyRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "Unable to read secret %s in namespace %s", auth.Header.SecretKeyRef.Name, namespace)
+		}
+		provider, err = credentials.FromAuthorizationHeader(string(secret.Data[auth.Header.SecretKeyRef.Key]))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	// Token endpoints are requested with the proxy, CA and timeout of the app repository
+	return provider.AuthorizationHeader(credentials.WithHTTPClient(ctx, client))
+}
+
 // InitNetClient returns an HTTP client based on the chart details loading a
 // custom CA if provided (as a secret)
 func (c *Client) InitNetClient(ctx context.Context, details *Details) (HTTPClient, error) {
-	log := logUtils.GetLogger(ctx)
+	namespace := util.GetPodNamespace()
+
+	// We grab the specified app repository (for later access to the repo URL, as well as any specified
+	// auth).
+	appRepo, err := c.appRepoClient.KubeappsV1alpha1().AppRepositories(namespace).Get(details.AppRepositoryResourceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to get app repository %s", details.AppRepositoryResourceName)
+	}
+	c.appRepo = appRepo
+
+	return c.initNetClient(ctx, appRepo)
+}
+
+// initNetClient returns an HTTP client with the CA, auth header and transport settings configured for the app repository
+func (c *Client) initNetClient(ctx context.Context, appRepo *appRepov1.AppRepository) (HTTPClient, error) {
+	client, err := c.newRepoClient(appRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultHeaders := http.Header{"User-Agent": []string{c.userAgent}}
+	authHeader, err := c.getAuthorizationHeader(ctx, util.GetPodNamespace(), appRepo.Spec.Auth, client)
+	if err != nil {
+		return nil, err
+	}
+	if authHeader != "" {
+		defaultHeaders.Set("Authorization", authHeader)
+	}
+
+	// Return Transport for testing purposes
+	return &clientWithDefaultHeaders{
+		client:         client,
+		defaultHeaders: defaultHeaders,
+	}, nil
+}
 
+// newRepoClient returns an HTTP client with the CA, client certificate and transport settings configured
+// for the app repository
+func (c *Client) newRepoClient(appRepo *appRepov1.AppRepository) (*http.Client, error) {
 	// Require the SystemCertPool unless the env var is explicitly set.
 	caCertPool, err := x509.SystemCertPool()
 	if err != nil {
@@ -296,14 +381,6 @@ func (c *Client) InitNetClient(ctx context.Context, details *Details) (HTTPClien
 	}
 
 	namespace := util.GetPodNamespace()
-
-	// We grab the specified app repository (for later access to the repo URL, as well as any specified
-	// auth).
-	appRepo, err := c.appRepoClient.KubeappsV1alpha1().AppRepositories(namespace).Get(details.AppRepositoryResourceName, metav1.GetOptions{})
-	if err != nil {
-		return nil, errors.Wrapf(err, "Unable to get app repository %s", details.AppRepositoryResourceName)
-	}
-	c.appRepo = appRepo
 	auth := appRepo.Spec.Auth
 
 	if auth.CustomCA != nil {
@@ -322,70 +399,135 @@ func (c *Client) InitNetClient(ctx context.Context, details *Details) (HTTPClien
 		}
 	}
 
-	defaultHeaders := http.Header{"User-Agent": []string{c.userAgent}}
-	if auth.Header != nil {
-		secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), auth.Header.SecretKeyRef.Name, metav1.GetOptions{})
+	certificates := []tls.Certificate{}
+	if auth.ClientCert != nil {
+		clientCertSecret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), auth.ClientCert.SecretRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, errors.Wrapf(err, "Unable to read secret %s in namespace %s", auth.Header.SecretKeyRef.Name, namespace)
+			return nil, errors.Wrapf(err, "Unable to read secret %s in namespace %s", auth.ClientCert.SecretRef.Name, namespace)
 		}
-		authHeader := string(secret.Data[auth.Header.SecretKeyRef.Key])
-
-		if strings.HasPrefix(authHeader, "Basic ") {
-			trimmedBasicHeader := strings.TrimPrefix(authHeader, "Basic ")
-			username, password, err := util.DecodeBasicAuthCredentials(trimmedBasicHeader)
-			if err != nil {
-				return nil, err
-			}
-			if username == "_json_key" {
-				log.Info("starting gcloud oauth flow")
-				accessToken, err := util.GetGCloudAccessToken(password)
-				if err != nil {
-					return nil, err
-				}
-				authHeader = "Bearer " + accessToken
-				log.Info("successfully performed gcloud oauth flow and set access token in authorization header")
-			}
+		clientCert, err := tls.X509KeyPair(clientCertSecret.Data[corev1.TLSCertKey], clientCertSecret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not load client certificate from secret %s", auth.ClientCert.SecretRef.Name)
 		}
-		defaultHeaders.Set("Authorization", authHeader)
+		certificates = append(certificates, clientCert)
 	}
 
-	// Return Transport for testing purposes
-	return &clientWithDefaultHeaders{
-		client: &http.Client{
-			Timeout: time.Second * defaultTimeoutSeconds,
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				TLSClientConfig: &tls.Config{
-					RootCAs:    caCertPool,
-					MinVersion: tls.VersionTLS12,
-				},
-			},
-		},
-		defaultHeaders: defaultHeaders,
-	}, nil
+	return newRepoHTTPClient(appRepo.Spec.Transport, &tls.Config{
+		RootCAs:      caCertPool,
+		Certificates: certificates,
+		MinVersion:   tls.VersionTLS12,
+	})
 }
 
-// GetChart retrieves and loads a Chart from a registry
-func (c *Client) GetChart(details *Details, netClient HTTPClient) (*chart.Chart, error) {
-	repoURL := c.appRepo.Spec.URL
-	if repoURL == "" {
-		return nil, errors.New("apprepo URL is empty")
+// GetChart retrieves and loads a Chart from a registry. Dependencies which are not
+// vendored in the chart are resolved from the configured app repositories.
+func (c *Client) GetChart(ctx context.Context, details *Details, netClient HTTPClient) (*chart.Chart, error) {
+	ch, err := c.fetchChart(ctx, netClient, details.ChartName, details.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.resolveDependencies(ctx, ch, 0)
+	if err != nil {
+		return nil, err
 	}
-	repoURL = strings.TrimSuffix(strings.TrimSpace(repoURL), "/") + "/index.yaml"
+	return ch, nil
+}
 
-	repoIndex, err := fetchRepoIndex(netClient, repoURL)
+// fetchChart retrieves and loads a Chart from the app repository of the client if the
+// policy of the app repository allows to install it.
+func (c *Client) fetchChart(ctx context.Context, netClient HTTPClient, chartName, chartVersion string) (*chart.Chart, error) {
+	if err := checkChartName(c.appRepo, chartName); err != nil {
+		return nil, err
+	}
+
+	ch, err := c.loadRepoChart(ctx, netClient, chartName, chartVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	chartURL, err := findChartInRepoIndex(repoIndex, repoURL, details.ChartName, details.Version)
+	if err := checkChartVersion(c.appRepo, ch.Metadata); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// loadRepoChart retrieves and loads a Chart from the app repository of the client. The mirrors
+// of the app repository are used if the repository is not reachable. Chart archives are
+// served from the chart cache if it is enabled.
+func (c *Client) loadRepoChart(ctx context.Context, netClient HTTPClient, chartName, chartVersion string) (*chart.Chart, error) {
+	if c.appRepo.Spec.URL == "" {
+		return nil, errors.New("apprepo URL is empty")
+	}
+	repoURLs := append([]string{c.appRepo.Spec.URL}, c.appRepo.Spec.Mirrors...)
+
+	if c.appRepo.Spec.Type == GitRepoType {
+		// Charts of git repositories have no provenance files which could be verified
+		if c.appRepo.Spec.Verification != nil {
+			return nil, errorUtils.UnprocessableEntity.NewErrorf("Chart verification is not supported for git repository %s, remove the verification of the app repository", c.appRepo.Name)
+		}
+		var ch *chart.Chart
+		_, err := downloadFailover.do(ctx, repoURLs, func(repoURL string) error {
+			var err error
+			ch, err = c.getGitChart(ctx, repoURL, chartName, chartVersion)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ch, nil
+	}
+
+	if c.appRepo.Spec.Type == OCIRepoType {
+		var ch *chart.Chart
+		_, err := downloadFailover.do(ctx, repoURLs, func(repoURL string) error {
+			var err error
+			ch, err = c.getOCIChart(ctx, netClient, repoURL, chartName, chartVersion)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ch, nil
+	}
+
+	indexURLs := repoIndexURLs(repoURLs)
+	repoIndex, indexURL, err := fetchRepoIndex(ctx, netClient, indexURLs)
 	if err != nil {
 		return nil, err
 	}
 
-	chartRequested, err := fetchChart(netClient, chartURL, c.load)
+	// Prefer the chart URLs relative to the index URL which was reachable
+	cv, chartURLs, err := findChartInRepoIndex(repoIndex, append([]string{indexURL}, indexURLs...), chartName, chartVersion)
 	if err != nil {
 		return nil, err
 	}
-	return chartRequested, nil
+
+	cacheKey := chartCacheKey(cv.Digest, chartURLs[0], cv.Version)
+	chartURL := chartURLs[0]
+	data, cached := chartCache.get(cacheKey)
+	if cached {
+		logUtils.GetLogger(ctx).Infof("chart %s %s served from chart cache", chartName, cv.Version)
+	} else {
+		chartURL, err = downloadFailover.do(ctx, chartURLs, func(chartURL string) error {
+			var err error
+			data, err = downloadChart(netClient, chartURL)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.appRepo.Spec.Verification != nil {
+		err = c.verifyChart(netClient, chartURL, cv.Digest, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cached {
+		cacheChart(ctx, cacheKey, data)
+	}
+	return c.load(bytes.NewReader(data))
 }
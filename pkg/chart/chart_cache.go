@@ -0,0 +1,252 @@
+package chart
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultChartCacheMaxBytes = 1024 * 1024 * 1024
+	chartCacheFileSuffix      = ".tgz"
+	chartCacheTmpPrefix       = "download-"
+)
+
+// ChartCacheStats contains the statistics of the chart archive cache
+type ChartCacheStats struct {
+	// Hits is the number of chart archives served from the cache
+	Hits uint64 `json:"hits"`
+	// Misses is the number of chart archives which were downloaded
+	Misses uint64 `json:"misses"`
+	// Evictions is the number of chart archives removed to stay within the size limit
+	Evictions uint64 `json:"evictions"`
+	// Entries is the number of cached chart archives
+	Entries int `json:"entries"`
+	// Bytes is the size of the cached chart archives
+	Bytes int64 `json:"bytes"`
+	// MaxBytes is the size limit of the cache
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+type cachedChart struct {
+	key  string
+	size int64
+}
+
+// chartArchiveCache is a LRU cache for chart archives on disk bounded by the size of the
+// archives. Archives are stored under their content digest if it is known, otherwise
+// under the hash of their URL and version. The cache is disabled if no directory is set.
+type chartArchiveCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	entries  map[string]*list.Element
+	lru      *list.List
+	stats    ChartCacheStats
+}
+
+func newChartArchiveCache(dir string, maxBytes int64) *chartArchiveCache {
+	return &chartArchiveCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		stats:    ChartCacheStats{MaxBytes: maxBytes},
+	}
+}
+
+// Cache downloaded chart archives on disk, disabled by default
+// nolint
+var chartCache = newChartArchiveCache("", defaultChartCacheMaxBytes)
+
+// ConfigureChartCache enables the chart archive cache in the directory with the given size
+// limit. Archives which are already in the directory, e.g. on a mounted volume, are reused.
+// An empty directory disables the cache.
+func ConfigureChartCache(dir string, maxBytes int64) error {
+	c := newChartArchiveCache(dir, maxBytes)
+	if c.enabled() {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.Wrapf(err, "Could not create chart cache directory %s", dir)
+		}
+		if err := c.load(); err != nil {
+			return err
+		}
+	}
+	chartCache = c
+	return nil
+}
+
+// GetChartCacheStats returns the current statistics of the chart archive cache
+func GetChartCacheStats() ChartCacheStats {
+	return chartCache.getStats()
+}
+
+// chartCacheKey returns the cache key of a chart archive. The digest is used if it is
+// known, otherwise the URL and the version identify the archive.
+func chartCacheKey(digest, chartURL, version string) string {
+	digest = strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+	if decoded, err := hex.DecodeString(digest); err == nil && len(decoded) == sha256.Size {
+		return "sha256-" + digest
+	}
+	sum := sha256.Sum256([]byte(chartURL + "\x00" + version))
+	return "url-" + hex.EncodeToString(sum[:])
+}
+
+func (c *chartArchiveCache) enabled() bool {
+	return c.dir != ""
+}
+
+func (c *chartArchiveCache) path(key string) string {
+	return filepath.Join(c.dir, key+chartCacheFileSuffix)
+}
+
+// load adds the archives of the cache directory to the cache. The modification time of an
+// archive is its last access.
+func (c *chartArchiveCache) load() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read chart cache directory %s", c.dir)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), chartCacheTmpPrefix) {
+			// Left over from an interrupted write
+			_ = os.Remove(filepath.Join(c.dir, file.Name()))
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(file.Name(), chartCacheFileSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(file.Name(), chartCacheFileSuffix)
+		c.entries[key] = c.lru.PushFront(&cachedChart{key: key, size: file.Size()})
+		c.stats.Bytes += file.Size()
+	}
+	c.stats.Entries = len(c.entries)
+	c.evict()
+	return nil
+}
+
+// get returns the cached archive. Archives stored under their digest are verified
+// and removed if they do not match. The archive is read without holding the lock of
+// the cache so that reads of large archives do not block other lookups.
+func (c *chartArchiveCache) get(key string) ([]byte, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+
+	// Archives are replaced by renames, so the file is either read completely or not at all
+	data, err := ioutil.ReadFile(c.path(key))
+	valid := err == nil && matchesKey(key, data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !valid {
+		// Only evict the entry which was read, it might have been replaced in the meantime
+		if current, ok := c.entries[key]; ok && current == elem {
+			c.remove(elem)
+		}
+		c.stats.Misses++
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	c.stats.Hits++
+	return data, true
+}
+
+// matchesKey returns false if the key is a digest which does not match the archive
+func matchesKey(key string, data []byte) bool {
+	if !strings.HasPrefix(key, "sha256-") {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == strings.TrimPrefix(key, "sha256-")
+}
+
+// put stores the archive and evicts the least recently used archives above the size
+// limit. Archives larger than the limit or not matching their digest are not cached.
+func (c *chartArchiveCache) put(key string, data []byte) error {
+	if !c.enabled() || int64(len(data)) > c.maxBytes || !matchesKey(key, data) {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	// Write to a temporary file first so that concurrent readers never see partial archives
+	tmpFile, err := ioutil.TempFile(c.dir, chartCacheTmpPrefix)
+	if err != nil {
+		return errors.Wrap(err, "Could not create file in chart cache")
+	}
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return errors.Wrap(err, "Could not write chart to cache")
+	}
+
+	size := int64(len(data))
+	c.entries[key] = c.lru.PushFront(&cachedChart{key: key, size: size})
+	c.stats.Bytes += size
+	c.stats.Entries = len(c.entries)
+	c.evict()
+	return nil
+}
+
+func (c *chartArchiveCache) evict() {
+	for c.stats.Bytes > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.remove(elem)
+		c.stats.Evictions++
+	}
+}
+
+func (c *chartArchiveCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cachedChart)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	_ = os.Remove(c.path(entry.key))
+	c.stats.Bytes -= entry.size
+	c.stats.Entries = len(c.entries)
+}
+
+func (c *chartArchiveCache) getStats() ChartCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -0,0 +1,239 @@
+package chart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+func digestKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256-" + hex.EncodeToString(sum[:])
+}
+
+func newTestChartCache(t *testing.T, maxBytes int64) (*chartArchiveCache, string) {
+	dir, err := ioutil.TempDir("", "chart-cache")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	c := newChartArchiveCache(dir, maxBytes)
+	if err := c.load(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return c, dir
+}
+
+func TestChartCacheKey(t *testing.T) {
+	digest := strings.Repeat("ab", sha256.Size)
+	testCases := []struct {
+		digest   string
+		expected string
+	}{
+		{digest, "sha256-" + digest},
+		{"sha256:" + digest, "sha256-" + digest},
+		{"", "url-"},
+		// Invalid digests must not be used as file names
+		{"../../etc/passwd", "url-"},
+	}
+	for _, tc := range testCases {
+		if got := chartCacheKey(tc.digest, "http://charts.example.com/foo-1.0.0.tgz", "1.0.0"); !strings.HasPrefix(got, tc.expected) {
+			t.Errorf("got: %q, want prefix: %q", got, tc.expected)
+		}
+	}
+	if chartCacheKey("", "http://charts.example.com/foo-1.0.0.tgz", "1.0.0") == chartCacheKey("", "http://charts.example.com/foo-1.0.0.tgz", "1.0.1") {
+		t.Errorf("expected different keys for different versions")
+	}
+}
+
+func TestChartCacheEviction(t *testing.T) {
+	c, dir := newTestChartCache(t, 10)
+	defer os.RemoveAll(dir)
+
+	first := []byte("aaaa")
+	second := []byte("bbbb")
+	third := []byte("cccc")
+	for _, data := range [][]byte{first, second} {
+		if err := c.put(digestKey(data), data); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	// Access the first archive so that the second one is the least recently used
+	if data, ok := c.get(digestKey(first)); !ok || string(data) != string(first) {
+		t.Fatalf("expected first archive to be cached")
+	}
+	if err := c.put(digestKey(third), third); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, ok := c.get(digestKey(second)); ok {
+		t.Errorf("expected second archive to be evicted")
+	}
+	if _, err := os.Stat(c.path(digestKey(second))); !os.IsNotExist(err) {
+		t.Errorf("expected evicted archive to be removed from disk")
+	}
+	for _, data := range [][]byte{first, third} {
+		if _, ok := c.get(digestKey(data)); !ok {
+			t.Errorf("expected %s to be cached", data)
+		}
+	}
+
+	stats := c.getStats()
+	if got, want := stats, (ChartCacheStats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2, Bytes: 8, MaxBytes: 10}); got != want {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+
+	// Archives larger than the cache and archives not matching their digest are not cached
+	if err := c.put(digestKey([]byte("too large archive")), []byte("too large archive")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := c.put(digestKey(first), []byte("oops")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := c.getStats().Bytes, int64(8); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if data, _ := c.get(digestKey(first)); string(data) != string(first) {
+		t.Errorf("got: %q, want: %q", data, first)
+	}
+}
+
+func TestChartCacheEvictsCorruptArchives(t *testing.T) {
+	c, dir := newTestChartCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	data := []byte("archive")
+	if err := c.put(digestKey(data), data); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// Concurrent lookups do not block each other and see the complete archive
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cached, ok := c.get(digestKey(data)); !ok || string(cached) != string(data) {
+				t.Errorf("got: %q, want: %q", cached, data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ioutil.WriteFile(c.path(digestKey(data)), []byte("corrupt"), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := c.get(digestKey(data)); ok {
+		t.Errorf("expected corrupt archive not to be served")
+	}
+	if _, err := os.Stat(c.path(digestKey(data))); !os.IsNotExist(err) {
+		t.Errorf("expected corrupt archive to be removed from disk")
+	}
+	if got, want := c.getStats(), (ChartCacheStats{Hits: 10, Misses: 1, MaxBytes: 100}); got != want {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestChartCacheSurvivesRestart(t *testing.T) {
+	c, dir := newTestChartCache(t, 10)
+	defer os.RemoveAll(dir)
+
+	older := []byte("older")
+	newer := []byte("newer")
+	for _, data := range [][]byte{older, newer} {
+		if err := c.put(digestKey(data), data); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(c.path(digestKey(older)), past, past); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, chartCacheTmpPrefix+"123"), []byte("partial"), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The cache of the new process is smaller and only keeps the most recently used archive
+	restarted := newChartArchiveCache(dir, 6)
+	if err := restarted.load(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := restarted.get(digestKey(newer)); !ok {
+		t.Errorf("expected archive to survive the restart")
+	}
+	if _, ok := restarted.get(digestKey(older)); ok {
+		t.Errorf("expected least recently used archive to be evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, chartCacheTmpPrefix+"123")); !os.IsNotExist(err) {
+		t.Errorf("expected partial download to be removed")
+	}
+}
+
+func TestGetChartUsesChartCache(t *testing.T) {
+	downloads := 0
+	repo := &fakeChartRepo{t: t, charts: []*chart.Chart{newChart("test", "1.0.0")}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".tgz") {
+			downloads++
+		}
+		repo.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "chart-cache")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ConfigureChartCache(dir, defaultChartCacheMaxBytes); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer func() {
+		chartCache = newChartArchiveCache("", defaultChartCacheMaxBytes)
+	}()
+	repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+
+	nullLogger, _ := test.NewNullLogger()
+	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+	chUtils := Client{
+		load: loader.LoadArchive,
+		appRepo: &appRepov1.AppRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "cached", Namespace: metav1.NamespaceSystem},
+			Spec:       appRepov1.AppRepositorySpec{URL: server.URL},
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		ch, err := chUtils.GetChart(ctx, &Details{ChartName: "test", Version: "1.0.0"}, &clientWithDefaultHeaders{client: http.DefaultClient})
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if got, want := ch.Metadata.Version, "1.0.0"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	}
+	if got, want := downloads, 1; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if got, want := GetChartCacheStats().Hits, uint64(2); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
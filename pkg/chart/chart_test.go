@@ -18,12 +18,20 @@ package chart
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -100,15 +108,56 @@ func TestFindChartInRepoIndex(t *testing.T) {
 	entries[name] = chartVersions
 	index := &repo.IndexFile{APIVersion: "v1", Generated: time.Now(), Entries: entries}
 
-	res, err := findChartInRepoIndex(index, repoURL, name, version)
+	cv, res, err := findChartInRepoIndex(index, []string{repoURL}, name, version)
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
-	if res != expectedURL {
+	if cv != &chartVersion {
+		t.Errorf("Expecting the index entry of %s %s", name, version)
+	}
+	if len(res) != 1 || res[0] != expectedURL {
 		t.Errorf("Expecting %s to be resolved as %s", res, expectedURL)
 	}
 }
 
+func TestFindChartInRepoIndexWithConstraint(t *testing.T) {
+	repoURL := "http://charts.example.com/repo/"
+	chartVersions := repo.ChartVersions{}
+	// Entries are deliberately not sorted
+	for _, version := range []string{"1.4.2", "2.0.0-rc.1", "1.5.0", "1.4.10"} {
+		chartVersions = append(chartVersions, &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: "foo", Version: version},
+			URLs:     []string{fmt.Sprintf("foo-%s.tgz", version)},
+		})
+	}
+	index := &repo.IndexFile{APIVersion: "v1", Generated: time.Now(), Entries: map[string]repo.ChartVersions{"foo": chartVersions}}
+
+	testCases := []struct {
+		constraint      string
+		expectedVersion string
+	}{
+		{"~1.4", "1.4.10"},
+		{"", "1.5.0"},
+		{"^2.0.0-0", "2.0.0-rc.1"},
+	}
+	for _, tc := range testCases {
+		cv, chartURLs, err := findChartInRepoIndex(index, []string{repoURL}, "foo", tc.constraint)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if got, want := cv.Version, tc.expectedVersion; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+		if got, want := chartURLs[0], fmt.Sprintf("%sfoo-%s.tgz", repoURL, tc.expectedVersion); got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	}
+
+	if _, _, err := findChartInRepoIndex(index, []string{repoURL}, "foo", "^3"); err == nil {
+		t.Errorf("expected error for unresolvable constraint")
+	}
+}
+
 func TestParseDetails(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -143,6 +192,31 @@ func TestParseDetails(t *testing.T) {
 			}`,
 			err: true,
 		},
+		{
+			name: "parses request with version constraint",
+			data: `{
+				"appRepositoryResourceName": "my-chart-repo",
+				"chartName": "test",
+				"releaseName": "foo",
+				"version": ">=1.0 <2"
+			}`,
+			expected: &Details{
+				AppRepositoryResourceName: "my-chart-repo",
+				ChartName:                 "test",
+				ReleaseName:               "foo",
+				Version:                   ">=1.0 <2",
+			},
+		},
+		{
+			name: "errors if version is no valid constraint",
+			data: `{
+				"appRepositoryResourceName": "my-chart-repo",
+				"chartName": "test",
+				"releaseName": "foo",
+				"version": "latest"
+			}`,
+			err: true,
+		},
 		{
 			name: "errors if appRepositoryResourceName is empty",
 			data: `{
@@ -443,6 +517,119 @@ func TestInitNetClient(t *testing.T) {
 	}
 }
 
+// selfSignedClientCert returns a PEM encoded client certificate and its private key
+func selfSignedClientCert(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hub"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestInitNetClientWithClientCert(t *testing.T) {
+	certPEM, keyPEM := selfSignedClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(certPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := []struct {
+		name          string
+		secretData    map[string][]byte
+		errorExpected bool
+	}{
+		{
+			name:       "client certificate is presented to the repository",
+			secretData: map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+		},
+		{
+			name:          "errors if the private key is missing",
+			secretData:    map[string][]byte{corev1.TLSCertKey: certPEM},
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient := fakeK8s.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "server-ca", Namespace: metav1.NamespaceSystem},
+				Data:       map[string][]byte{"ca.crt": serverCA},
+			}, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "client-cert", Namespace: metav1.NamespaceSystem},
+				Type:       corev1.SecretTypeTLS,
+				Data:       tc.secretData,
+			})
+			appRepoClient := fakeAppRepo.NewSimpleClientset(&appRepov1.AppRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "mtls", Namespace: metav1.NamespaceSystem},
+				Spec: appRepov1.AppRepositorySpec{
+					URL: server.URL,
+					Auth: appRepov1.AppRepositoryAuth{
+						CustomCA: &appRepov1.AppRepositoryCustomCA{
+							SecretKeyRef: corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "server-ca"},
+								Key:                  "ca.crt",
+							},
+						},
+						ClientCert: &appRepov1.AppRepositoryClientCert{
+							SecretRef: corev1.LocalObjectReference{Name: "client-cert"},
+						},
+					},
+				},
+			})
+			chUtils := Client{kubeClient: kubeClient, appRepoClient: appRepoClient}
+
+			nullLogger, _ := test.NewNullLogger()
+			ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+
+			httpClient, err := chUtils.InitNetClient(ctx, &Details{AppRepositoryResourceName: "mtls"})
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			req, err := getReq(server.URL)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			res, err := httpClient.Do(req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			res.Body.Close()
+			if got, want := res.StatusCode, http.StatusOK; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
 // Fake server for repositories and charts
 type fakeHTTPClient struct {
 	repoURL   string
@@ -540,6 +727,7 @@ func TestGetChart(t *testing.T) {
 	for _, tc := range testCases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
+			repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
 			httpClient := newHTTPClient(repoURL, []Details{target}, tc.userAgent)
 			kubeClient := fakeK8s.NewSimpleClientset()
 			chUtils := Client{
@@ -556,7 +744,7 @@ func TestGetChart(t *testing.T) {
 					},
 				},
 			}
-			ch, err := chUtils.GetChart(&target, httpClient)
+			ch, err := chUtils.GetChart(context.TODO(), &target, httpClient)
 
 			if err != nil {
 				t.Errorf("Unexpected error: %v", err)
@@ -587,21 +775,6 @@ func TestGetChart(t *testing.T) {
 	}
 }
 
-func TestGetIndexFromCache(t *testing.T) {
-	repoURL := "https://test.com"
-	data := []byte("foo")
-	index, sha := getIndexFromCache(repoURL, data)
-	if index != nil {
-		t.Error("Index should be empty since it's not in the cache yet")
-	}
-	fakeIndex := &repo.IndexFile{}
-	storeIndexInCache(repoURL, fakeIndex, sha)
-	index, _ = getIndexFromCache(repoURL, data)
-	if index != fakeIndex {
-		t.Error("It should return the stored index")
-	}
-}
-
 func TestClientWithDefaultHeaders(t *testing.T) {
 	testCases := []struct {
 		name            string
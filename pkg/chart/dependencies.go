@@ -0,0 +1,136 @@
+package chart
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+	"github.com/gardener/potter-hub/pkg/util"
+)
+
+// maxDependencyDepth limits how deep missing dependencies of resolved dependencies are resolved
+const maxDependencyDepth = 5
+
+// missingDependencies returns the dependencies of the chart which are not vendored in its
+// charts directory. Versions pinned in the Chart.lock take precedence over the version
+// constraints of the Chart.yaml.
+func missingDependencies(ch *chart.Chart) []*chart.Dependency {
+	if ch.Metadata == nil {
+		return nil
+	}
+
+	vendored := map[string]bool{}
+	for _, subchart := range ch.Dependencies() {
+		if subchart.Metadata != nil {
+			vendored[subchart.Name()] = true
+		}
+	}
+
+	locked := map[string]*chart.Dependency{}
+	if ch.Lock != nil {
+		for _, dep := range ch.Lock.Dependencies {
+			locked[dep.Name] = dep
+		}
+	}
+
+	missing := []*chart.Dependency{}
+	for _, dep := range ch.Metadata.Dependencies {
+		if vendored[dep.Name] {
+			continue
+		}
+		resolved := *dep
+		if lock, ok := locked[dep.Name]; ok && lock.Version != "" {
+			resolved.Version = lock.Version
+		}
+		missing = append(missing, &resolved)
+	}
+	return missing
+}
+
+func normalizeRepoURL(repoURL string) string {
+	return strings.TrimSuffix(strings.TrimSpace(repoURL), "/")
+}
+
+// findDependencyRepository returns the app repository a dependency refers to. The repository
+// of a dependency is either the URL of the app repository or its name as alias in the form
+// "@name" or "alias:name".
+func findDependencyRepository(appRepos []appRepov1.AppRepository, dep *chart.Dependency) (*appRepov1.AppRepository, error) {
+	if dep.Repository == "" {
+		return nil, errorUtils.UnprocessableEntity.NewErrorf("Dependency %q is not vendored and has no repository", dep.Name)
+	}
+
+	alias := ""
+	if strings.HasPrefix(dep.Repository, "@") {
+		alias = strings.TrimPrefix(dep.Repository, "@")
+	} else if strings.HasPrefix(dep.Repository, "alias:") {
+		alias = strings.TrimPrefix(dep.Repository, "alias:")
+	}
+
+	for i := range appRepos {
+		appRepo := &appRepos[i]
+		if alias != "" {
+			if appRepo.Name == alias {
+				return appRepo, nil
+			}
+			continue
+		}
+		if normalizeRepoURL(appRepo.Spec.URL) == normalizeRepoURL(dep.Repository) {
+			return appRepo, nil
+		}
+	}
+	return nil, errorUtils.UnprocessableEntity.NewErrorf("No app repository found for repository %q of dependency %q", dep.Repository, dep.Name)
+}
+
+// resolveDependencies downloads the dependencies which are not vendored in the chart from the
+// configured app repositories, using the credentials of the respective app repository, and
+// adds them to the chart.
+func (c *Client) resolveDependencies(ctx context.Context, ch *chart.Chart, depth int) error {
+	missing := missingDependencies(ch)
+	if len(missing) == 0 {
+		return nil
+	}
+	if depth >= maxDependencyDepth {
+		return errorUtils.UnprocessableEntity.NewErrorf("Dependencies of chart %q are nested deeper than %d levels", ch.Name(), maxDependencyDepth)
+	}
+
+	log := logUtils.GetLogger(ctx)
+	namespace := util.GetPodNamespace()
+
+	appRepos, err := c.appRepoClient.KubeappsV1alpha1().AppRepositories(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Unable to list app repositories in namespace %s", namespace)
+	}
+
+	for _, dep := range missing {
+		appRepo, err := findDependencyRepository(appRepos.Items, dep)
+		if err != nil {
+			return err
+		}
+
+		netClient, err := c.initNetClient(ctx, appRepo)
+		if err != nil {
+			return err
+		}
+
+		depClient := *c
+		depClient.appRepo = appRepo
+		subchart, err := depClient.fetchChart(ctx, netClient, dep.Name, dep.Version)
+		if err != nil {
+			return errors.Wrapf(err, "Could not resolve dependency %q of chart %q", dep.Name, ch.Name())
+		}
+		err = depClient.resolveDependencies(ctx, subchart, depth+1)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("resolved dependency %s %s of chart %s from app repository %s", dep.Name, subchart.Metadata.Version, ch.Name(), appRepo.Name)
+		ch.AddDependency(subchart)
+	}
+	return nil
+}
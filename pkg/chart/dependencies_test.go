@@ -0,0 +1,223 @@
+package chart
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeK8s "k8s.io/client-go/kubernetes/fake"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	fakeAppRepo "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/client/clientset/versioned/fake"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+// chartArchive packages the chart and returns the archive
+func chartArchive(t *testing.T, ch *chart.Chart) []byte {
+	dir, err := ioutil.TempDir("", "chart-archive")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	chartPath, err := chartutil.Save(ch, dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	data, err := ioutil.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return data
+}
+
+// fakeChartRepo serves an index and chart archives. All requests must carry the authorization header if set.
+type fakeChartRepo struct {
+	charts        []*chart.Chart
+	authorization string
+	t             *testing.T
+}
+
+func (f *fakeChartRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.authorization != "" && r.Header.Get("Authorization") != f.authorization {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	index := &repo.IndexFile{APIVersion: "v1", Generated: time.Now(), Entries: map[string]repo.ChartVersions{}}
+	for _, ch := range f.charts {
+		fileName := fmt.Sprintf("%s-%s.tgz", ch.Name(), ch.Metadata.Version)
+		if r.URL.Path == "/"+fileName {
+			_, _ = w.Write(chartArchive(f.t, ch))
+			return
+		}
+		index.Entries[ch.Name()] = append(index.Entries[ch.Name()], &repo.ChartVersion{Metadata: ch.Metadata, URLs: []string{fileName}})
+	}
+	if r.URL.Path != "/index.yaml" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(index)
+}
+
+func newChart(name, version string, dependencies ...*chart.Dependency) *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version, Dependencies: dependencies},
+	}
+}
+
+func TestGetChartResolvesDependencies(t *testing.T) {
+	mirror := httptest.NewServer(&fakeChartRepo{
+		t:             t,
+		authorization: "Bearer mirror-token",
+		charts: []*chart.Chart{
+			newChart("postgresql", "8.1.0"),
+			newChart("postgresql", "8.2.0"),
+			newChart("postgresql", "9.0.0"),
+			newChart("redis", "10.0.0"),
+		},
+	})
+	defer mirror.Close()
+
+	vendoredDependency := newChart("common", "1.0.0")
+	platformChart := newChart("platform", "1.0.0",
+		&chart.Dependency{Name: "common", Version: "1.0.0", Repository: "@internal"},
+		&chart.Dependency{Name: "monitoring", Version: "^2.0.0", Repository: "@internal"},
+		&chart.Dependency{Name: "postgresql", Version: "^8.0.0", Repository: mirror.URL + "/", Alias: "db"},
+		&chart.Dependency{Name: "redis", Version: "^10", Repository: "alias:mirror"},
+	)
+	platformChart.AddDependency(vendoredDependency)
+	platformChart.Lock = &chart.Lock{Dependencies: []*chart.Dependency{{Name: "postgresql", Version: "8.1.0"}}}
+
+	internal := httptest.NewServer(&fakeChartRepo{
+		t: t,
+		charts: []*chart.Chart{
+			platformChart,
+			newChart("monitoring", "2.3.0", &chart.Dependency{Name: "redis", Version: "10.0.0", Repository: mirror.URL}),
+		},
+	})
+	defer internal.Close()
+
+	internalRepo := &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: metav1.NamespaceSystem},
+		Spec:       appRepov1.AppRepositorySpec{URL: internal.URL},
+	}
+	mirrorRepo := &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror", Namespace: metav1.NamespaceSystem},
+		Spec: appRepov1.AppRepositorySpec{
+			URL: mirror.URL,
+			Auth: appRepov1.AppRepositoryAuth{
+				Header: &appRepov1.AppRepositoryAuthHeader{
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "mirror-auth"},
+						Key:                  "authorizationHeader",
+					},
+				},
+			},
+		},
+	}
+
+	chUtils := Client{
+		kubeClient: fakeK8s.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "mirror-auth", Namespace: metav1.NamespaceSystem},
+			Data:       map[string][]byte{"authorizationHeader": []byte("Bearer mirror-token")},
+		}),
+		appRepoClient: fakeAppRepo.NewSimpleClientset(internalRepo, mirrorRepo),
+		load:          loader.LoadArchive,
+	}
+
+	nullLogger, _ := test.NewNullLogger()
+	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+
+	repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+	details := &Details{AppRepositoryResourceName: "internal", ChartName: "platform", Version: "1.0.0"}
+	netClient, err := chUtils.InitNetClient(ctx, details)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	ch, err := chUtils.GetChart(ctx, details, netClient)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	versions := map[string]string{}
+	for _, dep := range ch.Dependencies() {
+		versions[dep.Name()] = dep.Metadata.Version
+	}
+	expected := map[string]string{"common": "1.0.0", "monitoring": "2.3.0", "postgresql": "8.1.0", "redis": "10.0.0"}
+	if len(versions) != len(expected) {
+		t.Errorf("got: %v, want: %v", versions, expected)
+	}
+	for name, version := range expected {
+		if got, want := versions[name], version; got != want {
+			t.Errorf("%s got: %q, want: %q", name, got, want)
+		}
+	}
+
+	for _, dep := range ch.Dependencies() {
+		if dep.Name() != "monitoring" {
+			continue
+		}
+		if got, want := len(dep.Dependencies()), 1; got != want {
+			t.Errorf("got: %d, want: %d dependencies of monitoring", got, want)
+		}
+	}
+}
+
+func TestFindDependencyRepository(t *testing.T) {
+	appRepos := []appRepov1.AppRepository{
+		{ObjectMeta: metav1.ObjectMeta{Name: "internal"}, Spec: appRepov1.AppRepositorySpec{URL: "https://charts.internal.example.com/"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "stable"}, Spec: appRepov1.AppRepositorySpec{URL: "https://charts.example.com/stable"}},
+	}
+
+	testCases := []struct {
+		repository    string
+		expectedName  string
+		errorExpected bool
+	}{
+		{"https://charts.internal.example.com", "internal", false},
+		{"https://charts.example.com/stable/", "stable", false},
+		{"@stable", "stable", false},
+		{"alias:internal", "internal", false},
+		{"@unknown", "", true},
+		{"https://charts.example.com/incubator", "", true},
+		{"", "", true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.repository, func(t *testing.T) {
+			appRepo, err := findDependencyRepository(appRepos, &chart.Dependency{Name: "dep", Repository: tc.repository})
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				if code, _ := errorUtils.GetHTTPErrorType(err); code != errorUtils.UnprocessableEntity {
+					t.Errorf("got: %d, want: %d", code, errorUtils.UnprocessableEntity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got, want := appRepo.Name, tc.expectedName; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
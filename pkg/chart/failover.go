@@ -0,0 +1,193 @@
+package chart
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryDelay     = 200 * time.Millisecond
+	defaultHostBackoff    = 5 * time.Second
+	defaultMaxHostBackoff = 5 * time.Minute
+)
+
+// statusError is returned for responses with an unexpected status code
+type statusError struct {
+	statusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("chart download request failed with status code %d", e.statusCode)
+}
+
+// isRetryable returns whether a request failed because of a (possibly temporary) problem
+// of the host, i.e. a network error or a server error.
+func isRetryable(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *statusError:
+		return cause.statusCode >= http.StatusInternalServerError || cause.statusCode == http.StatusTooManyRequests
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+type hostState struct {
+	failures       int
+	unhealthyUntil time.Time
+}
+
+// hostHealth tracks failing hosts. A failing host is considered unhealthy for a backoff
+// which doubles with every consecutive failure.
+type hostHealth struct {
+	mu         sync.Mutex
+	hosts      map[string]*hostState
+	backoff    time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+}
+
+func newHostHealth(backoff, maxBackoff time.Duration) *hostHealth {
+	return &hostHealth{
+		hosts:      map[string]*hostState{},
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		now:        time.Now,
+	}
+}
+
+func hostOf(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return parsedURL.Host
+}
+
+func (h *hostHealth) isHealthy(host string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.hosts[host]
+	return !ok || !h.now().Before(state.unhealthyUntil)
+}
+
+func (h *hostHealth) reportSuccess(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hosts, host)
+}
+
+func (h *hostHealth) reportFailure(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.hosts[host]
+	if !ok {
+		state = &hostState{}
+		h.hosts[host] = state
+	}
+	state.failures++
+
+	backoff := h.backoff
+	for i := 1; i < state.failures && backoff < h.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > h.maxBackoff {
+		backoff = h.maxBackoff
+	}
+	state.unhealthyUntil = h.now().Add(backoff)
+}
+
+// order returns the URLs of healthy hosts before the URLs of unhealthy hosts. Unhealthy
+// hosts are still tried as last resort.
+func (h *hostHealth) order(urls []string) []string {
+	healthy := []string{}
+	unhealthy := []string{}
+	for _, u := range urls {
+		if h.isHealthy(hostOf(u)) {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// failover tries a request against a list of equivalent URLs. Requests which fail because
+// of the host are retried with exponential backoff before the next URL is tried.
+type failover struct {
+	health   *hostHealth
+	attempts int
+	delay    time.Duration
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+func newFailover(health *hostHealth, attempts int, delay time.Duration) *failover {
+	return &failover{
+		health:   health,
+		attempts: attempts,
+		delay:    delay,
+		sleep:    sleepContext,
+	}
+}
+
+// sleepContext waits for the duration and returns the error of the context if it is done before
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// nolint
+var downloadFailover = newFailover(newHostHealth(defaultHostBackoff, defaultMaxHostBackoff), defaultRetryAttempts, defaultRetryDelay)
+
+// do calls fn for the URLs until it succeeds and returns the URL which succeeded. Errors with
+// a HTTP error type, e.g. failed verifications, are returned without trying further URLs.
+// The failover stops waiting for retries when the context is done.
+func (f *failover) do(ctx context.Context, urls []string, fn func(url string) error) (string, error) {
+	if len(urls) == 0 {
+		return "", errors.New("No URLs to download from")
+	}
+
+	var lastErr error
+	for _, u := range f.health.order(urls) {
+		host := hostOf(u)
+		delay := f.delay
+		for attempt := 1; ; attempt++ {
+			err := fn(u)
+			if err == nil {
+				f.health.reportSuccess(host)
+				return u, nil
+			}
+			if _, ok := errorUtils.GetHTTPErrorType(err); ok {
+				return "", err
+			}
+			lastErr = err
+
+			if !isRetryable(err) {
+				break
+			}
+			if attempt >= f.attempts {
+				f.health.reportFailure(host)
+				break
+			}
+			if err := f.sleep(ctx, delay); err != nil {
+				return "", errors.Wrapf(lastErr, "Retries aborted: %v", err)
+			}
+			delay *= 2
+		}
+	}
+	return "", lastErr
+}
@@ -0,0 +1,235 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestHostHealthBackoff(t *testing.T) {
+	now := time.Now()
+	health := newHostHealth(time.Second, 3*time.Second)
+	health.now = func() time.Time { return now }
+
+	urls := []string{"https://primary.example.com/index.yaml", "https://mirror.example.com/index.yaml"}
+
+	health.reportFailure("primary.example.com")
+	if health.isHealthy("primary.example.com") {
+		t.Errorf("expected host to be unhealthy after a failure")
+	}
+	if got, want := health.order(urls), []string{urls[1], urls[0]}; !cmp.Equal(got, want) {
+		t.Errorf(cmp.Diff(want, got))
+	}
+
+	now = now.Add(time.Second)
+	if !health.isHealthy("primary.example.com") {
+		t.Errorf("expected host to be healthy after the backoff")
+	}
+
+	// The backoff doubles with every consecutive failure up to the maximum
+	for _, expected := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		health.reportFailure("primary.example.com")
+		now = now.Add(expected - time.Millisecond)
+		if health.isHealthy("primary.example.com") {
+			t.Errorf("expected host to be unhealthy for %s", expected)
+		}
+		now = now.Add(time.Millisecond)
+		if !health.isHealthy("primary.example.com") {
+			t.Errorf("expected host to be healthy after %s", expected)
+		}
+	}
+
+	health.reportSuccess("primary.example.com")
+	health.reportFailure("primary.example.com")
+	now = now.Add(time.Second)
+	if !health.isHealthy("primary.example.com") {
+		t.Errorf("expected backoff to be reset by a success")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	unavailable := errors.WithStack(&statusError{statusCode: http.StatusServiceUnavailable})
+	notFound := errors.WithStack(&statusError{statusCode: http.StatusNotFound})
+
+	testCases := []struct {
+		name            string
+		errors          map[string][]error
+		expectedURL     string
+		expectedCalls   []string
+		expectedSleeps  []time.Duration
+		unhealthyHosts  []string
+		errorExpected   bool
+		expectedErrType errorUtils.HTTPErrorType
+	}{
+		{
+			name:          "first URL succeeds",
+			expectedURL:   "http://a/chart.tgz",
+			expectedCalls: []string{"http://a/chart.tgz"},
+		},
+		{
+			name:           "temporary failures are retried with exponential backoff",
+			errors:         map[string][]error{"http://a/chart.tgz": {unavailable, unavailable}},
+			expectedURL:    "http://a/chart.tgz",
+			expectedCalls:  []string{"http://a/chart.tgz", "http://a/chart.tgz", "http://a/chart.tgz"},
+			expectedSleeps: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		{
+			name:           "failing host is marked unhealthy",
+			errors:         map[string][]error{"http://a/chart.tgz": {unavailable, unavailable, unavailable}},
+			expectedURL:    "http://b/chart.tgz",
+			expectedCalls:  []string{"http://a/chart.tgz", "http://a/chart.tgz", "http://a/chart.tgz", "http://b/chart.tgz"},
+			expectedSleeps: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+			unhealthyHosts: []string{"a"},
+		},
+		{
+			name:          "missing files are not retried",
+			errors:        map[string][]error{"http://a/chart.tgz": {notFound}},
+			expectedURL:   "http://b/chart.tgz",
+			expectedCalls: []string{"http://a/chart.tgz", "http://b/chart.tgz"},
+		},
+		{
+			name: "all URLs fail",
+			errors: map[string][]error{
+				"http://a/chart.tgz": {notFound},
+				"http://b/chart.tgz": {notFound},
+			},
+			expectedCalls: []string{"http://a/chart.tgz", "http://b/chart.tgz"},
+			errorExpected: true,
+		},
+		{
+			name:            "errors with HTTP error type stop the failover",
+			errors:          map[string][]error{"http://a/chart.tgz": {errorUtils.UnprocessableEntity.NewError("verification failed")}},
+			expectedCalls:   []string{"http://a/chart.tgz"},
+			errorExpected:   true,
+			expectedErrType: errorUtils.UnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			health := newHostHealth(time.Minute, time.Hour)
+			f := newFailover(health, 3, 10*time.Millisecond)
+			sleeps := []time.Duration{}
+			f.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			calls := []string{}
+			u, err := f.do(context.TODO(), []string{"http://a/chart.tgz", "http://b/chart.tgz"}, func(u string) error {
+				calls = append(calls, u)
+				if errs := tc.errors[u]; len(errs) > 0 {
+					tc.errors[u] = errs[1:]
+					return errs[0]
+				}
+				return nil
+			})
+
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				if tc.expectedErrType != 0 {
+					if code, _ := errorUtils.GetHTTPErrorType(err); code != tc.expectedErrType {
+						t.Errorf("got: %d, want: %d", code, tc.expectedErrType)
+					}
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got, want := u, tc.expectedURL; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+			if !cmp.Equal(calls, tc.expectedCalls) {
+				t.Errorf(cmp.Diff(tc.expectedCalls, calls))
+			}
+			if len(sleeps) > 0 || len(tc.expectedSleeps) > 0 {
+				if !cmp.Equal(sleeps, tc.expectedSleeps) {
+					t.Errorf(cmp.Diff(tc.expectedSleeps, sleeps))
+				}
+			}
+			for _, host := range tc.unhealthyHosts {
+				if health.isHealthy(host) {
+					t.Errorf("expected host %s to be unhealthy", host)
+				}
+			}
+		})
+	}
+}
+
+func TestFailoverStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	f := newFailover(newHostHealth(time.Minute, time.Hour), 3, time.Hour)
+	calls := 0
+	_, err := f.do(ctx, []string{"http://a/chart.tgz", "http://b/chart.tgz"}, func(u string) error {
+		calls++
+		return &statusError{statusCode: http.StatusServiceUnavailable}
+	})
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if calls != 1 {
+		t.Errorf("got: %d calls, want: 1", calls)
+	}
+}
+
+func TestGetChartFromMirror(t *testing.T) {
+	primaryRequests := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(&fakeChartRepo{t: t, charts: []*chart.Chart{newChart("test", "1.0.0")}})
+	defer mirror.Close()
+
+	repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+	downloadFailover = newFailover(newHostHealth(time.Minute, time.Hour), 2, 0)
+	downloadFailover.sleep = func(context.Context, time.Duration) error { return nil }
+	defer func() {
+		downloadFailover = newFailover(newHostHealth(defaultHostBackoff, defaultMaxHostBackoff), defaultRetryAttempts, defaultRetryDelay)
+	}()
+
+	chUtils := Client{
+		load: loader.LoadArchive,
+		appRepo: &appRepov1.AppRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "mirrored", Namespace: metav1.NamespaceSystem},
+			Spec: appRepov1.AppRepositorySpec{
+				URL:     primary.URL,
+				Mirrors: []string{mirror.URL},
+			},
+		},
+	}
+
+	ch, err := chUtils.GetChart(context.TODO(), &Details{ChartName: "test", Version: "1.0.0"}, &clientWithDefaultHeaders{client: http.DefaultClient})
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if got, want := ch.Metadata.Version, "1.0.0"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	// The index is requested twice from the primary, the chart is downloaded from the mirror
+	if got, want := primaryRequests, 2; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if downloadFailover.health.isHealthy(hostOf(primary.URL)) {
+		t.Errorf("expected primary to be unhealthy")
+	}
+}
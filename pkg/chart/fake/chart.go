@@ -19,14 +19,20 @@ package fake
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"github.com/Masterminds/semver/v3"
 	"helm.sh/helm/v3/pkg/chart"
 
 	chartUtils "github.com/gardener/potter-hub/pkg/chart"
 )
 
-type Chart struct{}
+type Chart struct {
+	// Versions are the chart versions of the repository the requested version is resolved against,
+	// the requested version is used as is if empty
+	Versions []string
+}
 
 func (f *Chart) ParseDetails(data []byte) (*chartUtils.Details, error) {
 	details := &chartUtils.Details{}
@@ -34,18 +40,48 @@ func (f *Chart) ParseDetails(data []byte) (*chartUtils.Details, error) {
 	return details, err
 }
 
-func (f *Chart) GetChart(details *chartUtils.Details, netClient chartUtils.HTTPClient) (*chart.Chart, error) {
+func (f *Chart) GetChart(ctx context.Context, details *chartUtils.Details, netClient chartUtils.HTTPClient) (*chart.Chart, error) {
 	valuesMap := make(map[string]interface{})
 	valuesMap["values"] = details.Values
 
+	version := details.Version
+	if len(f.Versions) > 0 {
+		var err error
+		if version, err = f.resolveVersion(details.Version); err != nil {
+			return nil, err
+		}
+	}
+
 	return &chart.Chart{
 		Metadata: &chart.Metadata{
-			Name: details.ChartName,
+			Name:    details.ChartName,
+			Version: version,
 		},
 		Values: valuesMap,
 	}, nil
 }
 
+func (f *Chart) resolveVersion(versionConstraint string) (string, error) {
+	if versionConstraint == "" {
+		versionConstraint = "*"
+	}
+	constraint, err := semver.NewConstraint(versionConstraint)
+	if err != nil {
+		return "", err
+	}
+	var latest *semver.Version
+	for _, version := range f.Versions {
+		parsed, err := semver.NewVersion(version)
+		if err == nil && constraint.Check(parsed) && (latest == nil || parsed.GreaterThan(latest)) {
+			latest = parsed
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no chart version matches %q", versionConstraint)
+	}
+	return latest.Original(), nil
+}
+
 func (f *Chart) InitNetClient(ctx context.Context, details *chartUtils.Details) (chartUtils.HTTPClient, error) {
 	return &http.Client{}, nil
 }
@@ -0,0 +1,273 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	"github.com/gardener/potter-hub/pkg/util"
+)
+
+const (
+	// GitRepoType is the AppRepositorySpec.Type of repositories which are git repositories containing chart directories
+	GitRepoType = "git"
+
+	// Chart annotations recording the git revision a chart was loaded from
+	GitURLAnnotation    = "hub.k8s.sap.com/git-url"
+	GitRefAnnotation    = "hub.k8s.sap.com/git-ref"
+	GitCommitAnnotation = "hub.k8s.sap.com/git-commit"
+)
+
+// gitAllowedProtocols are the protocols git may use, i.e. no local files or remote helpers
+// like "ext::" which run commands
+// nolint
+var gitAllowedProtocols = "https:http"
+
+// gitCheckout is a temporary checkout of a git repository
+type gitCheckout struct {
+	dir    string
+	env    []string
+	config [][2]string
+}
+
+// setConfig sets a configuration for all git commands. It is passed in the environment
+// so that credentials do not show up in the command line.
+func (g *gitCheckout) setConfig(key, value string) {
+	g.config = append(g.config, [2]string{key, value})
+}
+
+func (g *gitCheckout) run(ctx context.Context, args ...string) (string, error) {
+	// Disable linting because the arguments are passed to git and not to a shell
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(append([]string{}, g.env...), fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(g.config)))
+	for i, config := range g.config {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, config[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, config[1]))
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// writeSecretFile writes the value of a secret key to a file in dir
+func (c *Client) writeSecretFile(namespace, dir, fileName string, ref corev1.SecretKeySelector) (string, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to read secret %s in namespace %s", ref.Name, namespace)
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", errors.Errorf("Secret %q did not contain key %q", ref.Name, ref.Key)
+	}
+	path := filepath.Join(dir, fileName)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", errors.Wrapf(err, "Could not write %s", fileName)
+	}
+	return path, nil
+}
+
+// newGitCheckout creates an empty git repository in dir configured with the credentials of
+// the app repository, i.e. the authorization header, the custom CA and the client certificate.
+func (c *Client) newGitCheckout(ctx context.Context, dir string) (*gitCheckout, error) {
+	namespace := util.GetPodNamespace()
+	auth := c.appRepo.Spec.Auth
+
+	credentialsDir := filepath.Join(dir, "credentials")
+	checkoutDir := filepath.Join(dir, "checkout")
+	for _, d := range []string{credentialsDir, checkoutDir} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			return nil, errors.Wrap(err, "Could not create directory for git checkout")
+		}
+	}
+
+	g := &gitCheckout{
+		dir: checkoutDir,
+		// Never ask for credentials and ignore any configuration of the host
+		env: append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir, "GIT_ALLOW_PROTOCOL="+gitAllowedProtocols), gitProxyEnv(c.appRepo.Spec.Transport)...),
+	}
+	if transport := c.appRepo.Spec.Transport; transport != nil && transport.EnableHTTP2 != nil {
+		if *transport.EnableHTTP2 {
+			g.setConfig("http.version", "HTTP/2")
+		} else {
+			g.setConfig("http.version", "HTTP/1.1")
+		}
+	}
+
+	client, err := c.newRepoClient(c.appRepo)
+	if err != nil {
+		return nil, err
+	}
+	authHeader, err := c.getAuthorizationHeader(ctx, namespace, auth, client)
+	if err != nil {
+		return nil, err
+	}
+	if authHeader != "" {
+		g.setConfig("http.extraHeader", "Authorization: "+authHeader)
+	}
+	if auth.CustomCA != nil {
+		caFile, err := c.writeSecretFile(namespace, credentialsDir, "ca.crt", auth.CustomCA.SecretKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		g.setConfig("http.sslCAInfo", caFile)
+	}
+	if auth.ClientCert != nil {
+		secretRef := corev1.LocalObjectReference{Name: auth.ClientCert.SecretRef.Name}
+		certFile, err := c.writeSecretFile(namespace, credentialsDir, corev1.TLSCertKey, corev1.SecretKeySelector{LocalObjectReference: secretRef, Key: corev1.TLSCertKey})
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := c.writeSecretFile(namespace, credentialsDir, corev1.TLSPrivateKeyKey, corev1.SecretKeySelector{LocalObjectReference: secretRef, Key: corev1.TLSPrivateKeyKey})
+		if err != nil {
+			return nil, err
+		}
+		g.setConfig("http.sslCert", certFile)
+		g.setConfig("http.sslKey", keyFile)
+	}
+
+	if _, err := g.run(ctx, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// checkout fetches the ref of the repository and returns the SHA of the checked out commit.
+// Servers which do not allow to fetch commits directly are fetched completely.
+func (g *gitCheckout) checkout(ctx context.Context, repoURL, ref string) (string, error) {
+	// The ref is passed to git checkout, which would take it as an option
+	if strings.HasPrefix(ref, "-") {
+		return "", errorUtils.UnprocessableEntity.NewErrorf("Invalid ref %q of git repository %s", ref, repoURL)
+	}
+	fetchArgs := []string{"fetch", "--quiet", "--depth=1", "--", repoURL}
+	if ref != "" {
+		fetchArgs = append(fetchArgs, ref)
+	}
+	_, err := g.run(ctx, fetchArgs...)
+	if err == nil {
+		_, err = g.run(ctx, "checkout", "--quiet", "FETCH_HEAD")
+	} else if ref != "" {
+		_, err = g.run(ctx, "fetch", "--quiet", "--", repoURL, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		if err == nil {
+			_, err = g.run(ctx, "checkout", "--quiet", ref)
+		}
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "Could not check out %q of git repository %s", ref, repoURL)
+	}
+	return g.run(ctx, "rev-parse", "HEAD")
+}
+
+// checkSymlinks returns an error if a symlink in the checkout points outside of it. Helm follows
+// symlinks when loading chart directories, so they could add files of the backend to the chart.
+func checkSymlinks(checkoutDir string) error {
+	root, err := filepath.EvalSymlinks(checkoutDir)
+	if err != nil {
+		return errors.Wrap(err, "Could not resolve git checkout directory")
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" && filepath.Dir(path) == root {
+			return filepath.SkipDir
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		name, _ := filepath.Rel(root, path)
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return errorUtils.UnprocessableEntity.New(errors.Wrapf(err, "Could not resolve symlink %s of git repository", name))
+		}
+		if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return errorUtils.UnprocessableEntity.NewErrorf("Symlink %s of git repository points outside of the repository", name)
+		}
+		return nil
+	})
+}
+
+// findChartDir returns the directory of the chart below the path of the checkout. The path
+// can contain a directory per chart or be a chart directory itself.
+func findChartDir(checkoutDir, path, chartName string) (string, error) {
+	baseDir := filepath.Join(checkoutDir, filepath.Clean("/"+path))
+	for _, dir := range []string{filepath.Join(baseDir, filepath.Clean("/"+chartName)), baseDir} {
+		metadata, err := chartutil.LoadChartfile(filepath.Join(dir, chartutil.ChartfileName))
+		if err == nil && metadata.Name == chartName {
+			return dir, nil
+		}
+	}
+	return "", errors.Errorf("chart %q not found in path %q of git repository", chartName, path)
+}
+
+// getGitChart checks out the configured ref of the git repository and loads the chart
+// directory. The commit of the checkout is recorded in the annotations of the chart.
+func (c *Client) getGitChart(ctx context.Context, repoURL, chartName, chartVersion string) (*chart.Chart, error) {
+	ref, path := "", ""
+	if c.appRepo.Spec.Git != nil {
+		ref, path = c.appRepo.Spec.Git.Ref, c.appRepo.Spec.Git.Path
+	}
+
+	dir, err := ioutil.TempDir("", "git-chart")
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create directory for git checkout")
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*defaultTimeoutSeconds)
+	defer cancel()
+
+	g, err := c.newGitCheckout(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.checkout(ctx, repoURL, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSymlinks(g.dir); err != nil {
+		return nil, err
+	}
+	chartDir, err := findChartDir(g.dir, path, chartName)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.LoadDir(chartDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not load chart %q from git repository", chartName)
+	}
+
+	// The chart of the checkout is used if no version is requested, even if it is a pre-release
+	if _, ok := resolveVersion([]string{ch.Metadata.Version}, chartVersion); chartVersion != "" && !ok {
+		return nil, errors.Errorf("chart %q version %q not found in git repository, found version %q at %s", chartName, chartVersion, ch.Metadata.Version, commit)
+	}
+
+	if ch.Metadata.Annotations == nil {
+		ch.Metadata.Annotations = map[string]string{}
+	}
+	ch.Metadata.Annotations[GitURLAnnotation] = repoURL
+	ch.Metadata.Annotations[GitCommitAnnotation] = commit
+	if ref != "" {
+		ch.Metadata.Annotations[GitRefAnnotation] = ref
+	}
+	return ch, nil
+}
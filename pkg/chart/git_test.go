@@ -0,0 +1,299 @@
+package chart
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// gitRepo is a local git repository for tests
+type gitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git-repo")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	r := &gitRepo{t: t, dir: dir}
+	r.git("init", "--quiet", "--initial-branch=main")
+	return r
+}
+
+func (r *gitRepo) git(args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitChart saves the chart directory below path and commits it
+func (r *gitRepo) commitChart(path, name, version string) string {
+	chartDir := filepath.Join(r.dir, path, name)
+	if err := os.MkdirAll(chartDir, 0700); err != nil {
+		r.t.Fatalf("%+v", err)
+	}
+	metadata := &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version}
+	if err := chartutil.SaveChartfile(filepath.Join(chartDir, chartutil.ChartfileName), metadata); err != nil {
+		r.t.Fatalf("%+v", err)
+	}
+	r.git("add", "--all")
+	r.git("commit", "--quiet", "-m", name+" "+version)
+	return r.git("rev-parse", "HEAD")
+}
+
+// allowLocalGitRepos allows git to check out the local repositories of the tests
+func allowLocalGitRepos() func() {
+	allowedProtocols := gitAllowedProtocols
+	gitAllowedProtocols += ":file"
+	return func() {
+		gitAllowedProtocols = allowedProtocols
+	}
+}
+
+func gitRepoClient(repoDir, path string) *Client {
+	return &Client{
+		appRepo: &appRepov1.AppRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "git-repo", Namespace: metav1.NamespaceSystem},
+			Spec: appRepov1.AppRepositorySpec{
+				Type: GitRepoType,
+				URL:  "file://" + repoDir,
+				Git:  &appRepov1.AppRepositoryGit{Path: path},
+			},
+		},
+	}
+}
+
+func TestGetChartFromGit(t *testing.T) {
+	repo := newGitRepo(t)
+	defer os.RemoveAll(repo.dir)
+	defer allowLocalGitRepos()()
+
+	firstCommit := repo.commitChart("charts", "test", "1.0.0")
+	repo.git("tag", "v1")
+	secondCommit := repo.commitChart("charts", "test", "2.0.0-rc.1")
+	repo.git("checkout", "--quiet", "-b", "release-1", firstCommit)
+	repo.git("checkout", "--quiet", "main")
+
+	testCases := []struct {
+		name            string
+		ref             string
+		path            string
+		chartName       string
+		version         string
+		expectedVersion string
+		expectedCommit  string
+		errorExpected   bool
+	}{
+		{
+			name:            "default branch",
+			path:            "charts",
+			chartName:       "test",
+			expectedVersion: "2.0.0-rc.1",
+			expectedCommit:  secondCommit,
+		},
+		{
+			name:            "branch",
+			ref:             "release-1",
+			path:            "charts",
+			chartName:       "test",
+			expectedVersion: "1.0.0",
+			expectedCommit:  firstCommit,
+		},
+		{
+			name:            "tag",
+			ref:             "v1",
+			path:            "/charts/",
+			chartName:       "test",
+			version:         "~1.0",
+			expectedVersion: "1.0.0",
+			expectedCommit:  firstCommit,
+		},
+		{
+			name:            "commit",
+			ref:             firstCommit,
+			path:            "charts",
+			chartName:       "test",
+			expectedVersion: "1.0.0",
+			expectedCommit:  firstCommit,
+		},
+		{
+			name:            "path is the chart directory",
+			path:            "charts/test",
+			chartName:       "test",
+			expectedVersion: "2.0.0-rc.1",
+			expectedCommit:  secondCommit,
+		},
+		{
+			name:          "version does not match",
+			ref:           "v1",
+			path:          "charts",
+			chartName:     "test",
+			version:       "^2.0.0",
+			errorExpected: true,
+		},
+		{
+			name:          "chart not found",
+			path:          "charts",
+			chartName:     "other",
+			errorExpected: true,
+		},
+		{
+			name:          "chart outside of the path",
+			path:          "charts/test",
+			chartName:     "../test",
+			errorExpected: true,
+		},
+		{
+			name:          "unknown ref",
+			ref:           "unknown",
+			path:          "charts",
+			chartName:     "test",
+			errorExpected: true,
+		},
+		{
+			name:          "ref is an option",
+			ref:           "--orphan=other",
+			path:          "charts",
+			chartName:     "test",
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			chUtils := Client{
+				appRepo: &appRepov1.AppRepository{
+					ObjectMeta: metav1.ObjectMeta{Name: "git-repo", Namespace: metav1.NamespaceSystem},
+					Spec: appRepov1.AppRepositorySpec{
+						Type: GitRepoType,
+						URL:  "file://" + repo.dir,
+						Git:  &appRepov1.AppRepositoryGit{Ref: tc.ref, Path: tc.path},
+					},
+				},
+			}
+
+			ch, err := chUtils.GetChart(context.TODO(), &Details{ChartName: tc.chartName, Version: tc.version}, nil)
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if got, want := ch.Metadata.Version, tc.expectedVersion; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := ch.Metadata.Annotations[GitCommitAnnotation], tc.expectedCommit; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := ch.Metadata.Annotations[GitURLAnnotation], "file://"+repo.dir; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetChartFromGitWithSymlinks(t *testing.T) {
+	secret, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Remove(secret.Name())
+	secret.Close()
+
+	testCases := []struct {
+		name          string
+		target        string
+		errorExpected bool
+	}{
+		{"symlink within the repository", "../README.md", false},
+		{"symlink to a file of the backend", secret.Name(), true},
+		{"symlink to a parent directory", "../../..", true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newGitRepo(t)
+			defer os.RemoveAll(repo.dir)
+			defer allowLocalGitRepos()()
+
+			if err := os.MkdirAll(filepath.Join(repo.dir, "charts", "test"), 0700); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(repo.dir, "charts", "README.md"), []byte("readme"), 0600); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if err := os.Symlink(tc.target, filepath.Join(repo.dir, "charts", "test", "token")); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			repo.commitChart("charts", "test", "1.0.0")
+
+			_, err := gitRepoClient(repo.dir, "charts").GetChart(context.TODO(), &Details{ChartName: "test"}, nil)
+			if !tc.errorExpected {
+				if err != nil {
+					t.Fatalf("Unexpected error: %+v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+			if code, _ := errorUtils.GetHTTPErrorType(err); code != errorUtils.UnprocessableEntity {
+				t.Errorf("got: %d, want: %d", code, errorUtils.UnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestGetChartFromGitWithVerification(t *testing.T) {
+	repo := newGitRepo(t)
+	defer os.RemoveAll(repo.dir)
+	defer allowLocalGitRepos()()
+	repo.commitChart("charts", "test", "1.0.0")
+
+	client := gitRepoClient(repo.dir, "charts")
+	client.appRepo.Spec.Verification = &appRepov1.AppRepositoryVerification{}
+	_, err := client.GetChart(context.TODO(), &Details{ChartName: "test"}, nil)
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.UnprocessableEntity {
+		t.Errorf("got: %v, want: unprocessable entity", err)
+	}
+}
+
+func TestGetChartFromGitWithLocalRepo(t *testing.T) {
+	repo := newGitRepo(t)
+	defer os.RemoveAll(repo.dir)
+	repo.commitChart("charts", "test", "1.0.0")
+
+	// Local repositories are not allowed outside of tests
+	if _, err := gitRepoClient(repo.dir, "charts").GetChart(context.TODO(), &Details{ChartName: "test"}, nil); err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"gopkg.in/yaml.v2"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/gardener/potter-hub/pkg/health"
 )
 
 type KubeappsRelease release.Release
@@ -42,6 +44,8 @@ type KReleaseInfo struct {
 	Status release.Status `json:"status,omitempty"`
 	// Cluster resources as kubectl would print them.
 	Resources string `json:"resources,omitempty"`
+	// Health is the health of the cluster resources
+	Health *health.ReleaseHealth `json:"health,omitempty"`
 	// Contains the rendered templates/NOTES.txt if available
 	Notes string `json:"notes,omitempty"`
 }
@@ -69,11 +73,40 @@ type KConfig struct {
 	Raw string `json:"raw,omitempty"`
 }
 
+// KubeappsReleaseWithHealth is a release with the health of its cluster resources
+type KubeappsReleaseWithHealth struct {
+	Release *KubeappsRelease
+	Health  *health.ReleaseHealth
+}
+
+func (rel *KubeappsReleaseWithHealth) MarshalJSON() ([]byte, error) {
+	kRelease, err := rel.Release.toKRelease()
+	if err != nil {
+		return nil, err
+	}
+	if rel.Health != nil {
+		if kRelease.Info == nil {
+			kRelease.Info = &KReleaseInfo{}
+		}
+		kRelease.Info.Resources = rel.Health.String()
+		kRelease.Info.Health = rel.Health
+	}
+	return json.Marshal(kRelease)
+}
+
 func (rel *KubeappsRelease) MarshalJSON() ([]byte, error) {
+	kRelease, err := rel.toKRelease()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(kRelease)
+}
+
+func (rel *KubeappsRelease) toKRelease() (*KRelease, error) {
 	var kChart KubeappsChart
 
 	if rel.Chart == nil {
-		kRelease := KRelease{
+		kRelease := &KRelease{
 			Name:      rel.Name,
 			Info:      nil,
 			Chart:     nil,
@@ -82,9 +115,7 @@ func (rel *KubeappsRelease) MarshalJSON() ([]byte, error) {
 			Version:   rel.Version,
 			Namespace: rel.Namespace,
 		}
-		jsonBytes, err := json.Marshal(kRelease)
-
-		return jsonBytes, err
+		return kRelease, nil
 	}
 
 	kChart = KubeappsChart{
@@ -124,7 +155,7 @@ func (rel *KubeappsRelease) MarshalJSON() ([]byte, error) {
 		kReleaseInfo.Deleted = nil
 	}
 
-	kRelease := KRelease{
+	kRelease := &KRelease{
 		Name:      rel.Name,
 		Info:      &kReleaseInfo,
 		Chart:     &kChart,
@@ -134,8 +165,5 @@ func (rel *KubeappsRelease) MarshalJSON() ([]byte, error) {
 		Version:   rel.Version,
 		Namespace: rel.Namespace,
 	}
-
-	jsonBytes, err := json.Marshal(kRelease)
-
-	return jsonBytes, err
+	return kRelease, nil
 }
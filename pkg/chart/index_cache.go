@@ -0,0 +1,234 @@
+package chart
+
+import (
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// DefaultIndexCacheMaxBytes is the default memory limit of the repo index cache
+	DefaultIndexCacheMaxBytes = 256 * 1024 * 1024
+	// DefaultIndexCacheTTL is the default duration after which a cached repo index is revalidated
+	DefaultIndexCacheTTL = time.Minute
+)
+
+// IndexCacheStats contains the statistics of the repo index cache
+type IndexCacheStats struct {
+	// Hits is the number of lookups served from the cache without contacting the repository
+	Hits uint64 `json:"hits"`
+	// Revalidations is the number of lookups for which the repository confirmed the cached index
+	Revalidations uint64 `json:"revalidations"`
+	// Misses is the number of lookups which downloaded the index
+	Misses uint64 `json:"misses"`
+	// Evictions is the number of indexes removed to stay within the memory limit
+	Evictions uint64 `json:"evictions"`
+	// Entries is the number of cached indexes
+	Entries int `json:"entries"`
+	// Bytes is the size of the raw cached indexes
+	Bytes int64 `json:"bytes"`
+	// MaxBytes is the memory limit of the cache
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+type repoIndex struct {
+	repoURL      string
+	checksum     string
+	etag         string
+	lastModified string
+	// size of the raw index.yaml, used to approximate the memory of the parsed index
+	size      int64
+	fetchedAt time.Time
+	index     *repo.IndexFile
+}
+
+// indexCache is a LRU cache for parsed repo indexes bounded by the size of the raw
+// indexes. Entries older than the TTL are revalidated with the repository using
+// conditional requests and parallel fetches of the same index share one download.
+type indexCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	lru      *list.List
+	stats    IndexCacheStats
+	group    singleflight.Group
+	now      func() time.Time
+}
+
+func newIndexCache(maxBytes int64, ttl time.Duration) *indexCache {
+	return &indexCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Cache the result of parsing the repo index since parsing this YAML
+// is an expensive operation. See https://github.com/kubeapps/kubeapps/issues/1052
+// nolint
+var repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+
+// ConfigureIndexCache sets the memory limit and the TTL of the repo index cache.
+// Cached indexes are dropped.
+func ConfigureIndexCache(maxBytes int64, ttl time.Duration) {
+	repoIndexes = newIndexCache(maxBytes, ttl)
+}
+
+// GetIndexCacheStats returns the statistics of the repo index cache
+func GetIndexCacheStats() IndexCacheStats {
+	return repoIndexes.getStats()
+}
+
+func (c *indexCache) getStats() IndexCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// get returns the cached entry and moves it to the front of the LRU list
+func (c *indexCache) get(repoURL string) *repoIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[repoURL]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*repoIndex)
+}
+
+func (c *indexCache) isFresh(entry *repoIndex) bool {
+	return c.now().Sub(entry.fetchedAt) < c.ttl
+}
+
+func (c *indexCache) store(entry *repoIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.repoURL]; ok {
+		c.stats.Bytes -= elem.Value.(*repoIndex).size
+		c.lru.Remove(elem)
+		delete(c.entries, entry.repoURL)
+	}
+	if entry.size > c.maxBytes {
+		// Never evict the whole cache for a single index
+		return
+	}
+
+	c.entries[entry.repoURL] = c.lru.PushFront(entry)
+	c.stats.Bytes += entry.size
+
+	for c.stats.Bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		evicted := oldest.Value.(*repoIndex)
+		c.lru.Remove(oldest)
+		delete(c.entries, evicted.repoURL)
+		c.stats.Bytes -= evicted.size
+		c.stats.Evictions++
+	}
+}
+
+func (c *indexCache) count(counter *uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*counter++
+}
+
+// fetch returns the index of the given repository from the cache or downloads it
+func (c *indexCache) fetch(netClient HTTPClient, repoURL string) (*repo.IndexFile, error) {
+	if entry := c.get(repoURL); entry != nil && c.isFresh(entry) {
+		c.count(&c.stats.Hits)
+		return entry.index, nil
+	}
+
+	index, err, _ := c.group.Do(repoURL, func() (interface{}, error) {
+		return c.revalidate(netClient, repoURL)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index.(*repo.IndexFile), nil
+}
+
+// revalidate requests the index with the validators of the cached entry (if any)
+// and only downloads and parses it if it has been modified.
+func (c *indexCache) revalidate(netClient HTTPClient, repoURL string) (*repo.IndexFile, error) {
+	cached := c.get(repoURL)
+
+	req, err := getReq(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	res, err := netClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Request failed")
+	}
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		_, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.count(&c.stats.Revalidations)
+		c.store(&repoIndex{
+			repoURL:      repoURL,
+			checksum:     cached.checksum,
+			etag:         cached.etag,
+			lastModified: cached.lastModified,
+			size:         cached.size,
+			fetchedAt:    c.now(),
+			index:        cached.index,
+		})
+		return cached.index, nil
+	}
+
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	data, err := readResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	c.count(&c.stats.Misses)
+
+	sha := checksum(data)
+	var index *repo.IndexFile
+	if cached != nil && cached.checksum == sha {
+		// The repository does not support validators but the content did not change
+		index = cached.index
+	} else {
+		index, err = parseIndex(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.store(&repoIndex{
+		repoURL:      repoURL,
+		checksum:     sha,
+		etag:         etag,
+		lastModified: lastModified,
+		size:         int64(len(data)),
+		fetchedAt:    c.now(),
+		index:        index,
+	})
+	return index, nil
+}
@@ -0,0 +1,177 @@
+package chart
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const fakeIndexYAML = `apiVersion: v1
+entries:
+  test:
+  - name: test
+    version: 1.0.0
+    urls:
+    - test-1.0.0.tgz
+`
+
+// fakeIndexServer serves an index.yaml and supports ETag validation
+type fakeIndexServer struct {
+	data      []byte
+	etag      string
+	downloads int32
+	requests  int32
+	// release blocks the responses until it is closed if set
+	release chan struct{}
+}
+
+func (f *fakeIndexServer) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&f.requests, 1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.etag != "" && req.Header.Get("If-None-Match") == f.etag {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	atomic.AddInt32(&f.downloads, 1)
+	header := http.Header{}
+	if f.etag != "" {
+		header.Set("ETag", f.etag)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(bytes.NewReader(f.data))}, nil
+}
+
+func TestIndexCacheTTLAndRevalidation(t *testing.T) {
+	const repoURL = "http://charts.example.com/index.yaml"
+	now := time.Now()
+	cache := newIndexCache(DefaultIndexCacheMaxBytes, time.Minute)
+	cache.now = func() time.Time { return now }
+	server := &fakeIndexServer{data: []byte(fakeIndexYAML), etag: `"v1"`}
+
+	index, err := cache.fetch(server, repoURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := index.Get("test", "1.0.0"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Within the TTL the repository is not contacted
+	cached, err := cache.fetch(server, repoURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cached != index {
+		t.Errorf("expected the cached index")
+	}
+	if got, want := server.requests, int32(1); got != want {
+		t.Errorf("got: %d, want: %d requests", got, want)
+	}
+
+	// After the TTL the index is revalidated but not downloaded again
+	now = now.Add(2 * time.Minute)
+	revalidated, err := cache.fetch(server, repoURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if revalidated != index {
+		t.Errorf("expected the cached index")
+	}
+	if got, want := server.requests, int32(2); got != want {
+		t.Errorf("got: %d, want: %d requests", got, want)
+	}
+	if got, want := server.downloads, int32(1); got != want {
+		t.Errorf("got: %d, want: %d downloads", got, want)
+	}
+
+	// A changed index is downloaded again
+	now = now.Add(2 * time.Minute)
+	server.etag = `"v2"`
+	if _, err := cache.fetch(server, repoURL); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := server.downloads, int32(2); got != want {
+		t.Errorf("got: %d, want: %d downloads", got, want)
+	}
+
+	stats := cache.getStats()
+	expected := IndexCacheStats{
+		Hits:          1,
+		Revalidations: 1,
+		Misses:        2,
+		Entries:       1,
+		Bytes:         int64(len(fakeIndexYAML)),
+		MaxBytes:      DefaultIndexCacheMaxBytes,
+	}
+	if stats != expected {
+		t.Errorf("got: %+v, want: %+v", stats, expected)
+	}
+}
+
+func TestIndexCacheEviction(t *testing.T) {
+	size := int64(len(fakeIndexYAML))
+	cache := newIndexCache(2*size, time.Minute)
+	server := &fakeIndexServer{data: []byte(fakeIndexYAML)}
+
+	for _, repoURL := range []string{"http://a/index.yaml", "http://b/index.yaml"} {
+		if _, err := cache.fetch(server, repoURL); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// Use a so that b is the least recently used index
+	if _, err := cache.fetch(server, "http://a/index.yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cache.fetch(server, "http://c/index.yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cache.get("http://b/index.yaml") != nil {
+		t.Errorf("expected b to be evicted")
+	}
+	for _, repoURL := range []string{"http://a/index.yaml", "http://c/index.yaml"} {
+		if cache.get(repoURL) == nil {
+			t.Errorf("expected %s to be cached", repoURL)
+		}
+	}
+	if got, want := cache.getStats().Evictions, uint64(1); got != want {
+		t.Errorf("got: %d, want: %d evictions", got, want)
+	}
+	if got, want := cache.getStats().Bytes, 2*size; got != want {
+		t.Errorf("got: %d, want: %d bytes", got, want)
+	}
+}
+
+func TestIndexCacheSharesParallelDownloads(t *testing.T) {
+	cache := newIndexCache(DefaultIndexCacheMaxBytes, time.Minute)
+	server := &fakeIndexServer{data: []byte(fakeIndexYAML), release: make(chan struct{})}
+
+	const parallelFetches = 10
+	var wg sync.WaitGroup
+	started := make(chan struct{}, parallelFetches)
+	for i := 0; i < parallelFetches; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- struct{}{}
+			if _, err := cache.fetch(server, "http://charts.example.com/index.yaml"); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	for i := 0; i < parallelFetches; i++ {
+		<-started
+	}
+	// Give the goroutines the chance to join the pending download
+	time.Sleep(50 * time.Millisecond)
+	close(server.release)
+	wg.Wait()
+
+	if got, want := server.downloads, int32(1); got != want {
+		t.Errorf("got: %d, want: %d downloads", got, want)
+	}
+}
@@ -0,0 +1,372 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+const (
+	// OCIRepoType is the AppRepositorySpec.Type of repositories which are OCI registries
+	OCIRepoType = "oci"
+
+	ociManifestMediaType           = "application/vnd.oci.image.manifest.v1+json"
+	helmChartContentLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// Charts pushed with helm < 3.7 and other tools use the generic tar+gzip media type
+	legacyChartContentLayerMediaType  = "application/tar+gzip"
+	helmChartProvenanceLayerMediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+type ociTagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociTokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// ociRegistry talks to an OCI distribution API. Requests are authorized with the
+// default headers of the given HTTPClient. If the registry answers with a bearer
+// challenge, these credentials are exchanged for a registry token.
+type ociRegistry struct {
+	netClient HTTPClient
+	baseURL   *url.URL
+	// repository is the path of the chart repositories within the registry, e.g. "charts"
+	repository string
+	// token is the registry token obtained for the last bearer challenge
+	token string
+}
+
+// newOCIRegistry parses an AppRepository URL like "oci://registry.example.com/charts".
+// An oci scheme is accessed via https, http(s) URLs are used as they are.
+func newOCIRegistry(netClient HTTPClient, repoURL string) (*ociRegistry, error) {
+	parsedURL, err := url.Parse(strings.TrimSpace(repoURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse OCI registry url")
+	}
+
+	switch parsedURL.Scheme {
+	case OCIRepoType:
+		parsedURL.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, errors.Errorf("Unsupported scheme %q in OCI registry url", parsedURL.Scheme)
+	}
+	if parsedURL.Host == "" {
+		return nil, errors.Errorf("OCI registry url %q has no host", repoURL)
+	}
+
+	return &ociRegistry{
+		netClient:  netClient,
+		baseURL:    &url.URL{Scheme: parsedURL.Scheme, Host: parsedURL.Host},
+		repository: strings.Trim(parsedURL.Path, "/"),
+	}, nil
+}
+
+func (r *ociRegistry) chartRepository(chartName string) string {
+	if r.repository == "" {
+		return chartName
+	}
+	return r.repository + "/" + chartName
+}
+
+func (r *ociRegistry) endpoint(repository, kind, reference string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", r.baseURL.String(), repository, kind, reference)
+}
+
+// do performs the request and retries it once with a registry token if the registry
+// requests a bearer token.
+func (r *ociRegistry) do(req *http.Request) (*http.Response, error) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	res, err := r.netClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Request failed")
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, errors.Errorf("Unauthorized request to OCI registry %s", r.baseURL.Host)
+	}
+
+	token, err := r.fetchToken(challenge)
+	if err != nil {
+		return nil, err
+	}
+	r.token = token
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	res, err = r.netClient.Do(retry)
+	if err != nil {
+		return nil, errors.Wrap(err, "Request failed")
+	}
+	return res, nil
+}
+
+// fetchToken requests a registry token for a "Bearer realm=...,service=...,scope=..." challenge
+func (r *ociRegistry) fetchToken(challenge string) (string, error) {
+	params := parseAuthChallenge(challenge[len("bearer "):])
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("OCI registry auth challenge contains no realm")
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not parse OCI registry auth realm")
+	}
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if value, ok := params[key]; ok {
+			query.Set(key, value)
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := getReq(tokenURL.String())
+	if err != nil {
+		return "", err
+	}
+	res, err := r.netClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "Request failed")
+	}
+	data, err := readResponseBody(res)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not fetch OCI registry token")
+	}
+
+	tokenResponse := ociTokenResponse{}
+	if err := json.Unmarshal(data, &tokenResponse); err != nil {
+		return "", errors.Wrap(err, "Could not unmarshall OCI registry token")
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", errors.New("OCI registry token response contains no token")
+}
+
+// parseAuthChallenge parses the comma separated key="value" pairs of a WWW-Authenticate header
+func parseAuthChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, ", ")
+		eq := strings.Index(challenge, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:eq]))
+		challenge = challenge[eq+1:]
+
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := strings.Index(challenge[1:], `"`)
+			if end < 0 {
+				value, challenge = challenge[1:], ""
+			} else {
+				value, challenge = challenge[1:end+1], challenge[end+2:]
+			}
+		} else {
+			end := strings.Index(challenge, ",")
+			if end < 0 {
+				value, challenge = challenge, ""
+			} else {
+				value, challenge = challenge[:end], challenge[end:]
+			}
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func (r *ociRegistry) get(rawURL, accept string) ([]byte, error) {
+	req, err := getReq(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	res, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readResponseBody(res)
+}
+
+func (r *ociRegistry) fetchTags(repository string) ([]string, error) {
+	data, err := r.get(r.endpoint(repository, "tags", "list"), "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not list tags of %s", repository)
+	}
+
+	tagList := ociTagList{}
+	if err := json.Unmarshal(data, &tagList); err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshall OCI tag list")
+	}
+	return tagList.Tags, nil
+}
+
+func (r *ociRegistry) fetchManifest(repository, tag string) (*ociManifest, error) {
+	data, err := r.get(r.endpoint(repository, "manifests", tag), ociManifestMediaType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not fetch manifest of %s:%s", repository, tag)
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshall OCI manifest")
+	}
+	return manifest, nil
+}
+
+func (r *ociRegistry) fetchBlob(repository string, layer ociDescriptor) ([]byte, error) {
+	data, err := r.get(r.endpoint(repository, "blobs", layer.Digest), "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not fetch blob %s of %s", layer.Digest, repository)
+	}
+
+	if !strings.HasPrefix(layer.Digest, "sha256:") {
+		return nil, errors.Errorf("Unsupported digest algorithm in %q", layer.Digest)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.TrimPrefix(layer.Digest, "sha256:") {
+		return nil, errors.Errorf("Digest of blob %s of %s does not match", layer.Digest, repository)
+	}
+	return data, nil
+}
+
+// chartVersionToTag converts a chart version to an OCI tag. Tags must not contain
+// a "+" which is why helm replaces it with "_".
+func chartVersionToTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+// findChartVersionInTags returns the tag for the given chart version or version constraint.
+// If no version is given, the tag of the latest stable chart version is returned.
+func findChartVersionInTags(tags []string, chartName, chartVersion string) (string, error) {
+	// Tags which are no chart versions like "latest" are ignored when resolving the version
+	versions := make([]string, len(tags))
+	for i, tag := range tags {
+		versions[i] = strings.ReplaceAll(tag, "_", "+")
+	}
+
+	version, ok := resolveVersion(versions, chartVersion)
+	if !ok {
+		if chartVersion != "" {
+			return "", errors.Errorf("chart %q version %q not found in repository", chartName, chartVersion)
+		}
+		return "", errors.Errorf("chart %q not found in repository", chartName)
+	}
+	return chartVersionToTag(version), nil
+}
+
+func findLayer(manifest *ociManifest, mediaTypes ...string) *ociDescriptor {
+	for i := range manifest.Layers {
+		for _, mediaType := range mediaTypes {
+			if manifest.Layers[i].MediaType == mediaType {
+				return &manifest.Layers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// getOCIChart resolves the chart version from the tags of the OCI registry and loads the chart layer
+func (c *Client) getOCIChart(ctx context.Context, netClient HTTPClient, repoURL, chartName, chartVersion string) (*chart.Chart, error) {
+	registry, err := newOCIRegistry(netClient, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	repository := registry.chartRepository(chartName)
+
+	tags, err := registry.fetchTags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := findChartVersionInTags(tags, chartName, chartVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := registry.fetchManifest(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := findLayer(manifest, helmChartContentLayerMediaType, legacyChartContentLayerMediaType)
+	if layer == nil {
+		return nil, errors.Errorf("Could not load %s:%s: OCI manifest contains no chart layer", repository, tag)
+	}
+
+	// The digest of the blob is always verified
+	cacheKey := chartCacheKey(layer.Digest, registry.endpoint(repository, "manifests", tag), tag)
+	data, cached := chartCache.get(cacheKey)
+	if cached {
+		logUtils.GetLogger(ctx).Infof("chart %s:%s served from chart cache", repository, tag)
+	} else {
+		data, err = registry.fetchBlob(repository, *layer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.appRepo.Spec.Verification != nil {
+		provLayer := findLayer(manifest, helmChartProvenanceLayerMediaType)
+		if provLayer == nil {
+			return nil, errorUtils.UnprocessableEntity.NewErrorf("Chart verification failed: %s:%s has no provenance layer", repository, tag)
+		}
+		provData, err := registry.fetchBlob(repository, *provLayer)
+		if err != nil {
+			return nil, err
+		}
+		fileName := fmt.Sprintf("%s-%s.tgz", chartName, strings.ReplaceAll(tag, "_", "+"))
+		err = c.verifyProvenance(fileName, data, provData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cached {
+		cacheChart(ctx, cacheKey, data)
+	}
+	return c.load(bytes.NewReader(data))
+}
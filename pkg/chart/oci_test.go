@@ -0,0 +1,249 @@
+package chart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+)
+
+// fakeOCIRegistry is an in-process stand-in for an OCI distribution API serving helm charts
+type fakeOCIRegistry struct {
+	// charts maps "<repository>:<tag>" to the chart archive content
+	charts    map[string][]byte
+	token     string
+	basicAuth string
+	requests  []string
+}
+
+func (f *fakeOCIRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.URL.Path)
+
+	if r.URL.Path == "/token" {
+		if f.basicAuth != "" && r.Header.Get("Authorization") != f.basicAuth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ociTokenResponse{Token: f.token})
+		return
+	}
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake",scope="repository:charts:pull"`, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		repository := strings.TrimSuffix(path, "/tags/list")
+		tags := []string{}
+		for ref := range f.charts {
+			if strings.HasPrefix(ref, repository+":") {
+				tags = append(tags, strings.TrimPrefix(ref, repository+":"))
+			}
+		}
+		_ = json.NewEncoder(w).Encode(ociTagList{Name: repository, Tags: tags})
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		data, ok := f.charts[parts[0]+":"+parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ociManifest{
+			SchemaVersion: 2,
+			Layers: []ociDescriptor{
+				{MediaType: helmChartContentLayerMediaType, Digest: ociDigest(data), Size: int64(len(data))},
+			},
+		})
+	case strings.Contains(path, "/blobs/"):
+		digest := path[strings.Index(path, "/blobs/")+len("/blobs/"):]
+		for _, data := range f.charts {
+			if ociDigest(data) == digest {
+				_, _ = w.Write(data)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func ociDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// contentLoadChart returns a chart whose name is the content of the loaded archive
+func contentLoadChart(in io.Reader) (*chart.Chart, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	return &chart.Chart{Metadata: &chart.Metadata{Name: string(data)}}, nil
+}
+
+func TestGetChartFromOCIRegistry(t *testing.T) {
+	charts := map[string][]byte{
+		"charts/test:1.0.0":       []byte("test-1.0.0"),
+		"charts/test:1.2.0_build": []byte("test-1.2.0+build"),
+		"charts/test:2.0.0-rc.1":  []byte("test-2.0.0-rc.1"),
+		"charts/test:latest":      []byte("test-latest"),
+	}
+
+	testCases := []struct {
+		name          string
+		version       string
+		token         string
+		basicAuth     string
+		expected      string
+		errorExpected bool
+	}{
+		{
+			name:     "exact version",
+			version:  "1.0.0",
+			expected: "test-1.0.0",
+		},
+		{
+			name:     "version with build metadata",
+			version:  "1.2.0+build",
+			expected: "test-1.2.0+build",
+		},
+		{
+			name:     "latest stable version if no version is given",
+			expected: "test-1.2.0+build",
+		},
+		{
+			name:     "version constraint",
+			version:  "~1.0",
+			expected: "test-1.0.0",
+		},
+		{
+			name:     "version constraint including pre-releases",
+			version:  ">=2.0.0-0",
+			expected: "test-2.0.0-rc.1",
+		},
+		{
+			name:          "unknown version",
+			version:       "3.0.0",
+			errorExpected: true,
+		},
+		{
+			name:      "registry token exchanged for auth header",
+			version:   "1.0.0",
+			token:     "registry-token",
+			basicAuth: "Basic dXNlcjpwYXNz",
+			expected:  "test-1.0.0",
+		},
+		{
+			name:          "token request rejected",
+			version:       "1.0.0",
+			token:         "registry-token",
+			basicAuth:     "Basic other",
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			registry := &fakeOCIRegistry{charts: charts, token: tc.token, basicAuth: tc.basicAuth}
+			server := httptest.NewServer(registry)
+			defer server.Close()
+
+			netClient := &clientWithDefaultHeaders{
+				client:         server.Client(),
+				defaultHeaders: http.Header{"Authorization": []string{"Basic dXNlcjpwYXNz"}},
+			}
+			chUtils := Client{
+				load: contentLoadChart,
+				appRepo: &appRepov1.AppRepository{
+					ObjectMeta: metav1.ObjectMeta{Name: "oci-repo", Namespace: metav1.NamespaceSystem},
+					Spec: appRepov1.AppRepositorySpec{
+						Type: OCIRepoType,
+						URL:  server.URL + "/charts",
+					},
+				},
+			}
+
+			ch, err := chUtils.GetChart(context.TODO(), &Details{ChartName: "test", Version: tc.version}, netClient)
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if got, want := ch.Metadata.Name, tc.expected; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNewOCIRegistry(t *testing.T) {
+	testCases := []struct {
+		repoURL            string
+		expectedBaseURL    string
+		expectedRepository string
+		errorExpected      bool
+	}{
+		{"oci://registry.example.com/charts", "https://registry.example.com", "charts", false},
+		{"oci://registry.example.com:5000/org/charts/", "https://registry.example.com:5000", "org/charts", false},
+		{"http://localhost:5000", "http://localhost:5000", "", false},
+		{"ftp://registry.example.com/charts", "", "", true},
+		{"oci:///charts", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.repoURL, func(t *testing.T) {
+			registry, err := newOCIRegistry(nil, tc.repoURL)
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got, want := registry.baseURL.String(), tc.expectedBaseURL; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := registry.repository, tc.expectedRepository; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	params := parseAuthChallenge(`realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts/test:pull"`)
+	expected := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:charts/test:pull",
+	}
+	if !cmp.Equal(params, expected) {
+		t.Errorf(cmp.Diff(expected, params))
+	}
+}
@@ -0,0 +1,128 @@
+package chart
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// matchesAny returns whether the chart name matches one of the patterns
+func matchesAny(patterns []string, chartName string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, chartName)
+		if err != nil {
+			return false, errorUtils.UnprocessableEntity.NewErrorf("Invalid chart pattern %q in policy: %v", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkChartName returns a Forbidden error if the policy of the app repository does not allow to install the chart
+func checkChartName(appRepo *appRepov1.AppRepository, chartName string) error {
+	policy := appRepo.Spec.Policy
+	if policy == nil {
+		return nil
+	}
+
+	denied, err := matchesAny(policy.DeniedCharts, chartName)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return errorUtils.Forbidden.NewErrorf("Chart %q is denied by the policy of app repository %s", chartName, appRepo.Name)
+	}
+
+	if len(policy.AllowedCharts) > 0 {
+		allowed, err := matchesAny(policy.AllowedCharts, chartName)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errorUtils.Forbidden.NewErrorf("Chart %q is not allowed by the policy of app repository %s", chartName, appRepo.Name)
+		}
+	}
+	return nil
+}
+
+func parsePolicyVersion(version string) (*semver.Version, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, errorUtils.UnprocessableEntity.NewErrorf("Invalid version %q in policy: %v", version, err)
+	}
+	return v, nil
+}
+
+// checkChartVersion returns a Forbidden error if the policy of the app repository does not allow to install
+// the version of the chart or the chart is deprecated and deprecated charts are blocked
+func checkChartVersion(appRepo *appRepov1.AppRepository, metadata *chart.Metadata) error {
+	policy := appRepo.Spec.Policy
+	if policy == nil {
+		return nil
+	}
+	chartDesc := fmt.Sprintf("Chart %q version %q", metadata.Name, metadata.Version)
+
+	if policy.BlockDeprecated && metadata.Deprecated {
+		return errorUtils.Forbidden.NewErrorf("%s is deprecated and deprecated charts are blocked by the policy of app repository %s", chartDesc, appRepo.Name)
+	}
+
+	deniedVersions := []appRepov1.AppRepositoryDeniedVersion{}
+	for _, deniedVersion := range policy.DeniedVersions {
+		matched, err := matchesAny([]string{deniedVersion.Chart}, metadata.Name)
+		if err != nil {
+			return err
+		}
+		if matched {
+			deniedVersions = append(deniedVersions, deniedVersion)
+		}
+	}
+	if policy.MinVersion == "" && policy.MaxVersion == "" && len(deniedVersions) == 0 {
+		return nil
+	}
+
+	version, err := semver.NewVersion(metadata.Version)
+	if err != nil {
+		return errorUtils.Forbidden.NewErrorf("%s is not a semantic version and cannot be checked against the policy of app repository %s", chartDesc, appRepo.Name)
+	}
+
+	if policy.MinVersion != "" {
+		minVersion, err := parsePolicyVersion(policy.MinVersion)
+		if err != nil {
+			return err
+		}
+		if version.LessThan(minVersion) {
+			return errorUtils.Forbidden.NewErrorf("%s is lower than the minimum version %q of the policy of app repository %s", chartDesc, policy.MinVersion, appRepo.Name)
+		}
+	}
+	if policy.MaxVersion != "" {
+		maxVersion, err := parsePolicyVersion(policy.MaxVersion)
+		if err != nil {
+			return err
+		}
+		if version.GreaterThan(maxVersion) {
+			return errorUtils.Forbidden.NewErrorf("%s is higher than the maximum version %q of the policy of app repository %s", chartDesc, policy.MaxVersion, appRepo.Name)
+		}
+	}
+
+	for _, deniedVersion := range deniedVersions {
+		constraint, err := semver.NewConstraint(deniedVersion.Versions)
+		if err != nil {
+			return errorUtils.UnprocessableEntity.NewErrorf("Invalid denied versions %q in policy: %v", deniedVersion.Versions, err)
+		}
+		if constraint.Check(version) {
+			msg := fmt.Sprintf("%s is denied by the policy of app repository %s", chartDesc, appRepo.Name)
+			if deniedVersion.Reason != "" {
+				msg = fmt.Sprintf("%s: %s", msg, deniedVersion.Reason)
+			}
+			return errorUtils.Forbidden.NewError(msg)
+		}
+	}
+	return nil
+}
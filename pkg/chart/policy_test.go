@@ -0,0 +1,113 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+func TestCheckChartPolicy(t *testing.T) {
+	policy := &appRepov1.AppRepositoryPolicy{
+		AllowedCharts: []string{"postgres*", "redis"},
+		DeniedCharts:  []string{"postgresql-ha"},
+		MinVersion:    "8.0.0",
+		MaxVersion:    "10.0.0",
+		DeniedVersions: []appRepov1.AppRepositoryDeniedVersion{
+			{Chart: "postgres*", Versions: ">=8.2.0 <8.2.3", Reason: "data loss on upgrade"},
+		},
+		BlockDeprecated: true,
+	}
+
+	testCases := []struct {
+		name         string
+		policy       *appRepov1.AppRepositoryPolicy
+		metadata     *chart.Metadata
+		expectedType errorUtils.HTTPErrorType
+	}{
+		{"no policy", nil, &chart.Metadata{Name: "mysql", Version: "1.0.0"}, 0},
+		{"allowed", policy, &chart.Metadata{Name: "postgresql", Version: "8.1.0"}, 0},
+		{"allowed version after denied versions", policy, &chart.Metadata{Name: "postgresql", Version: "8.2.3"}, 0},
+		{"upper bound is inclusive", policy, &chart.Metadata{Name: "redis", Version: "10.0.0"}, 0},
+		{"not allowed", policy, &chart.Metadata{Name: "mysql", Version: "8.1.0"}, errorUtils.Forbidden},
+		{"denied", policy, &chart.Metadata{Name: "postgresql-ha", Version: "8.1.0"}, errorUtils.Forbidden},
+		{"lower than minimum", policy, &chart.Metadata{Name: "postgresql", Version: "7.9.9"}, errorUtils.Forbidden},
+		{"higher than maximum", policy, &chart.Metadata{Name: "redis", Version: "10.0.1"}, errorUtils.Forbidden},
+		{"denied version", policy, &chart.Metadata{Name: "postgresql", Version: "8.2.1"}, errorUtils.Forbidden},
+		{"deprecated", policy, &chart.Metadata{Name: "redis", Version: "9.0.0", Deprecated: true}, errorUtils.Forbidden},
+		{"no semantic version", policy, &chart.Metadata{Name: "redis", Version: "latest"}, errorUtils.Forbidden},
+		{"invalid pattern", &appRepov1.AppRepositoryPolicy{DeniedCharts: []string{"["}}, &chart.Metadata{Name: "redis", Version: "1.0.0"}, errorUtils.UnprocessableEntity},
+		{"invalid version", &appRepov1.AppRepositoryPolicy{MinVersion: "one"}, &chart.Metadata{Name: "redis", Version: "1.0.0"}, errorUtils.UnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			appRepo := &appRepov1.AppRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "stable"},
+				Spec:       appRepov1.AppRepositorySpec{Policy: tc.policy},
+			}
+			err := checkChartName(appRepo, tc.metadata.Name)
+			if err == nil {
+				err = checkChartVersion(appRepo, tc.metadata)
+			}
+			if tc.expectedType == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %+v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+			if got, _ := errorUtils.GetHTTPErrorType(err); got != tc.expectedType {
+				t.Errorf("got: %d, want: %d (%v)", got, tc.expectedType, err)
+			}
+		})
+	}
+}
+
+func TestGetChartEnforcesPolicy(t *testing.T) {
+	server := httptest.NewServer(&fakeChartRepo{t: t, charts: []*chart.Chart{
+		newChart("postgresql", "8.1.0"),
+		newChart("postgresql", "8.2.1"),
+	}})
+	defer server.Close()
+	repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+
+	nullLogger, _ := test.NewNullLogger()
+	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+	chUtils := Client{
+		load: loader.LoadArchive,
+		appRepo: &appRepov1.AppRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "stable", Namespace: metav1.NamespaceSystem},
+			Spec: appRepov1.AppRepositorySpec{
+				URL: server.URL,
+				Policy: &appRepov1.AppRepositoryPolicy{
+					DeniedVersions: []appRepov1.AppRepositoryDeniedVersion{{Chart: "postgresql", Versions: "8.2.1"}},
+				},
+			},
+		},
+	}
+	netClient := &clientWithDefaultHeaders{client: http.DefaultClient}
+
+	if _, err := chUtils.GetChart(ctx, &Details{ChartName: "postgresql", Version: "8.1.0"}, netClient); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	_, err := chUtils.GetChart(ctx, &Details{ChartName: "postgresql", Version: "^8.0.0"}, netClient)
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.Forbidden {
+		t.Errorf("got: %d, want: %d", got, errorUtils.Forbidden)
+	}
+}
@@ -0,0 +1,265 @@
+package chart
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+	"github.com/gardener/potter-hub/pkg/util"
+)
+
+// hiddenAnnotation marks app repositories which are not shown in the catalog
+const hiddenAnnotation = "hub.k8s.sap.com/hidden"
+
+// Scores of the matches of a search term, the scores of all terms are added up
+const (
+	scoreNameExact       = 100
+	scoreNamePrefix      = 50
+	scoreNameContains    = 30
+	scoreKeywordExact    = 20
+	scoreKeywordContains = 10
+	scoreDescription     = 5
+	scoreMaintainer      = 3
+)
+
+// Searcher searches the charts of the app repositories
+type Searcher interface {
+	SearchCharts(ctx context.Context, query SearchQuery) (*SearchResults, error)
+}
+
+// SearchQuery filters the charts of a search
+type SearchQuery struct {
+	// Query contains the terms which must all match the name, description, keywords or maintainers of a chart
+	Query string
+	// Repo restricts the search to the app repository with this name
+	Repo string
+	// Keyword restricts the search to charts with this keyword
+	Keyword string
+}
+
+// SearchResult is a chart found by a search
+type SearchResult struct {
+	// Repository is the name of the app repository of the chart
+	Repository string `json:"repository"`
+	Name       string `json:"name"`
+	// Version is the latest version of the chart, pre-releases are only used if there is no other version
+	Version     string   `json:"version"`
+	AppVersion  string   `json:"appVersion,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	// Versions are all available versions of the chart, the highest first
+	Versions []string `json:"versions"`
+	// Score ranks the result, results with a higher score match the query better
+	Score int `json:"score"`
+}
+
+// SearchResults contains the charts found by a search and the app repositories which could not be searched
+type SearchResults struct {
+	Charts                  []SearchResult `json:"charts"`
+	UnavailableRepositories []string       `json:"unavailableRepositories,omitempty"`
+}
+
+// latestVersion returns the highest version of the sorted chart versions which is not a pre-release
+func latestVersion(chartVersions repo.ChartVersions) *repo.ChartVersion {
+	for _, cv := range chartVersions {
+		if v, err := semver.NewVersion(cv.Version); err == nil && v.Prerelease() == "" {
+			return cv
+		}
+	}
+	return chartVersions[0]
+}
+
+// scoreTerm returns how well the chart matches the lower case search term, 0 if it does not match
+func scoreTerm(cv *repo.ChartVersion, term string) int {
+	score := 0
+	name := strings.ToLower(cv.Name)
+	switch {
+	case name == term:
+		score += scoreNameExact
+	case strings.HasPrefix(name, term):
+		score += scoreNamePrefix
+	case strings.Contains(name, term):
+		score += scoreNameContains
+	}
+	for _, keyword := range cv.Keywords {
+		keyword = strings.ToLower(keyword)
+		if keyword == term {
+			score += scoreKeywordExact
+			break
+		} else if strings.Contains(keyword, term) {
+			score += scoreKeywordContains
+			break
+		}
+	}
+	if strings.Contains(strings.ToLower(cv.Description), term) {
+		score += scoreDescription
+	}
+	for _, maintainer := range cv.Maintainers {
+		if strings.Contains(strings.ToLower(maintainer.Name), term) || strings.Contains(strings.ToLower(maintainer.Email), term) {
+			score += scoreMaintainer
+			break
+		}
+	}
+	return score
+}
+
+func hasKeyword(cv *repo.ChartVersion, keyword string) bool {
+	for _, k := range cv.Keywords {
+		if strings.EqualFold(k, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndex returns the charts of the repository index matching the query. The latest version
+// of a chart is matched. An empty query matches all charts.
+func searchIndex(repoName string, index *repo.IndexFile, query SearchQuery) []SearchResult {
+	terms := strings.Fields(strings.ToLower(query.Query))
+	results := []SearchResult{}
+	for _, chartVersions := range index.Entries {
+		if len(chartVersions) == 0 {
+			continue
+		}
+		cv := latestVersion(chartVersions)
+		if query.Keyword != "" && !hasKeyword(cv, query.Keyword) {
+			continue
+		}
+
+		score := 0
+		matches := true
+		for _, term := range terms {
+			termScore := scoreTerm(cv, term)
+			if termScore == 0 {
+				matches = false
+				break
+			}
+			score += termScore
+		}
+		if !matches {
+			continue
+		}
+
+		versions := make([]string, len(chartVersions))
+		for i, v := range chartVersions {
+			versions[i] = v.Version
+		}
+		results = append(results, SearchResult{
+			Repository:  repoName,
+			Name:        cv.Name,
+			Version:     cv.Version,
+			AppVersion:  cv.AppVersion,
+			Description: cv.Description,
+			Icon:        cv.Icon,
+			Keywords:    cv.Keywords,
+			Deprecated:  cv.Deprecated,
+			Versions:    versions,
+			Score:       score,
+		})
+	}
+	return results
+}
+
+// sortSearchResults sorts the results by descending score, deprecated charts last among equal scores
+func sortSearchResults(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Deprecated != b.Deprecated {
+			return !a.Deprecated
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Repository < b.Repository
+	})
+}
+
+// isSearchable returns whether the charts of the app repository are searched. Only Helm
+// repositories have an index, hidden repositories are only searched if they are requested.
+func isSearchable(appRepo *appRepov1.AppRepository, query SearchQuery) bool {
+	if appRepo.Spec.Type == OCIRepoType || appRepo.Spec.Type == GitRepoType {
+		return false
+	}
+	if query.Repo != "" {
+		return appRepo.Name == query.Repo
+	}
+	return appRepo.Annotations[hiddenAnnotation] != "true"
+}
+
+// isInstallable returns false for charts the policy of the app repository does not allow to install
+func isInstallable(appRepo *appRepov1.AppRepository, result SearchResult) bool {
+	if checkChartName(appRepo, result.Name) != nil {
+		return false
+	}
+	return appRepo.Spec.Policy == nil || !appRepo.Spec.Policy.BlockDeprecated || !result.Deprecated
+}
+
+// SearchCharts searches the indexes of all Helm app repositories. Charts which cannot be installed
+// due to the policy of their app repository are omitted. Repositories which are not reachable are
+// reported and do not fail the search.
+func (c *Client) SearchCharts(ctx context.Context, query SearchQuery) (*SearchResults, error) {
+	log := logUtils.GetLogger(ctx)
+	namespace := util.GetPodNamespace()
+
+	appRepos, err := c.appRepoClient.KubeappsV1alpha1().AppRepositories(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to list app repositories in namespace %s", namespace)
+	}
+
+	results := &SearchResults{Charts: []SearchResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range appRepos.Items {
+		appRepo := &appRepos.Items[i]
+		if !isSearchable(appRepo, query) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			index, err := c.fetchSearchIndex(ctx, appRepo)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Warnf("could not search app repository %s: %v", appRepo.Name, err)
+				results.UnavailableRepositories = append(results.UnavailableRepositories, appRepo.Name)
+				return
+			}
+			for _, result := range searchIndex(appRepo.Name, index, query) {
+				if isInstallable(appRepo, result) {
+					results.Charts = append(results.Charts, result)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sortSearchResults(results.Charts)
+	sort.Strings(results.UnavailableRepositories)
+	return results, nil
+}
+
+// fetchSearchIndex returns the index of the app repository using the repo index cache
+func (c *Client) fetchSearchIndex(ctx context.Context, appRepo *appRepov1.AppRepository) (*repo.IndexFile, error) {
+	netClient, err := c.initNetClient(ctx, appRepo)
+	if err != nil {
+		return nil, err
+	}
+	index, _, err := fetchRepoIndex(ctx, netClient, repoIndexURLs(append([]string{appRepo.Spec.URL}, appRepo.Spec.Mirrors...)))
+	return index, err
+}
@@ -0,0 +1,155 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeK8s "k8s.io/client-go/kubernetes/fake"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	fakeAppRepo "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/client/clientset/versioned/fake"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+func searchChartVersion(name, version, description string, keywords []string, maintainers ...string) *repo.ChartVersion {
+	metadata := &chart.Metadata{Name: name, Version: version, Description: description, Keywords: keywords}
+	for _, maintainer := range maintainers {
+		metadata.Maintainers = append(metadata.Maintainers, &chart.Maintainer{Name: maintainer})
+	}
+	return &repo.ChartVersion{Metadata: metadata}
+}
+
+func TestSearchIndex(t *testing.T) {
+	index := &repo.IndexFile{Entries: map[string]repo.ChartVersions{
+		"postgresql": {
+			searchChartVersion("postgresql", "9.0.0-rc.1", "PostgreSQL database", []string{"database", "sql"}),
+			searchChartVersion("postgresql", "8.2.0", "PostgreSQL database", []string{"database", "sql"}),
+			searchChartVersion("postgresql", "8.1.0", "PostgreSQL database", []string{"database"}),
+		},
+		"postgresql-ha": {
+			searchChartVersion("postgresql-ha", "1.0.0", "Highly available PostgreSQL", []string{"database", "postgresql"}),
+		},
+		"mysql": {
+			searchChartVersion("mysql", "1.0.0", "Relational database", []string{"database", "sql"}, "Jane Doe"),
+		},
+		"pgadmin": {
+			searchChartVersion("pgadmin", "2.0.0-beta.1", "Administration of postgresql databases", nil),
+		},
+	}}
+
+	testCases := []struct {
+		name     string
+		query    SearchQuery
+		expected []string
+	}{
+		{"empty query matches all charts", SearchQuery{}, []string{"mysql", "pgadmin", "postgresql", "postgresql-ha"}},
+		{"ranked by match", SearchQuery{Query: "PostgreSQL"}, []string{"postgresql", "postgresql-ha", "pgadmin"}},
+		{"all terms must match", SearchQuery{Query: "relational database"}, []string{"mysql"}},
+		{"keyword filter", SearchQuery{Query: "postgresql", Keyword: "SQL"}, []string{"postgresql"}},
+		{"maintainer", SearchQuery{Query: "jane"}, []string{"mysql"}},
+		{"no match", SearchQuery{Query: "redis"}, []string{}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			results := searchIndex("stable", index, tc.query)
+			sortSearchResults(results)
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			if got, want := names, tc.expected; !reflect.DeepEqual(got, want) {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+
+	results := searchIndex("stable", index, SearchQuery{Query: "postgresql"})
+	sortSearchResults(results)
+	if got, want := results[0].Version, "8.2.0"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := results[0].Versions, []string{"9.0.0-rc.1", "8.2.0", "8.1.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	// Charts with only pre-releases are found with their latest pre-release
+	if got, want := results[2].Version, "2.0.0-beta.1"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestSearchCharts(t *testing.T) {
+	stable := httptest.NewServer(&fakeChartRepo{t: t, charts: []*chart.Chart{
+		newChart("postgresql", "8.1.0"),
+		newChart("postgresql", "8.2.0"),
+		newChart("redis", "10.0.0"),
+	}})
+	defer stable.Close()
+	internal := httptest.NewServer(&fakeChartRepo{t: t, charts: []*chart.Chart{
+		newChart("postgresql", "1.0.0"),
+	}})
+	defer internal.Close()
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	defer unavailable.Close()
+
+	repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+	appRepos := []*appRepov1.AppRepository{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "stable", Namespace: metav1.NamespaceSystem},
+			Spec:       appRepov1.AppRepositorySpec{URL: stable.URL},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: metav1.NamespaceSystem, Annotations: map[string]string{hiddenAnnotation: "true"}},
+			Spec:       appRepov1.AppRepositorySpec{URL: internal.URL},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unavailable", Namespace: metav1.NamespaceSystem},
+			Spec:       appRepov1.AppRepositorySpec{URL: unavailable.URL},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: metav1.NamespaceSystem},
+			Spec:       appRepov1.AppRepositorySpec{Type: OCIRepoType, URL: unavailable.URL},
+		},
+	}
+	chUtils := Client{
+		kubeClient:    fakeK8s.NewSimpleClientset(),
+		appRepoClient: fakeAppRepo.NewSimpleClientset(appRepos[0], appRepos[1], appRepos[2], appRepos[3]),
+	}
+
+	nullLogger, _ := test.NewNullLogger()
+	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+
+	results, err := chUtils.SearchCharts(ctx, SearchQuery{Query: "postgresql"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := len(results.Charts), 1; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if got, want := results.Charts[0], (SearchResult{Repository: "stable", Name: "postgresql", Version: "8.2.0", Versions: []string{"8.2.0", "8.1.0"}, Score: scoreNameExact}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+	if got, want := results.UnavailableRepositories, []string{"unavailable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	// Hidden repositories are searched if they are requested
+	results, err = chUtils.SearchCharts(ctx, SearchQuery{Query: "postgresql", Repo: "internal"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := len(results.Charts), 1; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if got, want := results.Charts[0].Repository, "internal"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package chart
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// newRepoHTTPClient returns the HTTP client for requests to an app repository with the transport settings
+// of the app repository. Settings which are not set keep the defaults of all repositories.
+func newRepoHTTPClient(settings *appRepov1.AppRepositoryTransport, tlsConfig *tls.Config) (*http.Client, error) {
+	if settings == nil {
+		settings = &appRepov1.AppRepositoryTransport{}
+	}
+
+	proxy, err := proxyFunc(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Second * defaultTimeoutSeconds
+	if settings.TimeoutSeconds > 0 {
+		timeout = time.Second * time.Duration(settings.TimeoutSeconds)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   time.Second * time.Duration(settings.TLSHandshakeTimeoutSeconds),
+		ResponseHeaderTimeout: time.Second * time.Duration(settings.ResponseHeaderTimeoutSeconds),
+		IdleConnTimeout:       time.Second * time.Duration(settings.IdleConnTimeoutSeconds),
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+	}
+	// A transport with a custom TLS config uses HTTP/2 only if it is forced
+	if settings.EnableHTTP2 != nil && *settings.EnableHTTP2 {
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// proxyFunc returns the proxy of the requests to an app repository. The proxy URL and the
+// no proxy setting of the app repository replace the ones of the environment.
+func proxyFunc(settings *appRepov1.AppRepositoryTransport) (func(*http.Request) (*url.URL, error), error) {
+	if settings.DisableProxy {
+		return nil, nil
+	}
+	if settings.ProxyURL == "" && settings.NoProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	config := httpproxy.FromEnvironment()
+	if settings.ProxyURL != "" {
+		proxyURL, err := url.Parse(settings.ProxyURL)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, errorUtils.UnprocessableEntity.NewErrorf("Invalid proxy URL %q", settings.ProxyURL)
+		}
+		config.HTTPProxy = settings.ProxyURL
+		config.HTTPSProxy = settings.ProxyURL
+	}
+	if settings.NoProxy != "" {
+		config.NoProxy = settings.NoProxy
+	}
+
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// gitProxyEnv returns the environment of git commands for the proxy settings of an app repository
+func gitProxyEnv(settings *appRepov1.AppRepositoryTransport) []string {
+	if settings == nil {
+		return nil
+	}
+	if settings.DisableProxy {
+		return []string{"no_proxy=*", "NO_PROXY=*"}
+	}
+	var env []string
+	if settings.ProxyURL != "" {
+		env = append(env, "http_proxy="+settings.ProxyURL, "https_proxy="+settings.ProxyURL, "HTTPS_PROXY="+settings.ProxyURL)
+	}
+	if settings.NoProxy != "" {
+		env = append(env, "no_proxy="+settings.NoProxy, "NO_PROXY="+settings.NoProxy)
+	}
+	return env
+}
@@ -0,0 +1,133 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestProxyFunc(t *testing.T) {
+	testCases := []struct {
+		name          string
+		settings      *appRepov1.AppRepositoryTransport
+		requestURL    string
+		expectedProxy string
+	}{
+		{"proxy", &appRepov1.AppRepositoryTransport{ProxyURL: "http://proxy.acme.com:3128"}, "https://charts.example.com/index.yaml", "http://proxy.acme.com:3128"},
+		{"no proxy", &appRepov1.AppRepositoryTransport{ProxyURL: "http://proxy.acme.com:3128", NoProxy: ".acme.com"}, "https://charts.acme.com/index.yaml", ""},
+		{"other host than no proxy", &appRepov1.AppRepositoryTransport{ProxyURL: "http://proxy.acme.com:3128", NoProxy: ".acme.com"}, "https://charts.example.com/index.yaml", "http://proxy.acme.com:3128"},
+		{"disabled proxy", &appRepov1.AppRepositoryTransport{ProxyURL: "http://proxy.acme.com:3128", DisableProxy: true}, "https://charts.example.com/index.yaml", ""},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, err := proxyFunc(tc.settings)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if proxy == nil {
+				if tc.expectedProxy != "" {
+					t.Errorf("got: no proxy, want: %q", tc.expectedProxy)
+				}
+				return
+			}
+			req, err := http.NewRequest(http.MethodGet, tc.requestURL, nil)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			proxyURL, err := proxy(req)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			got := ""
+			if proxyURL != nil {
+				got = proxyURL.String()
+			}
+			if got != tc.expectedProxy {
+				t.Errorf("got: %q, want: %q", got, tc.expectedProxy)
+			}
+		})
+	}
+}
+
+func TestNewRepoHTTPClient(t *testing.T) {
+	enabled := true
+	client, err := newRepoHTTPClient(&appRepov1.AppRepositoryTransport{
+		TimeoutSeconds:         30,
+		IdleConnTimeoutSeconds: 90,
+		MaxIdleConnsPerHost:    4,
+		EnableHTTP2:            &enabled,
+	}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if client.Timeout != 30*time.Second {
+		t.Errorf("got: %v, want: %v", client.Timeout, 30*time.Second)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("got: %v, want: %v", transport.IdleConnTimeout, 90*time.Second)
+	}
+	if transport.MaxIdleConnsPerHost != 4 {
+		t.Errorf("got: %d, want: %d", transport.MaxIdleConnsPerHost, 4)
+	}
+	if !transport.ForceAttemptHTTP2 {
+		t.Errorf("got: HTTP/2 disabled, want: HTTP/2 enabled")
+	}
+
+	client, err = newRepoHTTPClient(nil, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if client.Timeout != defaultTimeoutSeconds*time.Second {
+		t.Errorf("got: %v, want: %v", client.Timeout, defaultTimeoutSeconds*time.Second)
+	}
+
+	_, err = newRepoHTTPClient(&appRepov1.AppRepositoryTransport{ProxyURL: "proxy.acme.com"}, nil)
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.UnprocessableEntity {
+		t.Errorf("got: %d, want: %d", got, errorUtils.UnprocessableEntity)
+	}
+}
+
+func TestInitNetClientUsesProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxiedURL = req.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	chUtils := Client{}
+	netClient, err := chUtils.initNetClient(context.TODO(), &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "custom-repo", Namespace: metav1.NamespaceSystem},
+		Spec: appRepov1.AppRepositorySpec{
+			URL:       "http://charts.example.com",
+			Transport: &appRepov1.AppRepositoryTransport{ProxyURL: proxy.URL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://charts.example.com/index.yaml", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	res, err := netClient.Do(req)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer res.Body.Close()
+	if proxiedURL != "http://charts.example.com/index.yaml" {
+		t.Errorf("got: %q, want: %q", proxiedURL, "http://charts.example.com/index.yaml")
+	}
+}
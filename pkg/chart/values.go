@@ -0,0 +1,68 @@
+package chart
+
+import (
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/strvals"
+	"sigs.k8s.io/yaml"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// MergeValues merges the values layers and overrides of the details into Values, so that Values
+// contains the values as helm receives them. Values is the first layer, followed by ValuesLayers
+// in their order, Set and SetString. Later layers override the keys of earlier ones, maps are
+// merged recursively.
+func (d *Details) MergeValues() error {
+	if len(d.ValuesLayers) == 0 && len(d.Set) == 0 && len(d.SetString) == 0 {
+		return nil
+	}
+
+	merged := map[string]interface{}{}
+	for i, layer := range append([]string{d.Values}, d.ValuesLayers...) {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(layer), &values); err != nil {
+			return errorUtils.BadRequest.New(errors.Wrapf(err, "Could not parse values layer %d", i))
+		}
+		merged = mergeValues(merged, values)
+	}
+	for _, value := range d.Set {
+		if err := strvals.ParseInto(value, merged); err != nil {
+			return errorUtils.BadRequest.New(errors.Wrapf(err, "Could not parse set value %q", value))
+		}
+	}
+	for _, value := range d.SetString {
+		if err := strvals.ParseIntoString(value, merged); err != nil {
+			return errorUtils.BadRequest.New(errors.Wrapf(err, "Could not parse set string value %q", value))
+		}
+	}
+
+	values, err := yaml.Marshal(merged)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal merged values")
+	}
+	d.Values = string(values)
+	d.ValuesLayers = nil
+	d.Set = nil
+	d.SetString = nil
+	return nil
+}
+
+// mergeValues merges the values of b into a like helm merges values files
+func mergeValues(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeValues(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
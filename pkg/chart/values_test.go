@@ -0,0 +1,73 @@
+package chart
+
+import (
+	"testing"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestMergeValues(t *testing.T) {
+	testCases := []struct {
+		name     string
+		details  Details
+		expected string
+		errType  errorUtils.HTTPErrorType
+	}{
+		{
+			name:     "values without layers are unchanged",
+			details:  Details{Values: "b: 1\na: 2"},
+			expected: "b: 1\na: 2",
+		},
+		{
+			name: "layers override earlier layers",
+			details: Details{
+				Values:       "image:\n  repository: nginx\n  tag: \"1.19\"\nreplicas: 1\n",
+				ValuesLayers: []string{"image:\n  tag: \"1.20\"\n", "replicas: 3\n"},
+			},
+			expected: "image:\n  repository: nginx\n  tag: \"1.20\"\nreplicas: 3\n",
+		},
+		{
+			name: "set overrides the layers",
+			details: Details{
+				ValuesLayers: []string{"image:\n  tag: \"1.20\"\nreplicas: 3\n"},
+				Set:          []string{"replicas=5,ingress.enabled=true"},
+				SetString:    []string{"image.tag=1.21"},
+			},
+			expected: "image:\n  tag: \"1.21\"\ningress:\n  enabled: true\nreplicas: 5\n",
+		},
+		{
+			name:    "invalid layer",
+			details: Details{ValuesLayers: []string{"foo: [bar"}},
+			errType: errorUtils.BadRequest,
+		},
+		{
+			name:    "invalid set value",
+			details: Details{Set: []string{"foo[=bar"}},
+			errType: errorUtils.BadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.details.MergeValues()
+			if tc.errType != 0 {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				if got, _ := errorUtils.GetHTTPErrorType(err); got != tc.errType {
+					t.Errorf("got: %d, want: %d", got, tc.errType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if tc.details.Values != tc.expected {
+				t.Errorf("got: %q, want: %q", tc.details.Values, tc.expected)
+			}
+			if tc.details.ValuesLayers != nil || tc.details.Set != nil || tc.details.SetString != nil {
+				t.Errorf("got: %v %v %v, want: merged overrides", tc.details.ValuesLayers, tc.details.Set, tc.details.SetString)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/provenance"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	"github.com/gardener/potter-hub/pkg/util"
+)
+
+func provenanceURL(chartURL string) (string, error) {
+	parsedURL, err := url.Parse(chartURL)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not parse chart url")
+	}
+	parsedURL.Path += ".prov"
+	return parsedURL.String(), nil
+}
+
+// verifyDigest checks the chart archive against the digest of its repo index entry. Entries
+// without a digest cannot be verified and are rejected.
+func verifyDigest(data []byte, digest string) error {
+	if digest == "" {
+		return errorUtils.UnprocessableEntity.NewError("Chart verification failed: the repository index has no digest of the chart")
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.TrimPrefix(digest, "sha256:") {
+		return errorUtils.UnprocessableEntity.NewError("Chart verification failed: sha256 sum does not match the digest of the repository index")
+	}
+	return nil
+}
+
+// getKeyring reads the public PGP keyring referenced by the AppRepository
+func (c *Client) getKeyring() (openpgp.EntityList, error) {
+	namespace := util.GetPodNamespace()
+	secretKeyRef := c.appRepo.Spec.Verification.Keyring.SecretKeyRef
+
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), secretKeyRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read secret %s in namespace %s", secretKeyRef.Name, namespace)
+	}
+	data, ok := secret.Data[secretKeyRef.Key]
+	if !ok {
+		return nil, errors.Errorf("Secret %q did not contain key %q", secretKeyRef.Name, secretKeyRef.Key)
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read keyring from secret %s", secretKeyRef.Name)
+	}
+	return keyring, nil
+}
+
+// verifyProvenance verifies the signature of the provenance file and the sha256 sum it
+// contains for fileName against the chart archive.
+func (c *Client) verifyProvenance(fileName string, data, provData []byte) error {
+	keyring, err := c.getKeyring()
+	if err != nil {
+		return err
+	}
+
+	// The helm provenance package only verifies files
+	dir, err := ioutil.TempDir("", "chart-verification")
+	if err != nil {
+		return errors.Wrap(err, "Could not create directory for chart verification")
+	}
+	defer os.RemoveAll(dir)
+
+	chartPath := filepath.Join(dir, fileName)
+	if err := ioutil.WriteFile(chartPath, data, 0600); err != nil {
+		return errors.Wrap(err, "Could not write chart for verification")
+	}
+	provPath := chartPath + ".prov"
+	if err := ioutil.WriteFile(provPath, provData, 0600); err != nil {
+		return errors.Wrap(err, "Could not write provenance file for verification")
+	}
+
+	signatory := &provenance.Signatory{KeyRing: keyring}
+	if _, err := signatory.Verify(chartPath, provPath); err != nil {
+		return errorUtils.UnprocessableEntity.New(errors.Wrap(err, "Chart verification failed"))
+	}
+	return nil
+}
+
+// verifyChart checks the chart archive against the digest of the repo index and verifies
+// the provenance file next to the chart URL.
+func (c *Client) verifyChart(netClient HTTPClient, chartURL, digest string, data []byte) error {
+	if err := verifyDigest(data, digest); err != nil {
+		return err
+	}
+
+	provURL, err := provenanceURL(chartURL)
+	if err != nil {
+		return err
+	}
+	provData, err := downloadChart(netClient, provURL)
+	if err != nil {
+		return errorUtils.UnprocessableEntity.New(errors.Wrapf(err, "Chart verification failed: could not fetch provenance file %s", provURL))
+	}
+
+	parsedURL, err := url.Parse(chartURL)
+	if err != nil {
+		return errors.Wrap(err, "Could not parse chart url")
+	}
+	return c.verifyProvenance(path.Base(parsedURL.Path), data, provData)
+}
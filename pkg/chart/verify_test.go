@@ -0,0 +1,295 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeK8s "k8s.io/client-go/kubernetes/fake"
+
+	appRepov1 "github.com/gardener/potter-hub/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// signedChart packages a chart and signs it with a new PGP key. It returns the archive,
+// its provenance file and the public keyring.
+func signedChart(t *testing.T, name, version, description string) ([]byte, []byte, []byte) {
+	dir, err := ioutil.TempDir("", "signed-chart")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	chartPath, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version, Description: description},
+	}, dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	entity, err := openpgp.NewEntity("Chart Signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signatory := &provenance.Signatory{Entity: entity}
+	prov, err := signatory.ClearSign(chartPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	keyring := &bytes.Buffer{}
+	if err := entity.Serialize(keyring); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	data, err := ioutil.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return data, []byte(prov), keyring.Bytes()
+}
+
+// fakeFileClient serves static files by URL
+type fakeFileClient struct {
+	files map[string][]byte
+}
+
+func (f *fakeFileClient) Do(req *http.Request) (*http.Response, error) {
+	data, ok := f.files[req.URL.String()]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestGetChartWithVerification(t *testing.T) {
+	const (
+		repoURL         = "http://charts.example.com/"
+		chartURL        = repoURL + "test-1.0.0.tgz"
+		keyringSecret   = "keyring"
+		keyringSecretID = "pubring.gpg"
+	)
+
+	data, prov, keyring := signedChart(t, "test", "1.0.0", "signed chart")
+	_, otherProv, otherKeyring := signedChart(t, "test", "1.0.0", "other chart")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	testCases := []struct {
+		name          string
+		digest        string
+		prov          []byte
+		keyring       []byte
+		errorExpected bool
+	}{
+		{
+			name:    "valid signature and digest",
+			digest:  digest,
+			prov:    prov,
+			keyring: keyring,
+		},
+		{
+			name:          "valid signature without digest in the index",
+			prov:          prov,
+			keyring:       keyring,
+			errorExpected: true,
+		},
+		{
+			name:          "digest does not match",
+			digest:        "0000",
+			prov:          prov,
+			keyring:       keyring,
+			errorExpected: true,
+		},
+		{
+			name:          "missing provenance file",
+			digest:        digest,
+			keyring:       keyring,
+			errorExpected: true,
+		},
+		{
+			name:          "signed by an unknown key",
+			digest:        digest,
+			prov:          prov,
+			keyring:       otherKeyring,
+			errorExpected: true,
+		},
+		{
+			name:          "provenance file of another archive",
+			digest:        digest,
+			prov:          otherProv,
+			keyring:       otherKeyring,
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repoIndexes = newIndexCache(DefaultIndexCacheMaxBytes, DefaultIndexCacheTTL)
+
+			cv := &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "test", Version: "1.0.0"},
+				URLs:     []string{chartURL},
+				Digest:   tc.digest,
+			}
+			index := &repo.IndexFile{APIVersion: "v1", Generated: time.Now(), Entries: map[string]repo.ChartVersions{"test": {cv}}}
+			indexData, err := json.Marshal(index)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			files := map[string][]byte{
+				repoURL + "index.yaml": indexData,
+				chartURL:               data,
+			}
+			if tc.prov != nil {
+				files[chartURL+".prov"] = tc.prov
+			}
+
+			chUtils := Client{
+				kubeClient: fakeK8s.NewSimpleClientset(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: keyringSecret, Namespace: metav1.NamespaceSystem},
+					Data:       map[string][]byte{keyringSecretID: tc.keyring},
+				}),
+				load: loader.LoadArchive,
+				appRepo: &appRepov1.AppRepository{
+					ObjectMeta: metav1.ObjectMeta{Name: "signed", Namespace: metav1.NamespaceSystem},
+					Spec: appRepov1.AppRepositorySpec{
+						URL: repoURL,
+						Verification: &appRepov1.AppRepositoryVerification{
+							Keyring: appRepov1.AppRepositoryKeyring{
+								SecretKeyRef: corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{Name: keyringSecret},
+									Key:                  keyringSecretID,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			ch, err := chUtils.GetChart(context.TODO(), &Details{ChartName: "test", Version: "1.0.0"}, &fakeFileClient{files: files})
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				if code, _ := errorUtils.GetHTTPErrorType(err); code != errorUtils.UnprocessableEntity {
+					t.Errorf("got: %d, want: %d", code, errorUtils.UnprocessableEntity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if got, want := ch.Metadata.Name, "test"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	data := []byte("chart")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	testCases := []struct {
+		name          string
+		digest        string
+		errorExpected bool
+	}{
+		{"matching digest", digest, false},
+		{"matching digest with algorithm", "sha256:" + digest, false},
+		{"digest does not match", "0000", true},
+		{"missing digest", "", true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyDigest(data, tc.digest)
+			if !tc.errorExpected {
+				if err != nil {
+					t.Fatalf("Unexpected error: %+v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+			if code, _ := errorUtils.GetHTTPErrorType(err); code != errorUtils.UnprocessableEntity {
+				t.Errorf("got: %d, want: %d", code, errorUtils.UnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestProvenanceURL(t *testing.T) {
+	provURL, err := provenanceURL("https://charts.example.com/test-1.0.0.tgz?token=abc")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := provURL, "https://charts.example.com/test-1.0.0.tgz.prov?token=abc"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// Ensure the keyring secret can also contain an armored keyring
+func TestGetKeyringArmored(t *testing.T) {
+	_, _, keyring := signedChart(t, "test", "1.0.0", "signed chart")
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	armored := &bytes.Buffer{}
+	w, err := armor.Encode(armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := entities[0].Serialize(w); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	w.Close()
+
+	chUtils := Client{
+		kubeClient: fakeK8s.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "keyring", Namespace: metav1.NamespaceSystem},
+			Data:       map[string][]byte{"pubring.asc": armored.Bytes()},
+		}),
+		appRepo: &appRepov1.AppRepository{
+			Spec: appRepov1.AppRepositorySpec{
+				Verification: &appRepov1.AppRepositoryVerification{
+					Keyring: appRepov1.AppRepositoryKeyring{
+						SecretKeyRef: corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "keyring"},
+							Key:                  "pubring.asc",
+						},
+					},
+				},
+			},
+		},
+	}
+	parsed, err := chUtils.getKeyring()
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if got, want := len(parsed), 1; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
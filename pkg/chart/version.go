@@ -0,0 +1,59 @@
+package chart
+
+import (
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+// validateVersion checks that the requested chart version is either empty, an exact
+// version or a semver constraint like "~1.4", "^2.0.0" or ">=3.1 <4".
+func validateVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	if _, err := semver.NewVersion(version); err == nil {
+		return nil
+	}
+	if _, err := semver.NewConstraint(version); err != nil {
+		return errorUtils.BadRequest.New(errors.Wrapf(err, "Invalid chart version or constraint %q", version))
+	}
+	return nil
+}
+
+// resolveVersion returns the highest of the given versions which matches the version
+// constraint. A version equal to the constraint is always returned. An empty constraint
+// matches the latest version.
+// Pre-release versions are only considered if the constraint contains a pre-release,
+// e.g. "^2.0.0-0", or if the exact pre-release version is requested.
+func resolveVersion(versions []string, versionConstraint string) (string, bool) {
+	for _, version := range versions {
+		if versionConstraint != "" && version == versionConstraint {
+			return version, true
+		}
+	}
+
+	constraintString := versionConstraint
+	if constraintString == "" {
+		constraintString = "*"
+	}
+	constraint, err := semver.NewConstraint(constraintString)
+	if err != nil {
+		return "", false
+	}
+
+	var latest *semver.Version
+	latestVersion := ""
+	for _, version := range versions {
+		parsed, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if constraint.Check(parsed) && (latest == nil || parsed.GreaterThan(latest)) {
+			latest = parsed
+			latestVersion = version
+		}
+	}
+	return latestVersion, latest != nil
+}
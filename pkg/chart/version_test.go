@@ -0,0 +1,61 @@
+package chart
+
+import (
+	"testing"
+)
+
+func TestResolveVersion(t *testing.T) {
+	versions := []string{"1.3.9", "1.4.0", "1.4.7", "1.5.0", "2.0.0", "2.1.0-rc.1", "2.0.3", "3.0.0", "3.2.1", "4.0.0"}
+
+	testCases := []struct {
+		constraint string
+		expected   string
+		found      bool
+	}{
+		{"", "4.0.0", true},
+		{"1.4.0", "1.4.0", true},
+		{"~1.4", "1.4.7", true},
+		{"^2.0.0", "2.0.3", true},
+		{">=3.1 <4", "3.2.1", true},
+		{"^2.1.0-0", "2.1.0-rc.1", true},
+		{"2.1.0-rc.1", "2.1.0-rc.1", true},
+		{"^5", "", false},
+		{"9.9.9", "", false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.constraint, func(t *testing.T) {
+			version, found := resolveVersion(versions, tc.constraint)
+			if found != tc.found {
+				t.Fatalf("got: %t, want: %t", found, tc.found)
+			}
+			if version != tc.expected {
+				t.Errorf("got: %q, want: %q", version, tc.expected)
+			}
+		})
+	}
+}
+
+func TestResolveVersionIgnoresPrereleases(t *testing.T) {
+	version, found := resolveVersion([]string{"1.0.0", "1.1.0-beta.1"}, "")
+	if !found {
+		t.Fatalf("expected a version")
+	}
+	if got, want := version, "1.0.0"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for _, version := range []string{"", "1.0.0", "v1.0.0", "~1.4", "^2.0.0", ">=3.1 <4", "2.1.0-rc.1"} {
+		if err := validateVersion(version); err != nil {
+			t.Errorf("Unexpected error for %q: %v", version, err)
+		}
+	}
+	for _, version := range []string{"latest", ">=a.b"} {
+		if err := validateVersion(version); err == nil {
+			t.Errorf("expected error for %q", version)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package credentials
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// GCPProvider exchanges a Google Cloud service account key for access tokens
+	GCPProvider = "gcp"
+
+	// GCPServiceAccountKey contains the JSON key of the service account
+	GCPServiceAccountKey = "serviceAccountKey"
+	// GCPScopesKey contains the space or comma separated OAuth scopes of the access tokens
+	GCPScopesKey = "scopes"
+
+	gcpDefaultScope = "https://www.googleapis.com/auth/devstorage.read_only"
+)
+
+func init() {
+	Register(GCPProvider, newGCPProvider)
+}
+
+func newGCPProvider(credentials map[string][]byte) (Provider, error) {
+	serviceAccountKey, ok := credentials[GCPServiceAccountKey]
+	if !ok {
+		return nil, errors.Errorf("GCP credentials require %q", GCPServiceAccountKey)
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(serviceAccountKey, scopes(credentials, GCPScopesKey, gcpDefaultScope)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't create Google Service Account object")
+	}
+	return &tokenProvider{
+		cacheKey: cacheKey(GCPProvider, credentials),
+		newSource: func(ctx context.Context) oauth2.TokenSource {
+			return jwtConfig.TokenSource(ctx)
+		},
+	}, nil
+}
@@ -0,0 +1,46 @@
+package credentials
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// OAuth2Provider fetches access tokens with the OAuth2 client credentials flow
+	OAuth2Provider = "oauth2"
+
+	// OAuth2TokenURLKey contains the URL of the token endpoint
+	OAuth2TokenURLKey = "tokenURL"
+	// OAuth2ClientIDKey and OAuth2ClientSecretKey contain the credentials of the client
+	OAuth2ClientIDKey     = "clientID"
+	OAuth2ClientSecretKey = "clientSecret"
+	// OAuth2ScopesKey contains the space or comma separated scopes of the access tokens
+	OAuth2ScopesKey = "scopes"
+)
+
+func init() {
+	Register(OAuth2Provider, newOAuth2Provider)
+}
+
+func newOAuth2Provider(credentials map[string][]byte) (Provider, error) {
+	for _, key := range []string{OAuth2TokenURLKey, OAuth2ClientIDKey, OAuth2ClientSecretKey} {
+		if _, ok := credentials[key]; !ok {
+			return nil, errors.Errorf("OAuth2 credentials require %q", key)
+		}
+	}
+	config := &clientcredentials.Config{
+		TokenURL:     string(credentials[OAuth2TokenURLKey]),
+		ClientID:     string(credentials[OAuth2ClientIDKey]),
+		ClientSecret: string(credentials[OAuth2ClientSecretKey]),
+		Scopes:       scopes(credentials, OAuth2ScopesKey),
+	}
+	return &tokenProvider{
+		cacheKey: cacheKey(OAuth2Provider, credentials),
+		newSource: func(ctx context.Context) oauth2.TokenSource {
+			return config.TokenSource(ctx)
+		},
+	}, nil
+}
@@ -0,0 +1,244 @@
+// Package credentials creates the authorization headers for requests to chart repositories and
+// registries. Providers for registry vendors register themselves by name, so that an AppRepository
+// can select them without changes to the code using the credentials.
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/gardener/potter-hub/pkg/util"
+)
+
+const (
+	// defaultRefreshBefore is the time before their expiry at which cached tokens are refreshed
+	defaultRefreshBefore = time.Minute
+	// defaultMaxCachedTokens is the number of cached tokens, one per provider and credentials
+	defaultMaxCachedTokens = 1000
+	// defaultTokenTimeout limits requests to token endpoints if the context has no HTTP client
+	defaultTokenTimeout = 30 * time.Second
+)
+
+// Provider returns the value of the Authorization header for requests to a repository
+type Provider interface {
+	AuthorizationHeader(ctx context.Context) (string, error)
+}
+
+// Factory creates a provider from credentials, e.g. the data of a secret. The keys of the
+// credentials depend on the provider.
+type Factory func(credentials map[string][]byte) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a provider available under the name. Registering a name twice replaces the provider.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// New returns the provider registered under the name for the credentials
+func New(name string, credentials map[string][]byte) (Provider, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("Unknown credential provider %q", name)
+	}
+	return factory(credentials)
+}
+
+// FromAuthorizationHeader returns the provider for an authorization header configured as is.
+// Basic auth with the user "_json_key" contains a GCP service account key which is exchanged
+// for an access token, all other headers are used unchanged.
+func FromAuthorizationHeader(header string) (Provider, error) {
+	if strings.HasPrefix(header, "Basic ") {
+		username, password, err := util.DecodeBasicAuthCredentials(strings.TrimPrefix(header, "Basic "))
+		if err != nil {
+			return nil, err
+		}
+		if username == "_json_key" {
+			return New(GCPProvider, map[string][]byte{GCPServiceAccountKey: []byte(password)})
+		}
+	}
+	return New(StaticProvider, map[string][]byte{StaticAuthorizationHeaderKey: []byte(header)})
+}
+
+// WithHTTPClient returns a context whose providers request tokens with the client, e.g. the client of
+// a repository with its proxy, CA and timeout
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	if client == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+type cachedToken struct {
+	token    *oauth2.Token
+	lastUsed time.Time
+	// refreshing is closed when the running refresh of the token is finished
+	refreshing chan struct{}
+	err        error
+}
+
+// tokenCache caches the tokens of token sources until shortly before they expire. Concurrent
+// requests for the same token share one refresh. The least recently used tokens are evicted if
+// there are more than maxEntries.
+type tokenCache struct {
+	mu            sync.Mutex
+	entries       map[string]*cachedToken
+	maxEntries    int
+	refreshBefore time.Duration
+	now           func() time.Time
+}
+
+func newTokenCache(refreshBefore time.Duration, maxEntries int) *tokenCache {
+	return &tokenCache{
+		entries:       map[string]*cachedToken{},
+		maxEntries:    maxEntries,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// Cache the tokens of all providers since they are created for each request
+// nolint
+var tokens = newTokenCache(defaultRefreshBefore, defaultMaxCachedTokens)
+
+func (c *tokenCache) isFresh(token *oauth2.Token) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	// Tokens without expiry never expire
+	return token.Expiry.IsZero() || c.now().Add(c.refreshBefore).Before(token.Expiry)
+}
+
+// token returns the cached token of the key or a new token of the source. Requests waiting for the
+// refresh of another request stop waiting when their context is done.
+func (c *tokenCache) token(ctx context.Context, key string, newSource func(context.Context) oauth2.TokenSource) (*oauth2.Token, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.evict()
+		entry = &cachedToken{}
+		c.entries[key] = entry
+	}
+	entry.lastUsed = c.now()
+	if c.isFresh(entry.token) {
+		defer c.mu.Unlock()
+		return entry.token, nil
+	}
+	if refreshing := entry.refreshing; refreshing != nil {
+		c.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "Could not fetch access token")
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return entry.token, nil
+	}
+	refreshing := make(chan struct{})
+	entry.refreshing = refreshing
+	c.mu.Unlock()
+
+	token, err := fetchToken(ctx, newSource)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.token, entry.err, entry.refreshing = token, err, nil
+	close(refreshing)
+	return token, err
+}
+
+// fetchToken fetches a new token of the source. Token endpoints are requested with the client of the
+// context, or with a client with the default timeout so that a hung endpoint does not block requests.
+func fetchToken(ctx context.Context, newSource func(context.Context) oauth2.TokenSource) (*oauth2.Token, error) {
+	if _, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); !ok {
+		ctx = WithHTTPClient(ctx, &http.Client{Timeout: defaultTokenTimeout})
+	}
+	token, err := newSource(ctx).Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not fetch access token")
+	}
+	return token, nil
+}
+
+// evict removes the least recently used tokens which are not being refreshed until a new token fits
+// into the cache
+func (c *tokenCache) evict() {
+	for c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		oldestKey := ""
+		var oldest *cachedToken
+		for key, entry := range c.entries {
+			if entry.refreshing == nil && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(c.entries, oldestKey)
+	}
+}
+
+// cacheKey identifies the tokens of a provider and its credentials
+func cacheKey(name string, credentials map[string][]byte) string {
+	keys := make([]string, 0, len(credentials))
+	for key := range credentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hasher := sha256.New()
+	_, _ = hasher.Write([]byte(name))
+	for _, key := range keys {
+		_, _ = hasher.Write([]byte{0})
+		_, _ = hasher.Write([]byte(key))
+		_, _ = hasher.Write([]byte{0})
+		_, _ = hasher.Write(credentials[key])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// tokenProvider returns the cached access tokens of a token source as authorization header. The
+// source is created with the context of the request fetching a new token.
+type tokenProvider struct {
+	cacheKey  string
+	newSource func(ctx context.Context) oauth2.TokenSource
+}
+
+func (p *tokenProvider) AuthorizationHeader(ctx context.Context) (string, error) {
+	token, err := tokens.token(ctx, p.cacheKey, p.newSource)
+	if err != nil {
+		return "", err
+	}
+	return token.Type() + " " + token.AccessToken, nil
+}
+
+// scopes returns the space or comma separated scopes of the credentials or the default scopes
+func scopes(credentials map[string][]byte, key string, defaultScopes ...string) []string {
+	value, ok := credentials[key]
+	if !ok {
+		return defaultScopes
+	}
+	return strings.FieldsFunc(string(value), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\n'
+	})
+}
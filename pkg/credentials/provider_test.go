@@ -0,0 +1,335 @@
+package credentials
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestStaticProvider(t *testing.T) {
+	testCases := []struct {
+		name        string
+		credentials map[string][]byte
+		expected    string
+	}{
+		{"authorization header", map[string][]byte{StaticAuthorizationHeaderKey: []byte("Bearer abc")}, "Bearer abc"},
+		{"token", map[string][]byte{StaticTokenKey: []byte("abc")}, "Bearer abc"},
+		{"basic auth", map[string][]byte{StaticUsernameKey: []byte("user"), StaticPasswordKey: []byte("pass")}, "Basic dXNlcjpwYXNz"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := New(StaticProvider, tc.credentials)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			header, err := provider.AuthorizationHeader(context.TODO())
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if header != tc.expected {
+				t.Errorf("got: %q, want: %q", header, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		provider    string
+		credentials map[string][]byte
+	}{
+		{"unknown provider", "vault", map[string][]byte{}},
+		{"static without credentials", StaticProvider, map[string][]byte{StaticUsernameKey: []byte("user")}},
+		{"gcp without key", GCPProvider, map[string][]byte{}},
+		{"gcp with invalid key", GCPProvider, map[string][]byte{GCPServiceAccountKey: []byte("{")}},
+		{"oauth2 without client secret", OAuth2Provider, map[string][]byte{OAuth2TokenURLKey: []byte("http://localhost"), OAuth2ClientIDKey: []byte("id")}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.provider, tc.credentials); err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+		})
+	}
+}
+
+type fakeTokenSource struct {
+	calls int
+	ttl   time.Duration
+	// block delays the tokens until it is closed if set
+	block chan struct{}
+	// requested receives the token requests if set
+	requested chan struct{}
+}
+
+func (s *fakeTokenSource) Token() (*oauth2.Token, error) {
+	if s.requested != nil {
+		s.requested <- struct{}{}
+	}
+	if s.block != nil {
+		<-s.block
+	}
+	s.calls++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", s.calls),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(s.ttl),
+	}, nil
+}
+
+func (s *fakeTokenSource) newSource(ctx context.Context) oauth2.TokenSource {
+	return s
+}
+
+func TestTokenCache(t *testing.T) {
+	cache := newTokenCache(time.Minute, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	source := &fakeTokenSource{ttl: 10 * time.Minute}
+
+	for i := 0; i < 2; i++ {
+		token, err := cache.token(context.TODO(), "key", source.newSource)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if token.AccessToken != "token-1" {
+			t.Errorf("got: %q, want: %q", token.AccessToken, "token-1")
+		}
+	}
+
+	// Tokens are refreshed shortly before they expire
+	now = now.Add(9*time.Minute + time.Second)
+	token, err := cache.token(context.TODO(), "key", source.newSource)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if token.AccessToken != "token-2" {
+		t.Errorf("got: %q, want: %q", token.AccessToken, "token-2")
+	}
+
+	if _, err := cache.token(context.TODO(), "other", source.newSource); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if source.calls != 3 {
+		t.Errorf("got: %d calls, want: %d calls", source.calls, 3)
+	}
+
+	// The least recently used token is evicted
+	now = now.Add(time.Second)
+	if _, err := cache.token(context.TODO(), "other", source.newSource); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := cache.token(context.TODO(), "third", source.newSource); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := cache.entries["key"]; ok || len(cache.entries) != 2 {
+		t.Errorf("got: %d tokens, want: token of key evicted", len(cache.entries))
+	}
+}
+
+// Requests waiting for the refresh of a hung token endpoint stop waiting with their context
+func TestTokenCacheWithHungEndpoint(t *testing.T) {
+	cache := newTokenCache(time.Minute, 10)
+	source := &fakeTokenSource{ttl: 10 * time.Minute, block: make(chan struct{}), requested: make(chan struct{}, 1)}
+
+	refreshed := make(chan error)
+	go func() {
+		_, err := cache.token(context.TODO(), "key", source.newSource)
+		refreshed <- err
+	}()
+	<-source.requested
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	waiting := make(chan error)
+	go func() {
+		_, err := cache.token(ctx, "key", source.newSource)
+		waiting <- err
+	}()
+	select {
+	case err := <-waiting:
+		if err == nil {
+			t.Errorf("got: nil, want: error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("got: blocked request, want: error")
+	}
+
+	close(source.block)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if source.calls != 1 {
+		t.Errorf("got: %d calls, want: %d calls", source.calls, 1)
+	}
+}
+
+// fakeTokenEndpoint counts the token requests and returns a new access token for each of them
+type fakeTokenEndpoint struct {
+	t        *testing.T
+	requests int
+	validate func(req *http.Request)
+}
+
+func (e *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	e.requests++
+	if err := req.ParseForm(); err != nil {
+		e.t.Fatalf("%+v", err)
+	}
+	if e.validate != nil {
+		e.validate(req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": fmt.Sprintf("access-token-%d", e.requests),
+		"token_type":   "bearer",
+		"expires_in":   3600,
+	})
+}
+
+func TestOAuth2Provider(t *testing.T) {
+	tokens = newTokenCache(defaultRefreshBefore, defaultMaxCachedTokens)
+	endpoint := &fakeTokenEndpoint{t: t, validate: func(req *http.Request) {
+		if got := req.Form.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("got: %q, want: %q", got, "client_credentials")
+		}
+		if got := req.Form.Get("scope"); got != "read write" {
+			t.Errorf("got: %q, want: %q", got, "read write")
+		}
+		if username, password, ok := req.BasicAuth(); !ok || username != "client" || password != "secret" {
+			t.Errorf("got: %q:%q, want: %q:%q", username, password, "client", "secret")
+		}
+	}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	credentials := map[string][]byte{
+		OAuth2TokenURLKey:     []byte(server.URL),
+		OAuth2ClientIDKey:     []byte("client"),
+		OAuth2ClientSecretKey: []byte("secret"),
+		OAuth2ScopesKey:       []byte("read,write"),
+	}
+	// Providers are created for each request and share the cached token
+	for i := 0; i < 2; i++ {
+		provider, err := New(OAuth2Provider, credentials)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		header, err := provider.AuthorizationHeader(context.TODO())
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if header != "Bearer access-token-1" {
+			t.Errorf("got: %q, want: %q", header, "Bearer access-token-1")
+		}
+	}
+	if endpoint.requests != 1 {
+		t.Errorf("got: %d requests, want: %d requests", endpoint.requests, 1)
+	}
+}
+
+// roundTripCounter counts the requests of a client
+type roundTripCounter struct {
+	requests int
+}
+
+func (c *roundTripCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Token endpoints are requested with the client of the context, e.g. the client of the repository
+func TestOAuth2ProviderWithHTTPClient(t *testing.T) {
+	tokens = newTokenCache(defaultRefreshBefore, defaultMaxCachedTokens)
+	server := httptest.NewServer(&fakeTokenEndpoint{t: t})
+	defer server.Close()
+
+	provider, err := New(OAuth2Provider, map[string][]byte{
+		OAuth2TokenURLKey:     []byte(server.URL),
+		OAuth2ClientIDKey:     []byte("client"),
+		OAuth2ClientSecretKey: []byte("secret"),
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	transport := &roundTripCounter{}
+	ctx := WithHTTPClient(context.TODO(), &http.Client{Transport: transport})
+	if _, err := provider.AuthorizationHeader(ctx); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if transport.requests != 1 {
+		t.Errorf("got: %d requests, want: %d requests", transport.requests, 1)
+	}
+}
+
+func serviceAccountKey(t *testing.T, tokenURI string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	serviceAccountKey, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test",
+		"private_key_id": "1",
+		"private_key":    string(privateKey),
+		"client_email":   "sync@test.iam.gserviceaccount.com",
+		"token_uri":      tokenURI,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return serviceAccountKey
+}
+
+func TestFromAuthorizationHeader(t *testing.T) {
+	tokens = newTokenCache(defaultRefreshBefore, defaultMaxCachedTokens)
+	endpoint := &fakeTokenEndpoint{t: t, validate: func(req *http.Request) {
+		if got := req.Form.Get("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("got: %q, want: %q", got, "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		}
+	}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	jsonKey := base64.StdEncoding.EncodeToString(append([]byte("_json_key:"), serviceAccountKey(t, server.URL)...))
+
+	testCases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"bearer token", "Bearer abc", "Bearer abc"},
+		{"basic auth", "Basic dXNlcjpwYXNz", "Basic dXNlcjpwYXNz"},
+		{"gcp service account key", "Basic " + jsonKey, "Bearer access-token-1"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := FromAuthorizationHeader(tc.header)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			header, err := provider.AuthorizationHeader(context.TODO())
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if header != tc.expected {
+				t.Errorf("got: %q, want: %q", header, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// StaticProvider uses fixed credentials
+	StaticProvider = "static"
+
+	// StaticAuthorizationHeaderKey contains the complete authorization header, e.g. "Bearer <token>"
+	StaticAuthorizationHeaderKey = "authorizationHeader"
+	// StaticTokenKey contains a bearer token
+	StaticTokenKey = "token"
+	// StaticUsernameKey and StaticPasswordKey contain basic auth credentials
+	StaticUsernameKey = "username"
+	StaticPasswordKey = "password"
+)
+
+func init() {
+	Register(StaticProvider, newStaticProvider)
+}
+
+type staticProvider struct {
+	header string
+}
+
+func newStaticProvider(credentials map[string][]byte) (Provider, error) {
+	if header, ok := credentials[StaticAuthorizationHeaderKey]; ok {
+		return &staticProvider{header: string(header)}, nil
+	}
+	if token, ok := credentials[StaticTokenKey]; ok {
+		return &staticProvider{header: "Bearer " + string(token)}, nil
+	}
+	username, hasUsername := credentials[StaticUsernameKey]
+	password, hasPassword := credentials[StaticPasswordKey]
+	if hasUsername && hasPassword {
+		basicAuth := base64.StdEncoding.EncodeToString([]byte(string(username) + ":" + string(password)))
+		return &staticProvider{header: "Basic " + basicAuth}, nil
+	}
+	return nil, errors.Errorf("Static credentials require %q, %q or %q and %q", StaticAuthorizationHeaderKey, StaticTokenKey, StaticUsernameKey, StaticPasswordKey)
+}
+
+func (p *staticProvider) AuthorizationHeader(ctx context.Context) (string, error) {
+	return p.header, nil
+}
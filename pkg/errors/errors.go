@@ -7,13 +7,14 @@ import (
 )
 
 const (
-	BadRequest          = HTTPErrorType(400)
-	Unauthorized        = HTTPErrorType(401)
-	Forbidden           = HTTPErrorType(403)
-	NotFound            = HTTPErrorType(404)
-	Conflict            = HTTPErrorType(409)
-	UnprocessableEntity = HTTPErrorType(422)
-	InternalServerError = HTTPErrorType(500)
+	BadRequest            = HTTPErrorType(400)
+	Unauthorized          = HTTPErrorType(401)
+	Forbidden             = HTTPErrorType(403)
+	NotFound              = HTTPErrorType(404)
+	Conflict              = HTTPErrorType(409)
+	RequestEntityTooLarge = HTTPErrorType(413)
+	UnprocessableEntity   = HTTPErrorType(422)
+	InternalServerError   = HTTPErrorType(500)
 )
 
 type HTTPErrorType uint
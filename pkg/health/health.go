@@ -0,0 +1,283 @@
+// Package health computes the health of the resources of a release. The states are the readiness
+// states of the hub, so that helm releases and the applications of cluster boms report readiness
+// the same way.
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+)
+
+// States of resources and releases
+const (
+	StateOK      = "ok"
+	StatePending = "pending"
+	StateFailed  = "failed"
+	StateUnknown = "unknown"
+)
+
+// ResourceHealth is the health of a resource of a release
+type ResourceHealth struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	// Message explains why a resource is not ok, e.g. "1 of 3 replicas ready"
+	Message string `json:"message,omitempty"`
+}
+
+// ReleaseHealth is the aggregated health of the resources of a release
+type ReleaseHealth struct {
+	State     string           `json:"state"`
+	Resources []ResourceHealth `json:"resources"`
+}
+
+// NewReleaseHealth aggregates the health of the resources of a release. A release is failed if a
+// resource is failed, pending if a resource is pending, unknown if the health of a resource is
+// unknown and ok otherwise.
+func NewReleaseHealth(resources []ResourceHealth) *ReleaseHealth {
+	state := StateOK
+	for _, resource := range resources {
+		if stateOrder(resource.State) > stateOrder(state) {
+			state = resource.State
+		}
+	}
+	return &ReleaseHealth{State: state, Resources: resources}
+}
+
+func stateOrder(state string) int {
+	switch state {
+	case StateFailed:
+		return 3
+	case StatePending:
+		return 2
+	case StateUnknown:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// String prints the resources like kubectl
+func (h *ReleaseHealth) String() string {
+	out := &strings.Builder{}
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tSTATE\tMESSAGE")
+	for _, resource := range h.Resources {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", resource.Kind, resource.Namespace, resource.Name, resource.State, resource.Message)
+	}
+	_ = w.Flush()
+	return out.String()
+}
+
+// NewResourceHealth returns the health of a resource as it is in the cluster. Resources with ready
+// requirements of the hub are ok if the values at the field paths of the requirements are success
+// values. Otherwise Deployments, StatefulSets and DaemonSets are ok if all replicas are updated and
+// ready, Jobs if they are complete, like the jobs of ready requirements, and PersistentVolumeClaims
+// if they are bound. Other resources are ok if they exist.
+func NewResourceHealth(obj *unstructured.Unstructured, requirements ...hubv1.Resource) ResourceHealth {
+	resourceHealth := ResourceHealth{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		State:      StateOK,
+	}
+	if len(requirements) > 0 {
+		for _, requirement := range requirements {
+			state, message, err := requirementHealth(obj, requirement)
+			if err != nil {
+				state, message = StateUnknown, err.Error()
+			}
+			if stateOrder(state) > stateOrder(resourceHealth.State) {
+				resourceHealth.State, resourceHealth.Message = state, message
+			}
+		}
+		return resourceHealth
+	}
+
+	var err error
+	group := obj.GroupVersionKind().Group
+	switch {
+	case obj.GetKind() == "Deployment" && (group == "apps" || group == "extensions"):
+		deployment := &appsv1.Deployment{}
+		if err = fromUnstructured(obj, deployment); err == nil {
+			resourceHealth.State, resourceHealth.Message = deploymentHealth(deployment)
+		}
+	case obj.GetKind() == "StatefulSet" && group == "apps":
+		statefulSet := &appsv1.StatefulSet{}
+		if err = fromUnstructured(obj, statefulSet); err == nil {
+			resourceHealth.State, resourceHealth.Message = statefulSetHealth(statefulSet)
+		}
+	case obj.GetKind() == "DaemonSet" && (group == "apps" || group == "extensions"):
+		daemonSet := &appsv1.DaemonSet{}
+		if err = fromUnstructured(obj, daemonSet); err == nil {
+			resourceHealth.State, resourceHealth.Message = daemonSetHealth(daemonSet)
+		}
+	case obj.GetKind() == "Job" && group == "batch":
+		job := &batchv1.Job{}
+		if err = fromUnstructured(obj, job); err == nil {
+			resourceHealth.State, resourceHealth.Message = jobHealth(job)
+		}
+	case obj.GetKind() == "PersistentVolumeClaim" && group == "":
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err = fromUnstructured(obj, pvc); err == nil {
+			resourceHealth.State, resourceHealth.Message = pvcHealth(pvc)
+		}
+	}
+	if err != nil {
+		resourceHealth.State = StateUnknown
+		resourceHealth.Message = err.Error()
+	}
+	return resourceHealth
+}
+
+// requirementHealth evaluates a ready requirement of the hub on a resource. The resource is ok if
+// the value at the field path is one of the success values, or if it is set if there are no success
+// values. It is pending otherwise.
+func requirementHealth(obj *unstructured.Unstructured, requirement hubv1.Resource) (string, string, error) {
+	fieldPath := requirement.FieldPath
+	if !strings.HasPrefix(fieldPath, "{") {
+		fieldPath = "{" + fieldPath + "}"
+	}
+	parser := jsonpath.New("fieldPath")
+	if err := parser.Parse(fieldPath); err != nil {
+		return "", "", errors.Wrapf(err, "Invalid field path %q", requirement.FieldPath)
+	}
+	results, err := parser.FindResults(obj.Object)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		return StatePending, fmt.Sprintf("Field %s is not set", requirement.FieldPath), nil
+	}
+	value, err := json.Marshal(results[0][0].Interface())
+	if err != nil {
+		return "", "", errors.Wrapf(err, "Could not read field %s", requirement.FieldPath)
+	}
+	if len(requirement.SuccessValues) == 0 {
+		return StateOK, "", nil
+	}
+
+	for _, successValue := range requirement.SuccessValues {
+		// The success values are normalized like the value, e.g. the formatting of numbers
+		var expected interface{}
+		if err := json.Unmarshal(successValue.Raw, &expected); err != nil {
+			return "", "", errors.Wrapf(err, "Invalid success value of field %s", requirement.FieldPath)
+		}
+		normalized, err := json.Marshal(expected)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "Invalid success value of field %s", requirement.FieldPath)
+		}
+		if bytes.Equal(value, normalized) {
+			return StateOK, "", nil
+		}
+	}
+	return StatePending, fmt.Sprintf("Field %s is %s", requirement.FieldPath, value), nil
+}
+
+func fromUnstructured(obj *unstructured.Unstructured, into interface{}) error {
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into)
+	return errors.Wrapf(err, "Could not parse %s %s", obj.GetKind(), obj.GetName())
+}
+
+// replicas returns the desired replicas, which default to 1
+func replicas(specReplicas *int32) int32 {
+	if specReplicas == nil {
+		return 1
+	}
+	return *specReplicas
+}
+
+func deploymentHealth(deployment *appsv1.Deployment) (string, string) {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return StateFailed, condition.Message
+		}
+	}
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return StatePending, "Waiting for the rollout to be observed"
+	}
+	desired := replicas(deployment.Spec.Replicas)
+	if deployment.Status.UpdatedReplicas < desired {
+		return StatePending, fmt.Sprintf("%d of %d replicas updated", deployment.Status.UpdatedReplicas, desired)
+	}
+	if deployment.Status.Replicas > deployment.Status.UpdatedReplicas {
+		return StatePending, fmt.Sprintf("%d old replicas pending termination", deployment.Status.Replicas-deployment.Status.UpdatedReplicas)
+	}
+	if deployment.Status.AvailableReplicas < desired {
+		return StatePending, fmt.Sprintf("%d of %d replicas ready", deployment.Status.AvailableReplicas, desired)
+	}
+	return StateOK, ""
+}
+
+func statefulSetHealth(statefulSet *appsv1.StatefulSet) (string, string) {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		return StatePending, "Waiting for the rollout to be observed"
+	}
+	desired := replicas(statefulSet.Spec.Replicas)
+	if statefulSet.Status.ReadyReplicas < desired {
+		return StatePending, fmt.Sprintf("%d of %d replicas ready", statefulSet.Status.ReadyReplicas, desired)
+	}
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		partition := int32(0)
+		if statefulSet.Spec.UpdateStrategy.RollingUpdate != nil && statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+			partition = *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+		}
+		if statefulSet.Status.UpdatedReplicas < desired-partition {
+			return StatePending, fmt.Sprintf("%d of %d replicas updated", statefulSet.Status.UpdatedReplicas, desired-partition)
+		}
+	}
+	return StateOK, ""
+}
+
+func daemonSetHealth(daemonSet *appsv1.DaemonSet) (string, string) {
+	if daemonSet.Status.ObservedGeneration < daemonSet.Generation {
+		return StatePending, "Waiting for the rollout to be observed"
+	}
+	desired := daemonSet.Status.DesiredNumberScheduled
+	if daemonSet.Spec.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType && daemonSet.Status.UpdatedNumberScheduled < desired {
+		return StatePending, fmt.Sprintf("%d of %d pods updated", daemonSet.Status.UpdatedNumberScheduled, desired)
+	}
+	if daemonSet.Status.NumberReady < desired {
+		return StatePending, fmt.Sprintf("%d of %d pods ready", daemonSet.Status.NumberReady, desired)
+	}
+	return StateOK, ""
+}
+
+func jobHealth(job *batchv1.Job) (string, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return StateOK, ""
+		case batchv1.JobFailed:
+			return StateFailed, condition.Message
+		}
+	}
+	return StatePending, fmt.Sprintf("%d of %d completions", job.Status.Succeeded, replicas(job.Spec.Completions))
+}
+
+func pvcHealth(pvc *corev1.PersistentVolumeClaim) (string, string) {
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return StateOK, ""
+	case corev1.ClaimLost:
+		return StateFailed, "The volume of the claim is lost"
+	default:
+		return StatePending, "Waiting for the claim to be bound"
+	}
+}
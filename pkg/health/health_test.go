@@ -0,0 +1,248 @@
+package health
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+)
+
+func parseObject(t *testing.T, manifest string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return obj
+}
+
+func TestNewResourceHealth(t *testing.T) {
+	testCases := []struct {
+		name            string
+		manifest        string
+		expectedState   string
+		expectedMessage string
+	}{
+		{
+			"ready deployment",
+			`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: foo, generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2}`,
+			StateOK, "",
+		},
+		{
+			"deployment with unavailable replicas",
+			`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: foo, generation: 2}
+spec: {replicas: 3}
+status: {observedGeneration: 2, replicas: 3, updatedReplicas: 3, availableReplicas: 1}`,
+			StatePending, "1 of 3 replicas ready",
+		},
+		{
+			"deployment exceeding its progress deadline",
+			`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: foo, generation: 2}
+status:
+  observedGeneration: 2
+  conditions:
+  - {type: Progressing, status: "False", reason: ProgressDeadlineExceeded, message: "progress deadline exceeded"}`,
+			StateFailed, "progress deadline exceeded",
+		},
+		{
+			"statefulset with an unobserved generation",
+			`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: foo, generation: 3}
+spec: {replicas: 1}
+status: {observedGeneration: 2, readyReplicas: 1}`,
+			StatePending, "Waiting for the rollout to be observed",
+		},
+		{
+			"complete job",
+			`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: foo}
+status:
+  succeeded: 1
+  conditions:
+  - {type: Complete, status: "True"}`,
+			StateOK, "",
+		},
+		{
+			"failed job",
+			`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: foo}
+status:
+  failed: 6
+  conditions:
+  - {type: Failed, status: "True", message: "Job has reached the specified backoff limit"}`,
+			StateFailed, "Job has reached the specified backoff limit",
+		},
+		{
+			"running job",
+			`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: foo}
+spec: {completions: 3}
+status: {succeeded: 1}`,
+			StatePending, "1 of 3 completions",
+		},
+		{
+			"bound claim",
+			`
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: foo}
+status: {phase: Bound}`,
+			StateOK, "",
+		},
+		{
+			"pending claim",
+			`
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: foo}
+status: {phase: Pending}`,
+			StatePending, "Waiting for the claim to be bound",
+		},
+		{
+			"config map",
+			`
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: foo}`,
+			StateOK, "",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			resourceHealth := NewResourceHealth(parseObject(t, tc.manifest))
+			if resourceHealth.State != tc.expectedState {
+				t.Errorf("got: %q, want: %q", resourceHealth.State, tc.expectedState)
+			}
+			if resourceHealth.Message != tc.expectedMessage {
+				t.Errorf("got: %q, want: %q", resourceHealth.Message, tc.expectedMessage)
+			}
+		})
+	}
+}
+
+func TestNewResourceHealthWithRequirements(t *testing.T) {
+	const database = `
+apiVersion: example.com/v1
+kind: Database
+metadata: {name: foo}
+status: {phase: Running, replicas: 2}`
+
+	successValues := func(values ...string) []runtime.RawExtension {
+		rawValues := []runtime.RawExtension{}
+		for _, value := range values {
+			rawValues = append(rawValues, runtime.RawExtension{Raw: []byte(value)})
+		}
+		return rawValues
+	}
+
+	testCases := []struct {
+		name            string
+		requirements    []hubv1.Resource
+		expectedState   string
+		expectedMessage string
+	}{
+		{
+			"success value",
+			[]hubv1.Resource{{FieldPath: ".status.phase", SuccessValues: successValues(`"Creating"`, `"Running"`)}},
+			StateOK, "",
+		},
+		{
+			"field path in braces",
+			[]hubv1.Resource{{FieldPath: "{.status.phase}", SuccessValues: successValues(`"Running"`)}},
+			StateOK, "",
+		},
+		{
+			"numeric success value",
+			[]hubv1.Resource{{FieldPath: ".status.replicas", SuccessValues: successValues(`2.0`)}},
+			StateOK, "",
+		},
+		{
+			"set field without success values",
+			[]hubv1.Resource{{FieldPath: ".status.phase"}},
+			StateOK, "",
+		},
+		{
+			"other value",
+			[]hubv1.Resource{{FieldPath: ".status.phase", SuccessValues: successValues(`"Ready"`)}},
+			StatePending, `Field .status.phase is "Running"`,
+		},
+		{
+			"missing field",
+			[]hubv1.Resource{{FieldPath: ".status.endpoint"}},
+			StatePending, "Field .status.endpoint is not set",
+		},
+		{
+			"worst requirement",
+			[]hubv1.Resource{
+				{FieldPath: ".status.phase", SuccessValues: successValues(`"Running"`)},
+				{FieldPath: ".status.replicas", SuccessValues: successValues(`3`)},
+			},
+			StatePending, "Field .status.replicas is 2",
+		},
+		{
+			"invalid field path",
+			[]hubv1.Resource{{FieldPath: ".status[", SuccessValues: successValues(`"Running"`)}},
+			StateUnknown, "",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			resourceHealth := NewResourceHealth(parseObject(t, database), tc.requirements...)
+			if resourceHealth.State != tc.expectedState {
+				t.Errorf("got: %q, want: %q", resourceHealth.State, tc.expectedState)
+			}
+			if tc.expectedState != StateUnknown && resourceHealth.Message != tc.expectedMessage {
+				t.Errorf("got: %q, want: %q", resourceHealth.Message, tc.expectedMessage)
+			}
+		})
+	}
+}
+
+func TestNewReleaseHealth(t *testing.T) {
+	testCases := []struct {
+		name          string
+		states        []string
+		expectedState string
+	}{
+		{"no resources", []string{}, StateOK},
+		{"ok", []string{StateOK, StateOK}, StateOK},
+		{"unknown", []string{StateOK, StateUnknown}, StateUnknown},
+		{"pending", []string{StateUnknown, StatePending, StateOK}, StatePending},
+		{"failed", []string{StatePending, StateFailed, StateOK}, StateFailed},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			resources := []ResourceHealth{}
+			for _, state := range tc.states {
+				resources = append(resources, ResourceHealth{State: state})
+			}
+			if got := NewReleaseHealth(resources).State; got != tc.expectedState {
+				t.Errorf("got: %q, want: %q", got, tc.expectedState)
+			}
+		})
+	}
+}
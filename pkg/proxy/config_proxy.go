@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -21,7 +23,7 @@ type KubeconfigValidation struct {
 	Kubeconfig []byte
 }
 
-func (kv KubeconfigValidation) getClientSet(namespace string) (*kubernetes.Clientset, error) {
+func (kv KubeconfigValidation) getClientSet(namespace string) (kubernetes.Interface, error) {
 	restClientGetter := NewRemoteRESTClientGetter(kv.Kubeconfig, namespace)
 
 	kc := kube.New(restClientGetter)
@@ -53,7 +55,7 @@ func (kv KubeconfigValidation) initActionConfig(namespace string) *action.Config
 	return actionConfig
 }
 
-func getStorageType(clientset *kubernetes.Clientset, namespace string) *storage.Storage {
+func getStorageType(clientset kubernetes.Interface, namespace string) *storage.Storage {
 	var store *storage.Storage
 	switch os.Getenv("HELM_DRIVER") {
 	case "secret", "secrets", "":
@@ -103,7 +105,7 @@ func (tv TokenValidation) initActionConfig(namespace string) *action.Configurati
 	return actionConfig
 }
 
-func (tv TokenValidation) getClientSet(namespace string) (*kubernetes.Clientset, error) {
+func (tv TokenValidation) getClientSet(namespace string) (kubernetes.Interface, error) {
 	restClientGetter := NewKRESTClientGetter(tv.Token, namespace)
 
 	kc := kube.New(restClientGetter)
@@ -112,8 +114,20 @@ func (tv TokenValidation) getClientSet(namespace string) (*kubernetes.Clientset,
 	return kc.Factory.KubernetesClientSet()
 }
 
+// clusterKey identifies the cluster accessed with the kubeconfig
+func (kv KubeconfigValidation) clusterKey() string {
+	sum := sha256.Sum256(kv.Kubeconfig)
+	return hex.EncodeToString(sum[:])
+}
+
+// clusterKey identifies the cluster accessed with the token, all tokens access the cluster of the backend
+func (tv TokenValidation) clusterKey() string {
+	return ""
+}
+
 // ValidationObject can be used to initiate an helm action configuration or a kubernetes client set.
 type ValidationObject interface {
 	initActionConfig(string) *action.Configuration
-	getClientSet(namespace string) (*kubernetes.Clientset, error)
+	getClientSet(namespace string) (kubernetes.Interface, error)
+	clusterKey() string
 }
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	k8sYaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Changes of resources between two manifests
+	ResourceAdded   = "added"
+	ResourceRemoved = "removed"
+	ResourceChanged = "changed"
+
+	diffContextLines = 3
+)
+
+// ResourceDiff is the change of a resource between the manifest of a release and a new manifest
+type ResourceDiff struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Change is either "added", "removed" or "changed"
+	Change string `json:"change"`
+	// Diff is the unified diff of the YAML of the resource
+	Diff string `json:"diff"`
+}
+
+// manifestResource is a resource of a manifest with its YAML as rendered by helm
+type manifestResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"metadata"`
+	yaml string
+}
+
+// key identifies a resource independently of the version of its API
+func (r *manifestResource) key() string {
+	return r.Kind + "/" + r.Metadata.Namespace + "/" + r.Metadata.Name
+}
+
+// splitManifest returns the resources of a manifest in the order of the manifest
+func splitManifest(manifest string) ([]*manifestResource, error) {
+	decoder := k8sYaml.NewYAMLReader(bufio.NewReader(strings.NewReader(manifest)))
+	resources := []*manifestResource{}
+	for {
+		document, err := decoder.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "Could not read yaml manifest")
+		}
+
+		resource := &manifestResource{}
+		if err := yaml.Unmarshal(document, resource); err != nil {
+			return nil, errors.Wrap(err, "Could not parse yaml manifest")
+		}
+		// Skip documents which only contain comments
+		if resource.Kind == "" {
+			continue
+		}
+		resource.yaml = strings.TrimLeft(string(bytes.TrimPrefix(document, []byte("---"))), "\n")
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// DiffManifests returns the unified diffs of the resources which differ between the current and
+// the target manifest. Changed and added resources are in the order of the target manifest,
+// followed by the removed resources.
+func DiffManifests(current, target, currentLabel, targetLabel string) ([]ResourceDiff, error) {
+	currentResources, err := splitManifest(current)
+	if err != nil {
+		return nil, err
+	}
+	targetResources, err := splitManifest(target)
+	if err != nil {
+		return nil, err
+	}
+
+	currentByKey := map[string]*manifestResource{}
+	for _, resource := range currentResources {
+		currentByKey[resource.key()] = resource
+	}
+
+	diffs := []ResourceDiff{}
+	for _, targetResource := range targetResources {
+		currentResource, exists := currentByKey[targetResource.key()]
+		delete(currentByKey, targetResource.key())
+
+		change := ResourceAdded
+		currentYaml := ""
+		if exists {
+			if currentResource.yaml == targetResource.yaml {
+				continue
+			}
+			change = ResourceChanged
+			currentYaml = currentResource.yaml
+		}
+		diff, err := unifiedDiff(currentYaml, targetResource.yaml, currentLabel, targetLabel)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, newResourceDiff(targetResource, change, diff))
+	}
+
+	for _, currentResource := range currentResources {
+		if _, removed := currentByKey[currentResource.key()]; !removed {
+			continue
+		}
+		diff, err := unifiedDiff(currentResource.yaml, "", currentLabel, targetLabel)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, newResourceDiff(currentResource, ResourceRemoved, diff))
+	}
+	return diffs, nil
+}
+
+func newResourceDiff(resource *manifestResource, change, diff string) ResourceDiff {
+	return ResourceDiff{
+		APIVersion: resource.APIVersion,
+		Kind:       resource.Kind,
+		Namespace:  resource.Metadata.Namespace,
+		Name:       resource.Metadata.Name,
+		Change:     change,
+		Diff:       diff,
+	}
+}
+
+func unifiedDiff(a, b, fromFile, toFile string) (string, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  diffContextLines,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Could not diff manifests")
+	}
+	return diff, nil
+}
+
+// splitLines splits a text into lines, an empty text has no lines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return difflib.SplitLines(strings.TrimSuffix(s, "\n"))
+}
+
+// revisionLabel is the label of the manifest of a revision in diffs
+func revisionLabel(revision int) string {
+	return fmt.Sprintf("revision %d", revision)
+}
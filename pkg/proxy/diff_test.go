@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+const currentManifest = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo-config
+data:
+  replicas: "1"
+---
+# Source: foo/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: foo-secret
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+spec:
+  type: ClusterIP
+`
+
+const targetManifest = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo-config
+data:
+  replicas: "2"
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+spec:
+  type: ClusterIP
+---
+# Source: foo/templates/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: foo
+`
+
+func TestDiffManifests(t *testing.T) {
+	diffs, err := DiffManifests(currentManifest, targetManifest, revisionLabel(1), "preview")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expected := []ResourceDiff{
+		{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Name:       "foo-config",
+			Change:     ResourceChanged,
+			Diff: `--- revision 1
++++ preview
+@@ -4,4 +4,4 @@
+ metadata:
+   name: foo-config
+ data:
+-  replicas: "1"
++  replicas: "2"
+`,
+		},
+		{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Name:       "foo",
+			Change:     ResourceAdded,
+			Diff: `--- revision 1
++++ preview
+@@ -0,0 +1,5 @@
++# Source: foo/templates/serviceaccount.yaml
++apiVersion: v1
++kind: ServiceAccount
++metadata:
++  name: foo
+`,
+		},
+		{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Name:       "foo-secret",
+			Change:     ResourceRemoved,
+			Diff: `--- revision 1
++++ preview
+@@ -1,5 +0,0 @@
+-# Source: foo/templates/secret.yaml
+-apiVersion: v1
+-kind: Secret
+-metadata:
+-  name: foo-secret
+`,
+		},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("got: %+v, want: %+v", diffs, expected)
+	}
+}
+
+func TestDiffManifestsWithoutChanges(t *testing.T) {
+	diffs, err := DiffManifests(currentManifest, currentManifest, revisionLabel(1), revisionLabel(2))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("got: %+v, want: no diffs", diffs)
+	}
+}
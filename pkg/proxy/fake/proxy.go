@@ -20,15 +20,20 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/release"
 
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+	"github.com/gardener/potter-hub/pkg/health"
 	"github.com/gardener/potter-hub/pkg/proxy"
 )
 
 type Proxy struct {
-	Releases []release.Release
+	Releases   []release.Release
+	Operations []proxy.Operation
+	TestRuns   []proxy.TestRun
 }
 
 func (f *Proxy) GetReleaseStatus(ctx context.Context, namespace, relName string, vo proxy.ValidationObject) (release.Status, error) {
@@ -43,6 +48,96 @@ func (f *Proxy) ResolveManifestFromRelease(ctx context.Context, namespace, relea
 	return "", nil
 }
 
+// PreviewRelease returns a manifest of the patches of the options, so that checks of the patched
+// resources can be tested
+func (f *Proxy) PreviewRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options proxy.ReleaseOptions, vo proxy.ValidationObject) (*proxy.ReleasePreview, error) {
+	patches := []string{}
+	for _, patch := range options.Patches {
+		patches = append(patches, patch.Patch)
+	}
+	manifest := strings.Join(patches, "\n---\n")
+	for _, r := range f.Releases {
+		if r.Name == name {
+			return &proxy.ReleasePreview{Action: "upgrade", Manifest: manifest, CurrentRevision: r.Version, Diff: []proxy.ResourceDiff{}}, nil
+		}
+	}
+	return &proxy.ReleasePreview{Action: "install", Manifest: manifest}, nil
+}
+
+func (f *Proxy) GetReleaseHistory(ctx context.Context, name, namespace string, vo proxy.ValidationObject) ([]proxy.ReleaseRevision, error) {
+	history := []proxy.ReleaseRevision{}
+	for _, r := range f.Releases {
+		if r.Name == name {
+			history = append(history, proxy.ReleaseRevision{Revision: r.Version})
+		}
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("release %s not found", name)
+	}
+	return history, nil
+}
+
+func (f *Proxy) GetReleaseHealth(ctx context.Context, rel *release.Release, requirements hubv1.ReadyRequirements, vo proxy.ValidationObject) (*health.ReleaseHealth, error) {
+	return health.NewReleaseHealth([]health.ResourceHealth{}), nil
+}
+
+func (f *Proxy) DiffReleaseRevisions(ctx context.Context, name, namespace string, from, to int, vo proxy.ValidationObject) (*proxy.RevisionDiff, error) {
+	for _, r := range f.Releases {
+		if r.Name == name {
+			return &proxy.RevisionDiff{From: from, To: to, Manifest: []proxy.ResourceDiff{}}, nil
+		}
+	}
+	return nil, fmt.Errorf("release %s not found", name)
+}
+
+// StartOperation runs the operation synchronously so that its result can be checked
+func (f *Proxy) StartOperation(ctx context.Context, operationType, name, namespace string, vo proxy.ValidationObject, run proxy.OperationFunc) (*proxy.Operation, error) {
+	operation := proxy.Operation{
+		ID:          fmt.Sprintf("%s.%d", namespace, len(f.Operations)),
+		Type:        operationType,
+		Namespace:   namespace,
+		ReleaseName: name,
+		Phase:       proxy.OperationSucceeded,
+		Logs:        []string{},
+	}
+	if _, err := run(ctx); err != nil {
+		operation.Phase = proxy.OperationFailed
+		operation.Error = err.Error()
+	}
+	f.Operations = append(f.Operations, operation)
+	return &operation, nil
+}
+
+func (f *Proxy) GetOperation(ctx context.Context, id string, vo proxy.ValidationObject) (*proxy.Operation, error) {
+	for _, o := range f.Operations {
+		if o.ID == id {
+			return &o, nil
+		}
+	}
+	return nil, fmt.Errorf("operation %s not found", id)
+}
+
+func (f *Proxy) RunReleaseTests(ctx context.Context, name, namespace string, timeout time.Duration, vo proxy.ValidationObject) (*proxy.TestRun, error) {
+	for _, r := range f.Releases {
+		if r.Name == name {
+			run := proxy.TestRun{ReleaseName: name, Namespace: namespace, Revision: r.Version, Phase: proxy.TestRunSucceeded, Hooks: []proxy.TestHook{}}
+			f.TestRuns = append(f.TestRuns, run)
+			return &run, nil
+		}
+	}
+	return nil, fmt.Errorf("release %s not found", name)
+}
+
+func (f *Proxy) GetReleaseTestRun(ctx context.Context, name, namespace string, vo proxy.ValidationObject) (*proxy.TestRun, error) {
+	for i := len(f.TestRuns) - 1; i >= 0; i-- {
+		if f.TestRuns[i].ReleaseName == name {
+			run := f.TestRuns[i]
+			return &run, nil
+		}
+	}
+	return nil, fmt.Errorf("test run of release %s not found", name)
+}
+
 func (f *Proxy) ListReleases(ctx context.Context, namespace string, releaseListLimit int, status string, vo proxy.ValidationObject) ([]proxy.AppOverview, error) {
 	res := []proxy.AppOverview{}
 	for _, r := range f.Releases {
@@ -65,7 +160,7 @@ func (f *Proxy) ListReleases(ctx context.Context, namespace string, releaseListL
 	return res, nil
 }
 
-func (f *Proxy) CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, vo proxy.ValidationObject) (*release.Release, error) {
+func (f *Proxy) CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options proxy.ReleaseOptions, vo proxy.ValidationObject) (*release.Release, error) {
 	for _, r := range f.Releases {
 		if r.Name == name {
 			return nil, fmt.Errorf("release already exists")
@@ -79,7 +174,7 @@ func (f *Proxy) CreateRelease(ctx context.Context, name, namespace, values strin
 	return &r, nil
 }
 
-func (f *Proxy) UpdateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, vo proxy.ValidationObject) (*release.Release, error) {
+func (f *Proxy) UpdateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options proxy.ReleaseOptions, vo proxy.ValidationObject) (*release.Release, error) {
 	for _, r := range f.Releases {
 		if r.Name == name {
 			return &r, nil
@@ -106,10 +201,10 @@ func (f *Proxy) GetRelease(ctx context.Context, name, namespace string, vo proxy
 	return nil, fmt.Errorf("release %s not found", name)
 }
 
-func (f *Proxy) DeleteRelease(ctx context.Context, name, namespace string, keepHistory bool, vo proxy.ValidationObject) error {
+func (f *Proxy) DeleteRelease(ctx context.Context, name, namespace string, keepHistory *bool, vo proxy.ValidationObject) error {
 	for i, r := range f.Releases {
 		if r.Name == name {
-			if !keepHistory {
+			if keepHistory == nil || !*keepHistory {
 				f.Releases[i] = f.Releases[len(f.Releases)-1]
 				f.Releases = f.Releases[:len(f.Releases)-1]
 			} else {
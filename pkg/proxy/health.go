@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	batchv1 "k8s.io/api/batch/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+	"github.com/gardener/potter-hub/pkg/health"
+	yamlUtils "github.com/gardener/potter-hub/pkg/yaml"
+)
+
+// GetReleaseHealth fetches the resources of the manifest of a release from the cluster and returns
+// their health. The ready requirements of the hub add resources and conditions to the health of the
+// release. Resources which cannot be fetched are reported with an unknown state.
+func (p *Proxy) GetReleaseHealth(ctx context.Context, rel *release.Release, requirements hubv1.ReadyRequirements, vo ValidationObject) (*health.ReleaseHealth, error) {
+	objects, err := yamlUtils.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	config := vo.initActionConfig(rel.Namespace)
+	restConfig, err := config.RESTClientGetter.ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create kubernetes client config")
+	}
+	mapper, err := config.RESTClientGetter.ToRESTMapper()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create kubernetes rest mapper")
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create kubernetes client")
+	}
+	return releaseHealth(ctx, objects, rel.Namespace, requirements, mapper, client), nil
+}
+
+// releaseHealth returns the health of the objects of a manifest and of the resources of the ready
+// requirements which are not part of the manifest. Namespaced objects without a namespace are in the
+// namespace of the release.
+func releaseHealth(ctx context.Context, objects []*unstructured.Unstructured, namespace string, requirements hubv1.ReadyRequirements, mapper meta.RESTMapper, client dynamic.Interface) *health.ReleaseHealth {
+	resources := []health.ResourceHealth{}
+	jobs := requirements.Jobs
+	pending := requirements.Resources
+	for _, obj := range objects {
+		resourceHealth := health.ResourceHealth{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			State:      health.StateUnknown,
+		}
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			resourceHealth.Message = err.Error()
+			resources = append(resources, resourceHealth)
+			continue
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace && resourceHealth.Namespace == "" {
+			resourceHealth.Namespace = namespace
+		}
+
+		// Required jobs of the manifest are ok if they are complete like all jobs
+		jobs = removeJob(jobs, mapping.Resource, resourceHealth.Namespace, resourceHealth.Name)
+		var matching []hubv1.Resource
+		matching, pending = splitRequirements(pending, mapping.Resource, resourceHealth.Namespace, resourceHealth.Name)
+		resources = append(resources, fetchHealth(ctx, client, mapping.Resource, resourceHealth, matching...))
+	}
+
+	for _, job := range jobs {
+		resources = append(resources, fetchHealth(ctx, client, jobResource, health.ResourceHealth{
+			APIVersion: jobResource.GroupVersion().String(),
+			Kind:       "Job",
+			Namespace:  job.Namespace,
+			Name:       job.Name,
+			State:      health.StateUnknown,
+		}))
+	}
+	for _, requirement := range pending {
+		resourceHealth := health.ResourceHealth{
+			APIVersion: requirement.APIVersion,
+			Namespace:  requirement.Namespace,
+			Name:       requirement.Name,
+			State:      health.StateUnknown,
+		}
+		gv, err := schema.ParseGroupVersion(requirement.APIVersion)
+		if err != nil {
+			resourceHealth.Message = err.Error()
+			resources = append(resources, resourceHealth)
+			continue
+		}
+		resources = append(resources, fetchHealth(ctx, client, gv.WithResource(requirement.Resource), resourceHealth, requirement))
+	}
+	return health.NewReleaseHealth(resources)
+}
+
+var jobResource = batchv1.SchemeGroupVersion.WithResource("jobs")
+
+// removeJob removes the resource from the required jobs if it is one of them
+func removeJob(jobs []hubv1.Job, gvr schema.GroupVersionResource, namespace, name string) []hubv1.Job {
+	if gvr.GroupResource() != jobResource.GroupResource() {
+		return jobs
+	}
+	remaining := []hubv1.Job{}
+	for _, job := range jobs {
+		if job.Namespace != namespace || job.Name != name {
+			remaining = append(remaining, job)
+		}
+	}
+	return remaining
+}
+
+// splitRequirements returns the required resources matching the resource and the remaining ones
+func splitRequirements(requirements []hubv1.Resource, gvr schema.GroupVersionResource, namespace, name string) ([]hubv1.Resource, []hubv1.Resource) {
+	matching, remaining := []hubv1.Resource{}, []hubv1.Resource{}
+	for _, requirement := range requirements {
+		if requirement.APIVersion == gvr.GroupVersion().String() && requirement.Resource == gvr.Resource &&
+			requirement.Namespace == namespace && requirement.Name == name {
+			matching = append(matching, requirement)
+		} else {
+			remaining = append(remaining, requirement)
+		}
+	}
+	return matching, remaining
+}
+
+// fetchHealth fetches a resource and returns its health with the ready requirements on it. Resources
+// which do not exist are failed.
+func fetchHealth(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, resourceHealth health.ResourceHealth, requirements ...hubv1.Resource) health.ResourceHealth {
+	var resource dynamic.ResourceInterface = client.Resource(gvr)
+	if resourceHealth.Namespace != "" {
+		resource = client.Resource(gvr).Namespace(resourceHealth.Namespace)
+	}
+
+	current, err := resource.Get(ctx, resourceHealth.Name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		resourceHealth.State = health.StateFailed
+		resourceHealth.Message = "Resource not found"
+		return resourceHealth
+	}
+	if err != nil {
+		resourceHealth.Message = err.Error()
+		return resourceHealth
+	}
+	return health.NewResourceHealth(current, requirements...)
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+	"github.com/gardener/potter-hub/pkg/health"
+	yamlUtils "github.com/gardener/potter-hub/pkg/yaml"
+)
+
+const healthManifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: foo-data
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo-config
+---
+apiVersion: example.com/v1
+kind: Unknown
+metadata:
+  name: foo
+`
+
+func TestReleaseHealth(t *testing.T) {
+	deploymentGVK := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	pvcGVK := schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(deploymentGVK, meta.RESTScopeNamespace)
+	mapper.Add(pvcGVK, meta.RESTScopeNamespace)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "default"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+		"status":     map[string]interface{}{"replicas": int64(1), "updatedReplicas": int64(1), "availableReplicas": int64(1)},
+	}}
+	pvc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata":   map[string]interface{}{"name": "foo-data", "namespace": "default"},
+		"status":     map[string]interface{}{"phase": "Pending"},
+	}}
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), deployment, pvc)
+
+	objects, err := yamlUtils.ParseObjects(healthManifest)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	releaseHealth := releaseHealth(context.TODO(), objects, "default", hubv1.ReadyRequirements{}, mapper, client)
+
+	expectedStates := []string{health.StateOK, health.StatePending, health.StateFailed, health.StateUnknown}
+	if len(releaseHealth.Resources) != len(expectedStates) {
+		t.Fatalf("got: %d resources, want: %d resources", len(releaseHealth.Resources), len(expectedStates))
+	}
+	for i, expectedState := range expectedStates {
+		if releaseHealth.Resources[i].State != expectedState {
+			t.Errorf("got: %q, want: %q for %s", releaseHealth.Resources[i].State, expectedState, releaseHealth.Resources[i].Kind)
+		}
+		if i < 3 && releaseHealth.Resources[i].Namespace != "default" {
+			t.Errorf("got: %q, want: %q", releaseHealth.Resources[i].Namespace, "default")
+		}
+	}
+	if releaseHealth.State != health.StateFailed {
+		t.Errorf("got: %q, want: %q", releaseHealth.State, health.StateFailed)
+	}
+}
+
+const requirementsManifest = `---
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: foo
+`
+
+func TestReleaseHealthWithReadyRequirements(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"}, meta.RESTScopeNamespace)
+
+	database := func(name, phase string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Database",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+			"status":     map[string]interface{}{"phase": phase},
+		}}
+	}
+	job := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": "foo-migration", "namespace": "default"},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Complete", "status": "True"},
+		}},
+	}}
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), database("foo", "Running"), database("bar", "Creating"), job)
+
+	running := []runtime.RawExtension{{Raw: []byte(`"Running"`)}}
+	requirements := hubv1.ReadyRequirements{
+		Jobs: []hubv1.Job{
+			{Name: "foo-migration", Namespace: "default"},
+			{Name: "foo-backup", Namespace: "default"},
+		},
+		Resources: []hubv1.Resource{
+			{Name: "foo", Namespace: "default", APIVersion: "example.com/v1", Resource: "databases", FieldPath: ".status.phase", SuccessValues: running},
+			{Name: "bar", Namespace: "default", APIVersion: "example.com/v1", Resource: "databases", FieldPath: ".status.phase", SuccessValues: running},
+		},
+	}
+
+	objects, err := yamlUtils.ParseObjects(requirementsManifest)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	releaseHealth := releaseHealth(context.TODO(), objects, "default", requirements, mapper, client)
+
+	expected := []struct {
+		name  string
+		state string
+	}{
+		{name: "foo", state: health.StateOK},
+		{name: "foo-migration", state: health.StateOK},
+		{name: "foo-backup", state: health.StateFailed},
+		{name: "bar", state: health.StatePending},
+	}
+	if len(releaseHealth.Resources) != len(expected) {
+		t.Fatalf("got: %d resources, want: %d resources", len(releaseHealth.Resources), len(expected))
+	}
+	for i, e := range expected {
+		if releaseHealth.Resources[i].Name != e.name || releaseHealth.Resources[i].State != e.state {
+			t.Errorf("got: %s %q, want: %s %q", releaseHealth.Resources[i].Name, releaseHealth.Resources[i].State, e.name, e.state)
+		}
+	}
+	if releaseHealth.State != health.StateFailed {
+		t.Errorf("got: %q, want: %q", releaseHealth.State, health.StateFailed)
+	}
+}
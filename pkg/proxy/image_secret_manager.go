@@ -1,8 +1,10 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/release"
@@ -10,149 +12,422 @@ import (
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
 
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
 	logUtils "github.com/gardener/potter-hub/pkg/log"
 )
 
-// imageSecretManager manages the creation and deletion of image pull secrets for private repositories
-type imageSecretManager struct {
-	k8sClient     kubernetesClientInterface
-	release       *release.Release
-	sapSecretName string
-	sapSecret     *corev1.Secret
-	secretReader  secretConfigurationReader
+const (
+	// RegistryCredentialsLabel marks the docker config secrets of the hub which charts can request as
+	// image pull secrets by the name of the secret
+	RegistryCredentialsLabel = "hub.k8s.sap.com/registry-credentials"
+
+	// imagePullSecretLabel marks the image pull secrets managed by the hub, its value is the name of
+	// the registry credentials
+	imagePullSecretLabel = "hub.k8s.sap.com/image-pull-secret"
+	// imagePullSecretOwnerPrefix prefixes the annotations naming the releases using an image pull secret
+	imagePullSecretOwnerPrefix = "hub.k8s.sap.com/used-by-"
+	// patchedServiceAccountAnnotation marks image pull secrets which were added to the default service account
+	patchedServiceAccountAnnotation = "hub.k8s.sap.com/patched-service-account"
+
+	// imagePullSecretValues is the key of the values with which charts request image pull secrets
+	imagePullSecretValues = "hubsec"
+	// legacyRegistryCredentials are the credentials of charts with the value hubsec.enabled
+	legacyRegistryCredentials = "hubsec"
+	defaultServiceAccount     = "default"
+)
+
+// ImagePullSecretManager copies registry credentials of the hub into the namespaces of the releases
+// which request them with their values:
+//
+//	hubsec:
+//	  enabled: true                      # the credentials named "hubsec"
+//	  registries: [registry-a, registry-b]
+//	  patchDefaultServiceAccount: true   # adds the secrets to the default service account
+//
+// The releases using an image pull secret are tracked with annotations of the secret, which is deleted
+// when no release uses it anymore. Secrets of the same name which are not managed by the hub are never
+// changed. Changed credentials are propagated to the namespaces of recent operations, see
+// Proxy.StartImagePullSecretPropagation.
+type ImagePullSecretManager struct {
+	credentials typedCoreV1.SecretInterface
 }
 
-// NewImageSecrets creates a new instance of an image secret.
-// Please pass an instance of *ValidationObject* for kubeconfig creation and
-// a string *namespace* in which the secret shall be created/updated.
-func newImageSecret(ctx context.Context, rel *release.Release, dockerconfigjson string, vo ValidationObject) *imageSecretManager {
-	log := logUtils.GetLogger(ctx)
+// NewImagePullSecretManager creates an ImagePullSecretManager for the registry credentials in the
+// secrets of the hub labeled with RegistryCredentialsLabel
+func NewImagePullSecretManager(credentials typedCoreV1.SecretInterface) *ImagePullSecretManager {
+	return &ImagePullSecretManager{credentials: credentials}
+}
 
-	imageSecret := new(imageSecretManager)
+// imagePullSecretRequest are the image pull secrets requested by the values of a release
+type imagePullSecretRequest struct {
+	names               []string
+	patchServiceAccount bool
+}
 
-	reader := helmConfigurationReader{}
-	imageSecret.secretReader = &reader
+func requestedImagePullSecrets(values map[string]interface{}) imagePullSecretRequest {
+	request := imagePullSecretRequest{names: []string{}}
+	config, ok := values[imagePullSecretValues].(map[string]interface{})
+	if !ok {
+		return request
+	}
+	names := map[string]bool{}
+	if enabled, ok := config["enabled"].(bool); ok && enabled {
+		names[legacyRegistryCredentials] = true
+	}
+	if registries, ok := config["registries"].([]interface{}); ok {
+		for _, registry := range registries {
+			if name, ok := registry.(string); ok && name != "" {
+				names[name] = true
+			}
+		}
+	}
+	for name := range names {
+		request.names = append(request.names, name)
+	}
+	sort.Strings(request.names)
+	request.patchServiceAccount, _ = config["patchDefaultServiceAccount"].(bool)
+	return request
+}
 
-	clientset, err := vo.getClientSet(rel.Namespace)
-	if err != nil {
-		log.Fatalf("Error creating kubernetes client for namespace %s. Error: %v.", rel.Namespace, err)
-		return nil
+func ownerAnnotation(releaseName string) string {
+	return imagePullSecretOwnerPrefix + releaseName
+}
+
+func hasOwners(secret *corev1.Secret) bool {
+	for key := range secret.Annotations {
+		if strings.HasPrefix(key, imagePullSecretOwnerPrefix) {
+			return true
+		}
 	}
+	return false
+}
 
-	var k8sClient = &kubernetesClient{clientset: clientset}
-	imageSecret.k8sClient = k8sClient
+// isManaged returns whether the secret is an image pull secret created by the hub for releases
+func isManaged(secret *corev1.Secret) bool {
+	_, labeled := secret.Labels[imagePullSecretLabel]
+	return labeled && hasOwners(secret)
+}
 
-	imageSecret.release = rel
+// isLegacy returns whether the secret is a "hubsec" secret created by earlier versions of the hub,
+// which neither labeled the secret nor tracked the releases using it
+func isLegacy(secret *corev1.Secret) bool {
+	_, labeled := secret.Labels[imagePullSecretLabel]
+	return !labeled && secret.Name == legacyRegistryCredentials && secret.Type == corev1.SecretTypeDockerConfigJson
+}
 
-	imageSecret.sapSecretName = "hubsec"
-	// The Secret creation encodes the config automatically and it is part of the helm chart also base64 encoded.
-	// to prevent double encoding, we decode here, and with this write the secret as-is from the env variable (helm value)
-	// into the secret.
-	dockerSecretsString, err := base64.StdEncoding.DecodeString(dockerconfigjson)
+// getCredentials returns the registry credentials with the name
+func (m *ImagePullSecretManager) getCredentials(ctx context.Context, name string) (*corev1.Secret, error) {
+	credentials, err := m.credentials.Get(ctx, name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) || (err == nil && credentials.Labels[RegistryCredentialsLabel] != "true") {
+		return nil, errorUtils.UnprocessableEntity.NewErrorf("Unknown registry credentials %q", name)
+	}
 	if err != nil {
-		log.Println("Error decoding docker config image pull secret")
-		return nil
+		return nil, errors.Wrapf(err, "Could not get registry credentials %s", name)
 	}
-	imageSecret.sapSecret = &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      imageSecret.sapSecretName,
-			Namespace: rel.Namespace,
-		},
-		StringData: map[string]string{
-			".dockerconfigjson": string(dockerSecretsString),
-		},
-		Type: corev1.SecretTypeDockerConfigJson,
+	if credentials.Type != corev1.SecretTypeDockerConfigJson {
+		return nil, errorUtils.UnprocessableEntity.NewErrorf("Registry credentials %q are not of type %s", name, corev1.SecretTypeDockerConfigJson)
 	}
-
-	return imageSecret
+	return credentials, nil
 }
 
-// createOrUpdateImageSecret Ensures (creates/updates) the image pull secret.
-func (i *imageSecretManager) createOrUpdateImageSecret(ctx context.Context) error {
-	log := logUtils.GetLogger(ctx)
-
-	secretEnabled := i.secretReader.isSecretEnabled(ctx, i.release)
-	if !secretEnabled {
-		log.Printf("sap image secret %s is not required for chart %s", i.sapSecretName, i.release.Name)
-		return nil
+// syncRelease ensures that the image pull secrets requested by the values of the release exist in
+// its namespace. If prune is set, the release stops using the secrets it no longer requests. It returns
+// the secrets the release did not use before, also if it fails, so that a failed operation can undo it.
+func (m *ImagePullSecretManager) syncRelease(ctx context.Context, clientset kubernetes.Interface, rel *release.Release, prune bool) ([]string, error) {
+	values, err := EffectiveValues(rel)
+	if err != nil {
+		return nil, err
 	}
-
-	log.Printf("Ensuring sap image secret %s exists in namespace %s", i.sapSecretName, i.release.Namespace)
-
-	_, err := i.k8sClient.createSecret(i.release.Namespace, i.sapSecret)
-	if k8sErrors.IsAlreadyExists(err) {
-		_, err = i.k8sClient.updateSecret(i.release.Namespace, i.sapSecret)
+	request := requestedImagePullSecrets(values)
+	requested := map[string]bool{}
+	acquired := []string{}
+	for _, name := range request.names {
+		credentials, err := m.getCredentials(ctx, name)
+		if err != nil {
+			return acquired, err
+		}
+		added, err := m.acquire(ctx, clientset, rel.Namespace, rel.Name, credentials, request.patchServiceAccount)
+		if added {
+			acquired = append(acquired, name)
+		}
 		if err != nil {
-			return errors.Wrapf(err, "Unable to update sap image secret %s in namespace %s", i.sapSecretName, i.release.Namespace)
+			return acquired, err
+		}
+		requested[name] = true
+	}
+	if !prune {
+		return acquired, nil
+	}
+
+	secrets, err := m.releaseSecrets(ctx, clientset, rel.Namespace, rel.Name)
+	if err != nil {
+		return acquired, err
+	}
+	for _, name := range secrets {
+		if !requested[name] {
+			if err := m.release(ctx, clientset, rel.Namespace, name, rel.Name); err != nil {
+				return acquired, err
+			}
 		}
-	} else if err != nil {
-		return errors.Wrapf(err, "Unable to create/update sap image secret %s in namespace %s", i.sapSecretName, i.release.Namespace)
 	}
+	return acquired, nil
+}
 
-	log.Printf("sap image secret %s successfully installed in %s", i.sapSecretName, i.release.Namespace)
+// releaseSome stops the release using the image pull secrets, e.g. the ones acquired by a failed operation
+func (m *ImagePullSecretManager) releaseSome(ctx context.Context, clientset kubernetes.Interface, namespace, releaseName string, names []string) error {
+	for _, name := range names {
+		if err := m.release(ctx, clientset, namespace, name, releaseName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// releaseAll stops the release using any image pull secrets, e.g. when it is uninstalled
+func (m *ImagePullSecretManager) releaseAll(ctx context.Context, clientset kubernetes.Interface, namespace, releaseName string) error {
+	secrets, err := m.releaseSecrets(ctx, clientset, namespace, releaseName)
+	if err != nil {
+		return err
+	}
+	for _, name := range secrets {
+		if err := m.release(ctx, clientset, namespace, name, releaseName); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// deleteImageSecret Deletes the image pull secret.
-func (i *imageSecretManager) deleteImageSecret(ctx context.Context) error {
+// releaseSecrets returns the names of the image pull secrets used by the release
+func (m *ImagePullSecretManager) releaseSecrets(ctx context.Context, clientset kubernetes.Interface, namespace, releaseName string) ([]string, error) {
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: imagePullSecretLabel})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not list image pull secrets in namespace %s", namespace)
+	}
+	names := []string{}
+	for _, secret := range secrets.Items {
+		if _, ok := secret.Annotations[ownerAnnotation(releaseName)]; ok {
+			names = append(names, secret.Name)
+		}
+	}
+	return names, nil
+}
+
+// acquire creates or updates the image pull secret of the credentials and marks it as used by the release.
+// Existing secrets with the same name which are not managed by the hub are a conflict, except for
+// legacy "hubsec" secrets which are adopted. It returns whether the release did not use the secret
+// before. Adopted secrets are not reported, releasing them would delete the secret of other releases.
+func (m *ImagePullSecretManager) acquire(ctx context.Context, clientset kubernetes.Interface, namespace, releaseName string, credentials *corev1.Secret, patchServiceAccount bool) (bool, error) {
 	log := logUtils.GetLogger(ctx)
+	secrets := clientset.CoreV1().Secrets(namespace)
 
-	log.Printf("Deleting sap image secret %s from namespace %s", i.sapSecretName, i.release.Namespace)
+	added := false
+	err := retry.OnError(retry.DefaultRetry, isConcurrentChange, func() error {
+		added = false
+		secret, err := secrets.Get(ctx, credentials.Name, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      credentials.Name,
+					Namespace: namespace,
+				},
+				Type: corev1.SecretTypeDockerConfigJson,
+			}
+			m.own(secret, releaseName, credentials, patchServiceAccount)
+			if _, err = secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			added = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isManaged(secret) && !isLegacy(secret) {
+			return errorUtils.Conflict.NewErrorf("Secret %q in namespace %q is not managed by the hub", secret.Name, namespace)
+		}
+		_, used := secret.Annotations[ownerAnnotation(releaseName)]
+		adopted := isLegacy(secret)
+		m.own(secret, releaseName, credentials, patchServiceAccount)
+		if _, err = secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		added = !used && !adopted
+		return nil
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "Unable to create/update image pull secret %s in namespace %s", credentials.Name, namespace)
+	}
 
-	err := i.k8sClient.deleteSecret(i.release.Namespace, i.sapSecretName)
-	if err != nil && !k8sErrors.IsNotFound(err) {
-		return errors.Wrapf(err, "Unable to delete sap image secret %s in namespace %s",
-			i.sapSecretName, i.release.Namespace)
+	if patchServiceAccount {
+		if err := addServiceAccountImagePullSecret(ctx, clientset.CoreV1().ServiceAccounts(namespace), credentials.Name); err != nil {
+			return added, err
+		}
 	}
-	return nil
+	log.Infof("Image pull secret %s is used by release %s in namespace %s", credentials.Name, releaseName, namespace)
+	return added, nil
 }
 
-type secretConfigurationReader interface {
-	isSecretEnabled(ctx context.Context, release *release.Release) bool
+func (m *ImagePullSecretManager) own(secret *corev1.Secret, releaseName string, credentials *corev1.Secret, patchServiceAccount bool) {
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Labels[imagePullSecretLabel] = credentials.Name
+	secret.Annotations[ownerAnnotation(releaseName)] = "true"
+	if patchServiceAccount {
+		secret.Annotations[patchedServiceAccountAnnotation] = "true"
+	}
+	secret.Data = credentials.Data
 }
 
-type helmConfigurationReader struct{}
-
-func (helmConfigurationReader) isSecretEnabled(ctx context.Context, rel *release.Release) bool {
-	// We have to check the secret configuration in both, the chart values and the override values
+// release marks the image pull secret as no longer used by the release and deletes it if no other
+// release uses it
+func (m *ImagePullSecretManager) release(ctx context.Context, clientset kubernetes.Interface, namespace, name, releaseName string) error {
 	log := logUtils.GetLogger(ctx)
+	secrets := clientset.CoreV1().Secrets(namespace)
 
-	log.Printf("chart config for 'hubsec': %s", rel.Chart.Values["hubsec"])
-	if sapSecret, ok := rel.Chart.Values["hubsec"]; ok {
-		sapSecretConfiguration := sapSecret.(map[string]interface{})
-		// Comparison to with boolean needed to parse to bool type
-		return true == sapSecretConfiguration["enabled"]
+	deleted := false
+	patchedServiceAccount := false
+	err := retry.OnError(retry.DefaultRetry, isConcurrentChange, func() error {
+		secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		delete(secret.Annotations, ownerAnnotation(releaseName))
+		if hasOwners(secret) {
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+			return err
+		}
+		err = secrets.Delete(ctx, name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &secret.ResourceVersion},
+		})
+		if err == nil || k8sErrors.IsNotFound(err) {
+			deleted = true
+			_, patchedServiceAccount = secret.Annotations[patchedServiceAccountAnnotation]
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Unable to release image pull secret %s in namespace %s", name, namespace)
 	}
 
-	log.Printf("override config for 'hubsec': %s", rel.Config["hubsec"])
-	if sapSecret, ok := rel.Config["hubsec"]; ok {
-		sapSecretConfiguration := sapSecret.(map[string]interface{})
-		// Comparison to with boolean needed to parse to bool type
-		return true == sapSecretConfiguration["enabled"]
+	if deleted {
+		log.Infof("Deleted image pull secret %s in namespace %s", name, namespace)
+		if patchedServiceAccount {
+			return removeServiceAccountImagePullSecret(ctx, clientset.CoreV1().ServiceAccounts(namespace), name)
+		}
 	}
-	return false
+	return nil
 }
 
-type kubernetesClientInterface interface {
-	createSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error)
-	updateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error)
-	deleteSecret(namespace string, name string) error
+// isConcurrentChange returns whether an update failed because the object was changed concurrently
+func isConcurrentChange(err error) bool {
+	return k8sErrors.IsConflict(err) || k8sErrors.IsAlreadyExists(err)
 }
 
-type kubernetesClient struct {
-	clientset *kubernetes.Clientset
+func addServiceAccountImagePullSecret(ctx context.Context, serviceAccounts typedCoreV1.ServiceAccountInterface, name string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		serviceAccount, err := serviceAccounts.Get(ctx, defaultServiceAccount, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, ref := range serviceAccount.ImagePullSecrets {
+			if ref.Name == name {
+				return nil
+			}
+		}
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		_, err = serviceAccounts.Update(ctx, serviceAccount, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "Unable to add image pull secret %s to the default service account", name)
 }
 
-func (k *kubernetesClient) createSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
-	return k.clientset.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+func removeServiceAccountImagePullSecret(ctx context.Context, serviceAccounts typedCoreV1.ServiceAccountInterface, name string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		serviceAccount, err := serviceAccounts.Get(ctx, defaultServiceAccount, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		refs := []corev1.LocalObjectReference{}
+		for _, ref := range serviceAccount.ImagePullSecrets {
+			if ref.Name != name {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == len(serviceAccount.ImagePullSecrets) {
+			return nil
+		}
+		serviceAccount.ImagePullSecrets = refs
+		_, err = serviceAccounts.Update(ctx, serviceAccount, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "Unable to remove image pull secret %s from the default service account", name)
 }
 
-func (k *kubernetesClient) updateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
-	return k.clientset.CoreV1().Secrets(namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+// Propagate updates the image pull secrets in the namespace whose registry credentials changed.
+// Secrets of deleted credentials are kept, so that running workloads can still pull their images.
+func (m *ImagePullSecretManager) Propagate(ctx context.Context, clientset kubernetes.Interface, namespace string) error {
+	log := logUtils.GetLogger(ctx)
+
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: imagePullSecretLabel})
+	if err != nil {
+		return errors.Wrapf(err, "Could not list image pull secrets in namespace %s", namespace)
+	}
+	if len(secrets.Items) == 0 {
+		return nil
+	}
+	credentialList, err := m.credentials.List(ctx, metav1.ListOptions{LabelSelector: RegistryCredentialsLabel + "=true"})
+	if err != nil {
+		return errors.Wrap(err, "Could not list registry credentials")
+	}
+	credentials := map[string]*corev1.Secret{}
+	for i := range credentialList.Items {
+		credentials[credentialList.Items[i].Name] = &credentialList.Items[i]
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !isManaged(secret) {
+			continue
+		}
+		source, ok := credentials[secret.Labels[imagePullSecretLabel]]
+		if !ok {
+			log.Warnf("Registry credentials %s of image pull secret %s in namespace %s not found", secret.Labels[imagePullSecretLabel], secret.Name, namespace)
+			continue
+		}
+		if equalSecretData(secret.Data, source.Data) {
+			continue
+		}
+		secret.Data = source.Data
+		if _, err := clientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "Could not update image pull secret %s in namespace %s", secret.Name, namespace)
+		}
+		log.Infof("Updated image pull secret %s in namespace %s", secret.Name, namespace)
+	}
+	return nil
 }
 
-func (k *kubernetesClient) deleteSecret(namespace, name string) error {
-	return k.clientset.CoreV1().Secrets(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+func equalSecretData(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
 }
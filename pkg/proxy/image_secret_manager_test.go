@@ -2,133 +2,309 @@ package proxy
 
 import (
 	"context"
+	"reflect"
 	"testing"
+	"time"
 
-	"github.com/sirupsen/logrus"
-	"github.com/sirupsen/logrus/hooks/test"
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 	"helm.sh/helm/v3/pkg/release"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
 
-	logUtils "github.com/gardener/potter-hub/pkg/log"
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
 )
 
-type MockedKubernetesClient struct {
-	mock.Mock
+func registryCredentials(name, config string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "hub",
+			Labels:    map[string]string{RegistryCredentialsLabel: "true"},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+	}
+}
+
+func newTestImagePullSecretManager() *ImagePullSecretManager {
+	hub := fake.NewSimpleClientset(
+		registryCredentials("hubsec", `{"auths":{"hub.example.com":{}}}`),
+		registryCredentials("registry-a", `{"auths":{"a.example.com":{}}}`),
+		registryCredentials("registry-c", `{"auths":{"c.example.com":{}}}`),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "hub"}},
+	)
+	return NewImagePullSecretManager(hub.CoreV1().Secrets("hub"))
+}
 
-	createSuccessful bool
+func releaseRequesting(name string, hubsec map[string]interface{}) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: "default",
+		Config:    map[string]interface{}{"hubsec": hubsec},
+	}
+}
 
-	createCalled bool
-	updateCalled bool
-	deleteCalled bool
+func TestRequestedImagePullSecrets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   map[string]interface{}
+		expected imagePullSecretRequest
+	}{
+		{"no values", map[string]interface{}{}, imagePullSecretRequest{names: []string{}}},
+		{"disabled", map[string]interface{}{"hubsec": map[string]interface{}{"enabled": false}}, imagePullSecretRequest{names: []string{}}},
+		{"legacy", map[string]interface{}{"hubsec": map[string]interface{}{"enabled": true}}, imagePullSecretRequest{names: []string{"hubsec"}}},
+		{
+			"registries",
+			map[string]interface{}{"hubsec": map[string]interface{}{
+				"enabled":                    true,
+				"registries":                 []interface{}{"registry-b", "registry-a", "hubsec"},
+				"patchDefaultServiceAccount": true,
+			}},
+			imagePullSecretRequest{names: []string{"hubsec", "registry-a", "registry-b"}, patchServiceAccount: true},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := requestedImagePullSecrets(tc.values)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got: %+v, want: %+v", got, tc.expected)
+			}
+		})
+	}
 }
 
-func (m *MockedKubernetesClient) createSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
-	m.createCalled = true
+func TestImagePullSecretOwnership(t *testing.T) {
+	ctx := operationTestContext()
+	manager := newTestImagePullSecretManager()
+	clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}})
+	secrets := clientset.CoreV1().Secrets("default")
 
-	if m.createSuccessful {
-		return &corev1.Secret{
-			TypeMeta:   metav1.TypeMeta{},
-			ObjectMeta: metav1.ObjectMeta{},
-			Data:       nil,
-			StringData: nil,
-			Type:       "",
-		}, nil
+	foo := releaseRequesting("foo", map[string]interface{}{"enabled": true, "registries": []interface{}{"registry-a"}, "patchDefaultServiceAccount": true})
+	bar := releaseRequesting("bar", map[string]interface{}{"registries": []interface{}{"registry-a"}})
+	for _, rel := range []*release.Release{foo, bar} {
+		if _, err := manager.syncRelease(ctx, clientset, rel, true); err != nil {
+			t.Fatalf("%+v", err)
+		}
 	}
 
-	return nil, errors.NewAlreadyExists(schema.GroupResource{
-		Group:    "mock",
-		Resource: "mock",
-	}, "mock")
-}
+	secret, err := secrets.Get(context.TODO(), "registry-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson || string(secret.Data[corev1.DockerConfigJsonKey]) != `{"auths":{"a.example.com":{}}}` {
+		t.Errorf("got: %s %q, want: docker config of registry-a", secret.Type, secret.Data[corev1.DockerConfigJsonKey])
+	}
+	if secret.Annotations[ownerAnnotation("foo")] == "" || secret.Annotations[ownerAnnotation("bar")] == "" {
+		t.Errorf("got: %v, want: annotations of both releases", secret.Annotations)
+	}
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts("default").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(serviceAccount.ImagePullSecrets) != 2 {
+		t.Errorf("got: %v, want: hubsec and registry-a", serviceAccount.ImagePullSecrets)
+	}
 
-func (m *MockedKubernetesClient) updateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
-	m.updateCalled = true
-	return &corev1.Secret{
-		TypeMeta:   metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{},
-		Data:       nil,
-		StringData: nil,
-		Type:       "",
-	}, nil
-}
+	// foo stops using hubsec, the secret is deleted since no release uses it
+	foo.Config = map[string]interface{}{"hubsec": map[string]interface{}{"registries": []interface{}{"registry-a"}}}
+	if _, err := manager.syncRelease(ctx, clientset, foo, true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := secrets.Get(context.TODO(), "hubsec", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: hubsec, want: deleted secret")
+	}
 
-func (m *MockedKubernetesClient) deleteSecret(namespace, name string) error {
-	m.deleteCalled = true
-	return nil
-}
+	// registry-a is kept as long as bar uses it
+	if err := manager.releaseAll(ctx, clientset, "default", "foo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := secrets.Get(context.TODO(), "registry-a", metav1.GetOptions{}); err != nil {
+		t.Errorf("got: %v, want: registry-a used by bar", err)
+	}
+	if err := manager.releaseAll(ctx, clientset, "default", "bar"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := secrets.Get(context.TODO(), "registry-a", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: registry-a, want: deleted secret")
+	}
 
-type MockedSecretConfigurationReader struct {
-	mock.Mock
+	serviceAccount, err = clientset.CoreV1().ServiceAccounts("default").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(serviceAccount.ImagePullSecrets) != 0 {
+		t.Errorf("got: %v, want: no image pull secrets", serviceAccount.ImagePullSecrets)
+	}
 }
 
-func (*MockedSecretConfigurationReader) isSecretEnabled(ctx context.Context, rel *release.Release) bool {
-	return true
-}
+// A failed install of a release with the name of an existing release only undoes the secrets it acquired
+func TestReleaseAcquiredImagePullSecrets(t *testing.T) {
+	ctx := operationTestContext()
+	manager := newTestImagePullSecretManager()
+	clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}})
+	secrets := clientset.CoreV1().Secrets("default")
 
-func generateTestData() (*MockedKubernetesClient, imageSecretManager) {
-	secretReader := new(MockedSecretConfigurationReader)
-	k8sMock := new(MockedKubernetesClient)
-	expectedSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "testrelease",
-			Namespace: "testnamespace",
-		},
+	existing := releaseRequesting("foo", map[string]interface{}{"registries": []interface{}{"registry-a"}, "patchDefaultServiceAccount": true})
+	acquired, err := manager.syncRelease(ctx, clientset, existing, true)
+	if err != nil {
+		t.Fatalf("%+v", err)
 	}
-	secretManager := imageSecretManager{
-		k8sClient: k8sMock,
-		release: &release.Release{
-			Name:      "testrelease",
-			Namespace: "testnamespace",
-		},
-		sapSecretName: "testsecret",
-		sapSecret:     expectedSecret,
-		secretReader:  secretReader,
+	if !reflect.DeepEqual(acquired, []string{"registry-a"}) {
+		t.Errorf("got: %v, want: [registry-a]", acquired)
+	}
+
+	failed := releaseRequesting("foo", map[string]interface{}{"enabled": true, "registries": []interface{}{"registry-a"}, "patchDefaultServiceAccount": true})
+	acquired, err = manager.syncRelease(ctx, clientset, failed, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(acquired, []string{"hubsec"}) {
+		t.Errorf("got: %v, want: [hubsec]", acquired)
+	}
+	if err := manager.releaseSome(ctx, clientset, "default", "foo", acquired); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, err := secrets.Get(context.TODO(), "hubsec", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: hubsec, want: deleted secret")
+	}
+	secret, err := secrets.Get(context.TODO(), "registry-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if secret.Annotations[ownerAnnotation("foo")] == "" {
+		t.Errorf("got: %v, want: registry-a used by foo", secret.Annotations)
+	}
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts("default").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(serviceAccount.ImagePullSecrets) != 1 || serviceAccount.ImagePullSecrets[0].Name != "registry-a" {
+		t.Errorf("got: %v, want: registry-a", serviceAccount.ImagePullSecrets)
 	}
-	return k8sMock, secretManager
 }
 
-func Test_CreateSecret_Successfully(t *testing.T) {
-	k8sMock, secretManager := generateTestData()
-	k8sMock.createSuccessful = true
-	nullLogger, _ := test.NewNullLogger()
-	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+func TestImagePullSecretErrors(t *testing.T) {
+	ctx := operationTestContext()
+	manager := newTestImagePullSecretManager()
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry-a", Namespace: "default"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry-c", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"own.example.com":{}}}`)},
+		},
+	)
 
-	err := secretManager.createOrUpdateImageSecret(ctx)
+	testCases := []struct {
+		name     string
+		registry string
+		expected errorUtils.HTTPErrorType
+	}{
+		{"unknown credentials", "registry-b", errorUtils.UnprocessableEntity},
+		{"secret without registry credentials label", "internal", errorUtils.UnprocessableEntity},
+		{"secret which is not an image pull secret", "registry-a", errorUtils.Conflict},
+		{"image pull secret which is not managed by the hub", "registry-c", errorUtils.Conflict},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rel := releaseRequesting("foo", map[string]interface{}{"registries": []interface{}{tc.registry}})
+			_, err := manager.syncRelease(ctx, clientset, rel, true)
+			if err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+			if got, _ := errorUtils.GetHTTPErrorType(err); got != tc.expected {
+				t.Errorf("got: %d, want: %d", got, tc.expected)
+			}
+		})
+	}
 
-	assert.True(t, k8sMock.createCalled, "Create should have been called")
-	assert.False(t, k8sMock.updateCalled, "Update should not have been called called")
-	assert.False(t, k8sMock.deleteCalled, "Delete should not have been called called")
-	assert.Nil(t, err, "Secret should have been created without error")
+	// Secrets which are not managed by the hub are left untouched
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), "registry-c", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(secret.Annotations) != 0 || string(secret.Data[corev1.DockerConfigJsonKey]) != `{"auths":{"own.example.com":{}}}` {
+		t.Errorf("got: %v %q, want: unchanged secret", secret.Annotations, secret.Data[corev1.DockerConfigJsonKey])
+	}
 }
 
-func Test_UpdateSecret_Successfully(t *testing.T) {
-	k8sMock, secretManager := generateTestData()
-	k8sMock.createSuccessful = false
-	nullLogger, _ := test.NewNullLogger()
-	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+// The "hubsec" secrets created by earlier versions of the hub are adopted by the releases using them
+func TestAdoptLegacyImagePullSecret(t *testing.T) {
+	ctx := operationTestContext()
+	manager := newTestImagePullSecretManager()
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hubsec", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"old.example.com":{}}}`)},
+	})
 
-	err := secretManager.createOrUpdateImageSecret(ctx)
-
-	assert.True(t, k8sMock.createCalled, "Create should have been called")
-	assert.True(t, k8sMock.updateCalled, "Update should have been called")
-	assert.False(t, k8sMock.deleteCalled, "Delete should not have been called called")
-	assert.Nil(t, err, "Secret should have been updated without error")
+	rel := releaseRequesting("foo", map[string]interface{}{"enabled": true})
+	if _, err := manager.syncRelease(ctx, clientset, rel, true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), "hubsec", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !isManaged(secret) || secret.Annotations[ownerAnnotation("foo")] == "" {
+		t.Errorf("got: %v %v, want: secret managed for foo", secret.Labels, secret.Annotations)
+	}
+	if got, want := string(secret.Data[corev1.DockerConfigJsonKey]), `{"auths":{"hub.example.com":{}}}`; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
 }
 
-func Test_DeleteSecret_Successfully(t *testing.T) {
-	k8sMock, secretManager := generateTestData()
-	nullLogger, _ := test.NewNullLogger()
-	ctx := context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+func TestPropagateRegistryCredentials(t *testing.T) {
+	ctx := operationTestContext()
+	hub := fake.NewSimpleClientset(registryCredentials("registry-a", `{"auths":{"a.example.com":{}}}`))
+	manager := NewImagePullSecretManager(hub.CoreV1().Secrets("hub"))
+	vo := newTestValidation()
+	clientset := vo.clientset
 
-	err := secretManager.deleteImageSecret(ctx)
+	for _, namespace := range []string{"default", "other"} {
+		rel := releaseRequesting("foo", map[string]interface{}{"registries": []interface{}{"registry-a"}})
+		rel.Namespace = namespace
+		if _, err := manager.syncRelease(ctx, clientset, rel, true); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
 
-	assert.False(t, k8sMock.createCalled, "Create should not have been called")
-	assert.False(t, k8sMock.updateCalled, "Update should not have been called")
-	assert.True(t, k8sMock.deleteCalled, "Delete should have been called called")
-	assert.Nil(t, err, "Secret should have been deleted without error")
+	updated := registryCredentials("registry-a", `{"auths":{"a.example.com":{"auth":"new"}}}`)
+	if _, err := hub.CoreV1().Secrets("hub").Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	unmanaged := registryCredentials("registry-a", `{"auths":{"own.example.com":{}}}`)
+	unmanaged.Namespace = "unmanaged"
+	unmanaged.Labels = map[string]string{imagePullSecretLabel: "registry-a"}
+	if _, err := clientset.CoreV1().Secrets("unmanaged").Create(context.TODO(), unmanaged, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The credentials are propagated to the namespaces of recent operations only
+	p := &Proxy{ImagePullSecrets: manager, Targets: NewTargetNamespaces(10, time.Hour)}
+	for _, namespace := range []string{"default", "unmanaged"} {
+		p.rememberTarget(vo, namespace)
+	}
+	p.PropagateImagePullSecrets(ctx)
+
+	expected := map[string]string{
+		"default":   `{"auths":{"a.example.com":{"auth":"new"}}}`,
+		"other":     `{"auths":{"a.example.com":{}}}`,
+		"unmanaged": `{"auths":{"own.example.com":{}}}`,
+	}
+	for namespace, want := range expected {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), "registry-a", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got := string(secret.Data[corev1.DockerConfigJsonKey]); got != want {
+			t.Errorf("got: %q, want: %q in namespace %s", got, want, namespace)
+		}
+	}
 }
@@ -0,0 +1,424 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedCoordinationV1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+const (
+	// DefaultReleaseLockWaitTimeout is the default time an operation waits for the lock of a release
+	DefaultReleaseLockWaitTimeout = 10 * time.Second
+
+	// releaseLockLabel marks the leases used as locks of releases
+	releaseLockLabel      = "hub.k8s.sap.com/release-lock"
+	releaseLockNamePrefix = "hub-release-"
+
+	// The holder of a lock renews its lease regularly. Leases which are not renewed within their
+	// duration belong to holders which were interrupted and can be taken over.
+	releaseLockDuration      = 60 * time.Second
+	releaseLockRenewInterval = releaseLockDuration / 3
+	releaseLockRetryInterval = 500 * time.Millisecond
+	// releaseLockRequestTimeout limits the requests renewing and releasing leases, which run
+	// independent of the requests of the operations
+	releaseLockRequestTimeout = 10 * time.Second
+)
+
+type releaseLockKey struct{}
+
+var errLeaseTakenOver = errors.New("lease was taken over")
+
+// releaseLock is a lock of a release held by an operation of this process. Locks are leases in the
+// namespace of the release in the target cluster, so that they are shared by all replicas of the
+// ui-backend. The lock is lost if its lease is taken over or cannot be renewed in time.
+type releaseLock struct {
+	leases    typedCoordinationV1.LeaseInterface
+	namespace string
+	name      string
+	holder    string
+	done      chan struct{}
+	lost      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// lockHolder returns a new holder identity for a lock, it is unique per operation
+func (p *Proxy) lockHolder() (string, error) {
+	identity := p.LockHolderIdentity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", errors.Wrap(err, "Could not determine lock holder identity")
+		}
+		identity = hostname
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "Could not determine lock holder identity")
+	}
+	return identity + "/" + hex.EncodeToString(suffix), nil
+}
+
+// lockRelease acquires the lock of a release and returns the context of the operation holding it and
+// the function releasing it. The context is canceled if the lock is lost. The lock is reentrant for
+// operations whose context already holds it.
+func (p *Proxy) lockRelease(ctx context.Context, vo ValidationObject, namespace, name string) (context.Context, func(), error) {
+	if held, ok := ctx.Value(releaseLockKey{}).(*releaseLock); ok && held.namespace == namespace && held.name == name {
+		return ctx, func() {}, nil
+	}
+	lock, err := p.acquireReleaseLock(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	lockCtx, cancel := lock.withContext(ctx)
+	return lockCtx, func() {
+		cancel()
+		lock.release(ctx)
+	}, nil
+}
+
+// tryLockRelease acquires the lock of a release without waiting for another holder and returns the
+// function releasing it. The lock is reentrant for operations whose context already holds it.
+func (p *Proxy) tryLockRelease(ctx context.Context, leases typedCoordinationV1.LeaseInterface, namespace, name string) (func(), error) {
+	if held, ok := ctx.Value(releaseLockKey{}).(*releaseLock); ok && held.namespace == namespace && held.name == name {
+		return func() {}, nil
+	}
+	holder, err := p.lockHolder()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := acquireReleaseLock(ctx, leases, namespace, name, holder, 0)
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.release(ctx) }, nil
+}
+
+// withContext returns a context holding the lock which is canceled if the lock is lost
+func (l *releaseLock) withContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	lockCtx, cancel := context.WithCancel(context.WithValue(ctx, releaseLockKey{}, l))
+	go func() {
+		select {
+		case <-l.lost:
+			cancel()
+		case <-lockCtx.Done():
+		}
+	}()
+	return lockCtx, cancel
+}
+
+// isLost returns whether the lease of the lock was taken over or could not be renewed in time
+func (l *releaseLock) isLost() bool {
+	select {
+	case <-l.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// lostError is returned for changes of a release after its lock was lost
+func (l *releaseLock) lostError() error {
+	return errorUtils.Conflict.NewErrorf("Lock of release %q in namespace %q was lost, the release might be changed by another operation", l.name, l.namespace)
+}
+
+func (p *Proxy) acquireReleaseLock(ctx context.Context, vo ValidationObject, namespace, name string) (*releaseLock, error) {
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+	holder, err := p.lockHolder()
+	if err != nil {
+		return nil, err
+	}
+	waitTimeout := p.LockWaitTimeout
+	if waitTimeout == 0 {
+		waitTimeout = DefaultReleaseLockWaitTimeout
+	}
+	return acquireReleaseLock(ctx, clientset.CoordinationV1().Leases(namespace), namespace, name, holder, waitTimeout)
+}
+
+// acquireReleaseLock waits until the lease of the release is free or expired and takes it. A conflict
+// naming the current holder is returned if the lease is still held after the wait timeout.
+func acquireReleaseLock(ctx context.Context, leases typedCoordinationV1.LeaseInterface, namespace, name, holder string, waitTimeout time.Duration) (*releaseLock, error) {
+	lock := &releaseLock{
+		leases:    leases,
+		namespace: namespace,
+		name:      name,
+		holder:    holder,
+		done:      make(chan struct{}),
+		lost:      make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		acquired, currentHolder, err := lock.tryAcquire(ctx, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			if currentHolder == "" {
+				currentHolder = "another operation"
+			}
+			return nil, errorUtils.Conflict.NewErrorf("Release %q in namespace %q is locked by %s", name, namespace, currentHolder)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "Could not lock release %s", name)
+		case <-time.After(releaseLockRetryInterval):
+		}
+	}
+
+	lock.wg.Add(1)
+	go lock.renew(logUtils.GetLogger(ctx))
+	return lock, nil
+}
+
+func (l *releaseLock) leaseName() string {
+	return releaseLockNamePrefix + l.name
+}
+
+// tryAcquire takes the lease if it is free or expired. Otherwise it returns the current holder,
+// which is empty if the lease was changed concurrently.
+func (l *releaseLock) tryAcquire(ctx context.Context, now time.Time) (bool, string, error) {
+	lease, err := l.leases.Get(ctx, l.leaseName(), metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.leaseName(),
+				Namespace: l.namespace,
+				Labels:    map[string]string{releaseLockLabel: "true"},
+			},
+		}
+		l.hold(lease, now)
+		_, err = l.leases.Create(ctx, lease, metav1.CreateOptions{})
+		if k8sErrors.IsAlreadyExists(err) {
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", lockError(err, l.name)
+		}
+		return true, "", nil
+	}
+	if err != nil {
+		return false, "", lockError(err, l.name)
+	}
+
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" && !isLeaseExpired(lease, now) {
+		return false, *lease.Spec.HolderIdentity, nil
+	}
+	l.hold(lease, now)
+	_, err = l.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", lockError(err, l.name)
+	}
+	return true, "", nil
+}
+
+func (l *releaseLock) hold(lease *coordinationv1.Lease, now time.Time) {
+	holder := l.holder
+	durationSeconds := int32(releaseLockDuration / time.Second)
+	acquireTime := metav1.NewMicroTime(now)
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.AcquireTime = &acquireTime
+	lease.Spec.RenewTime = &acquireTime
+}
+
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
+
+func lockError(err error, name string) error {
+	if k8sErrors.IsForbidden(err) {
+		return errorUtils.Forbidden.New(errors.Wrapf(err, "Could not lock release %s", name))
+	}
+	return errors.Wrapf(err, "Could not lock release %s", name)
+}
+
+// renew renews the lease until the lock is released. The lock is lost if the lease was taken over
+// or if it could not be renewed before it expires.
+func (l *releaseLock) renew(log *logUtils.Logger) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(releaseLockRenewInterval)
+	defer ticker.Stop()
+	lastRenewal := time.Now()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			err := l.renewLease()
+			if err == nil {
+				lastRenewal = time.Now()
+				continue
+			}
+			log.Errorf("Could not renew lock of release %s: %v", l.name, err)
+			if isLeaseLost(err, time.Since(lastRenewal)) {
+				log.Errorf("Lost lock of release %s, stopping the operation", l.name)
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+// isLeaseLost returns whether a failed renewal loses the lease, i.e. if it was taken over or if
+// another holder might take it over before the next renewal
+func isLeaseLost(err error, sinceRenewal time.Duration) bool {
+	return errors.Is(err, errLeaseTakenOver) || sinceRenewal+releaseLockRenewInterval >= releaseLockDuration
+}
+
+func (l *releaseLock) renewLease() error {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseLockRequestTimeout)
+	defer cancel()
+	lease, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	renewTime := metav1.NowMicro()
+	lease.Spec.RenewTime = &renewTime
+	_, err = l.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		// The lease was changed since it was read, e.g. by another holder
+		if _, getErr := l.get(ctx); errors.Is(getErr, errLeaseTakenOver) {
+			return getErr
+		}
+	}
+	return err
+}
+
+// get returns the lease if it is still held by this lock
+func (l *releaseLock) get(ctx context.Context) (*coordinationv1.Lease, error) {
+	lease, err := l.leases.Get(ctx, l.leaseName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder {
+		return nil, fmt.Errorf("lease %s: %w", l.leaseName(), errLeaseTakenOver)
+	}
+	return lease, nil
+}
+
+// release stops renewing the lease and deletes it. Failures are only logged since the lease expires
+// if it is not renewed.
+func (l *releaseLock) release(ctx context.Context) {
+	log := logUtils.GetLogger(ctx)
+
+	close(l.done)
+	l.wg.Wait()
+	if l.isLost() {
+		return
+	}
+
+	// The request of the operation may already be finished
+	requestCtx, cancel := context.WithTimeout(context.Background(), releaseLockRequestTimeout)
+	defer cancel()
+	lease, err := l.get(requestCtx)
+	if err != nil {
+		log.Errorf("Could not release lock of release %s: %v", l.name, err)
+		return
+	}
+	err = l.leases.Delete(requestCtx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		log.Errorf("Could not release lock of release %s: %v", l.name, err)
+	}
+}
+
+// guardReleaseLock makes the changes of the action config fail once the lock of the release held
+// by the context is lost. Helm actions cannot be canceled, so they would otherwise carry on and
+// change the release concurrently with the new holder of the lock.
+func guardReleaseLock(ctx context.Context, config *action.Configuration) {
+	lock, ok := ctx.Value(releaseLockKey{}).(*releaseLock)
+	if !ok {
+		return
+	}
+	if config.KubeClient != nil {
+		config.KubeClient = &lockedKubeClient{Interface: config.KubeClient, lock: lock}
+	}
+	if config.Releases != nil {
+		config.Releases.Driver = &lockedDriver{Driver: config.Releases.Driver, lock: lock}
+	}
+}
+
+// lockedKubeClient fails changes of resources after the lock of the release was lost
+type lockedKubeClient struct {
+	kube.Interface
+	lock *releaseLock
+}
+
+func (c *lockedKubeClient) Create(resources kube.ResourceList) (*kube.Result, error) {
+	if c.lock.isLost() {
+		return nil, c.lock.lostError()
+	}
+	return c.Interface.Create(resources)
+}
+
+func (c *lockedKubeClient) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	if c.lock.isLost() {
+		return nil, c.lock.lostError()
+	}
+	return c.Interface.Update(original, target, force)
+}
+
+func (c *lockedKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	if c.lock.isLost() {
+		return nil, []error{c.lock.lostError()}
+	}
+	return c.Interface.Delete(resources)
+}
+
+// lockedDriver fails changes of the release records after the lock of the release was lost
+type lockedDriver struct {
+	driver.Driver
+	lock *releaseLock
+}
+
+func (d *lockedDriver) Create(key string, rls *release.Release) error {
+	if d.lock.isLost() {
+		return d.lock.lostError()
+	}
+	return d.Driver.Create(key, rls)
+}
+
+func (d *lockedDriver) Update(key string, rls *release.Release) error {
+	if d.lock.isLost() {
+		return d.lock.lostError()
+	}
+	return d.Driver.Update(key, rls)
+}
+
+func (d *lockedDriver) Delete(key string) (*release.Release, error) {
+	if d.lock.isLost() {
+		return nil, d.lock.lostError()
+	}
+	return d.Driver.Delete(key)
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestReleaseLock(t *testing.T) {
+	ctx := operationTestContext()
+	leases := fake.NewSimpleClientset().CoordinationV1().Leases("default")
+
+	lock, err := acquireReleaseLock(ctx, leases, "default", "foo", "ui-backend-1/a", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	_, err = acquireReleaseLock(ctx, leases, "default", "foo", "ui-backend-2/b", 0)
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.Conflict {
+		t.Errorf("got: %d, want: %d", got, errorUtils.Conflict)
+	}
+	if !strings.Contains(err.Error(), "ui-backend-1/a") {
+		t.Errorf("got: %q, want: error naming the holder", err.Error())
+	}
+
+	// Releases with the same name in other namespaces are not locked
+	other, err := acquireReleaseLock(ctx, fake.NewSimpleClientset().CoordinationV1().Leases("other"), "other", "foo", "ui-backend-2/b", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	other.release(ctx)
+
+	lock.release(ctx)
+	if _, err := leases.Get(context.TODO(), releaseLockNamePrefix+"foo", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: lease, want: released lease is deleted")
+	}
+
+	lock, err = acquireReleaseLock(ctx, leases, "default", "foo", "ui-backend-2/b", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	lock.release(ctx)
+}
+
+func TestTakeOverExpiredReleaseLock(t *testing.T) {
+	ctx := operationTestContext()
+	holder := "ui-backend-1/a"
+	durationSeconds := int32(releaseLockDuration / time.Second)
+	renewTime := metav1.NewMicroTime(time.Now().Add(-2 * releaseLockDuration))
+	expired := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: releaseLockNamePrefix + "foo", Namespace: "default"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &durationSeconds,
+			RenewTime:            &renewTime,
+		},
+	}
+	leases := fake.NewSimpleClientset(expired).CoordinationV1().Leases("default")
+
+	lock, err := acquireReleaseLock(ctx, leases, "default", "foo", "ui-backend-2/b", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	lease, err := leases.Get(context.TODO(), releaseLockNamePrefix+"foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if *lease.Spec.HolderIdentity != "ui-backend-2/b" {
+		t.Errorf("got: %q, want: %q", *lease.Spec.HolderIdentity, "ui-backend-2/b")
+	}
+	lock.release(ctx)
+}
+
+func TestReentrantReleaseLock(t *testing.T) {
+	lock := &releaseLock{namespace: "default", name: "foo"}
+	ctx := context.WithValue(operationTestContext(), releaseLockKey{}, lock)
+
+	// The lock held by the context is reused without a client
+	lockCtx, unlock, err := (&Proxy{}).lockRelease(ctx, nil, "default", "foo")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if lockCtx != ctx {
+		t.Errorf("got: new context, want: context holding the lock")
+	}
+	unlock()
+}
+
+func TestRenewTakenOverReleaseLock(t *testing.T) {
+	ctx := operationTestContext()
+	leases := fake.NewSimpleClientset().CoordinationV1().Leases("default")
+
+	lock, err := acquireReleaseLock(ctx, leases, "default", "foo", "ui-backend-1/a", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer lock.release(ctx)
+	if err := lock.renewLease(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	lease, err := leases.Get(context.TODO(), releaseLockNamePrefix+"foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	other := "ui-backend-2/b"
+	lease.Spec.HolderIdentity = &other
+	if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	err = lock.renewLease()
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if !isLeaseLost(err, 0) {
+		t.Errorf("got: %v, want: lost lease", err)
+	}
+}
+
+func TestIsLeaseLost(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		sinceRenewal time.Duration
+		expected     bool
+	}{
+		{"taken over", errLeaseTakenOver, 0, true},
+		{"failed renewal", errors.New("timeout"), releaseLockRenewInterval, false},
+		{"lease expires before the next renewal", errors.New("timeout"), releaseLockDuration - releaseLockRenewInterval, true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isLeaseLost(tc.err, tc.sinceRenewal); got != tc.expected {
+				t.Errorf("got: %t, want: %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLostReleaseLockStopsOperation(t *testing.T) {
+	lock := &releaseLock{namespace: "default", name: "foo", lost: make(chan struct{})}
+	ctx, cancel := lock.withContext(operationTestContext())
+	defer cancel()
+
+	config := &action.Configuration{
+		KubeClient: &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Releases:   storage.Init(driver.NewMemory()),
+	}
+	guardReleaseLock(ctx, config)
+	if err := config.Releases.Create(&release.Release{Name: "foo", Version: 1, Info: &release.Info{}}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	close(lock.lost)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("got: running operation, want: canceled context")
+	}
+
+	err := config.Releases.Create(&release.Release{Name: "foo", Version: 2, Info: &release.Info{}})
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.Conflict {
+		t.Errorf("got: %v, want: conflict", err)
+	}
+	if _, err := config.KubeClient.Create(kube.ResourceList{}); err == nil {
+		t.Errorf("got: nil, want: error")
+	}
+}
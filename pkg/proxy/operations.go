@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+// OperationPhase is the phase of an asynchronous release operation
+type OperationPhase string
+
+const (
+	OperationQueued    OperationPhase = "queued"
+	OperationRunning   OperationPhase = "running"
+	OperationSucceeded OperationPhase = "succeeded"
+	OperationFailed    OperationPhase = "failed"
+
+	// Types of release operations
+	OperationInstall = "install"
+	OperationUpgrade = "upgrade"
+
+	// operationLabel marks the config maps containing operation records
+	operationLabel      = "hub.k8s.sap.com/operation"
+	operationDataKey    = "operation.json"
+	operationNamePrefix = "hub-operation-"
+
+	// Running operations update their record regularly. Records which are not updated for a while belong
+	// to operations which were interrupted, e.g. by a restart of the ui-backend.
+	operationHeartbeatInterval = 10 * time.Second
+	operationStaleAfter        = 6 * operationHeartbeatInterval
+	// Records of finished operations are deleted after the retention when new operations are started
+	operationRetention = 24 * time.Hour
+	// maxOperationLogLines limits the log lines kept in an operation record, older lines are dropped
+	maxOperationLogLines = 500
+)
+
+// Operation is the record of an asynchronous install or upgrade of a release. Records are stored as
+// config maps in the namespace of the release so that they survive restarts of the ui-backend.
+type Operation struct {
+	// ID is "<namespace>.<suffix>" so that the record can be found without listing all namespaces
+	ID          string         `json:"id"`
+	Type        string         `json:"type"`
+	Namespace   string         `json:"namespace"`
+	ReleaseName string         `json:"releaseName"`
+	Phase       OperationPhase `json:"phase"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	StartedAt   *time.Time     `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time     `json:"finishedAt,omitempty"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	// Revision is the revision of the release created by a successful operation
+	Revision int `json:"revision,omitempty"`
+	// Error is the error of a failed operation
+	Error string `json:"error,omitempty"`
+	// Logs are the log lines of helm during the operation
+	Logs []string `json:"logs"`
+}
+
+// OperationFunc performs a release operation. Helm logs written while it runs are recorded if
+// ctx is passed to the proxy.
+type OperationFunc func(ctx context.Context) (*release.Release, error)
+
+type operationLogKey struct{}
+
+// setOperationLog records the helm logs of the action config in the operation of the context if there is one
+func setOperationLog(ctx context.Context, config *action.Configuration) {
+	record, ok := ctx.Value(operationLogKey{}).(*operationRecorder)
+	if !ok {
+		return
+	}
+	log := func(format string, v ...interface{}) {
+		logf(format, v...)
+		record.log(fmt.Sprintf(format, v...))
+	}
+	config.Log = log
+	if kc, ok := config.KubeClient.(*kube.Client); ok {
+		kc.Log = log
+	}
+}
+
+// operationRecorder updates the record of a running operation
+type operationRecorder struct {
+	mu         sync.Mutex
+	operation  Operation
+	configMaps typedCoreV1.ConfigMapInterface
+	created    bool
+}
+
+func (r *operationRecorder) log(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operation.Logs = append(r.operation.Logs, line)
+	if len(r.operation.Logs) > maxOperationLogLines {
+		r.operation.Logs = r.operation.Logs[len(r.operation.Logs)-maxOperationLogLines:]
+	}
+}
+
+// update changes the operation and persists its record
+func (r *operationRecorder) update(change func(operation *Operation)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if change != nil {
+		change(&r.operation)
+	}
+	r.operation.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(&r.operation)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal operation")
+	}
+	_, name, err := parseOperationID(r.operation.ID)
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: r.operation.Namespace,
+			Labels:    map[string]string{operationLabel: "true"},
+		},
+		Data: map[string]string{operationDataKey: string(data)},
+	}
+
+	if !r.created {
+		_, err = r.configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "Could not create record of operation %s", r.operation.ID)
+		}
+		r.created = true
+		return nil
+	}
+	_, err = r.configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "Could not update record of operation %s", r.operation.ID)
+}
+
+// snapshot returns a copy of the operation
+func (r *operationRecorder) snapshot() *Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	operation := r.operation
+	operation.Logs = append([]string{}, r.operation.Logs...)
+	return &operation
+}
+
+func newOperationID(namespace string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "Could not create operation ID")
+	}
+	return namespace + "." + hex.EncodeToString(suffix), nil
+}
+
+// parseOperationID returns the namespace and the config map name of the record of an operation
+func parseOperationID(id string) (string, string, error) {
+	parts := strings.Split(id, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errorUtils.BadRequest.NewErrorf("Invalid operation ID %q", id)
+	}
+	return parts[0], operationNamePrefix + parts[1], nil
+}
+
+// startOperation persists the record of a new operation and runs it in the background
+func startOperation(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, operationType, name, namespace string, run OperationFunc) (*Operation, error) {
+	log := logUtils.GetLogger(ctx)
+
+	id, err := newOperationID(namespace)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &operationRecorder{
+		operation: Operation{
+			ID:          id,
+			Type:        operationType,
+			Namespace:   namespace,
+			ReleaseName: name,
+			Phase:       OperationQueued,
+			CreatedAt:   time.Now().UTC(),
+			Logs:        []string{},
+		},
+		configMaps: configMaps,
+	}
+	if err := recorder.update(nil); err != nil {
+		return nil, err
+	}
+	queued := recorder.snapshot()
+
+	cleanupOperations(ctx, configMaps, time.Now())
+
+	// The operation outlives the request, it only keeps its logger
+	operationCtx := context.WithValue(context.Background(), logUtils.LoggerKey{}, log)
+	operationCtx = context.WithValue(operationCtx, operationLogKey{}, recorder)
+	go runOperation(operationCtx, recorder, run)
+
+	return queued, nil
+}
+
+func runOperation(ctx context.Context, recorder *operationRecorder, run OperationFunc) {
+	log := logUtils.GetLogger(ctx)
+
+	err := recorder.update(func(operation *Operation) {
+		now := time.Now().UTC()
+		operation.Phase = OperationRunning
+		operation.StartedAt = &now
+	})
+	if err != nil {
+		log.Error(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(operationHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := recorder.update(nil); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}()
+
+	rel, runErr := run(ctx)
+	close(done)
+
+	err = recorder.update(func(operation *Operation) {
+		now := time.Now().UTC()
+		operation.FinishedAt = &now
+		if runErr != nil {
+			operation.Phase = OperationFailed
+			operation.Error = runErr.Error()
+			return
+		}
+		operation.Phase = OperationSucceeded
+		if rel != nil {
+			operation.Revision = rel.Version
+		}
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// getOperation returns the record of an operation. Operations which are not finished but whose
+// record was not updated recently are reported as failed.
+func getOperation(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, id string, now time.Time) (*Operation, error) {
+	_, name, err := parseOperationID(id)
+	if err != nil {
+		return nil, err
+	}
+	configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil, errorUtils.NotFound.NewErrorf("Operation %q not found", id)
+		}
+		return nil, errors.Wrapf(err, "Could not get operation %s", id)
+	}
+	operation, err := decodeOperation(configMap)
+	if err != nil {
+		return nil, err
+	}
+	if operation.ID != id {
+		return nil, errorUtils.NotFound.NewErrorf("Operation %q not found", id)
+	}
+
+	finished := operation.Phase == OperationSucceeded || operation.Phase == OperationFailed
+	if !finished && now.Sub(operation.UpdatedAt) > operationStaleAfter {
+		operation.Phase = OperationFailed
+		operation.Error = "Operation was interrupted"
+	}
+	return operation, nil
+}
+
+func decodeOperation(configMap *corev1.ConfigMap) (*Operation, error) {
+	operation := &Operation{}
+	if err := json.Unmarshal([]byte(configMap.Data[operationDataKey]), operation); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse operation record %s", configMap.Name)
+	}
+	return operation, nil
+}
+
+// cleanupOperations deletes the records of operations which finished before the retention period.
+// Failures are only logged since they do not affect new operations.
+func cleanupOperations(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, now time.Time) {
+	log := logUtils.GetLogger(ctx)
+
+	list, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: operationLabel + "=true"})
+	if err != nil {
+		log.Errorf("Could not list operation records: %v", err)
+		return
+	}
+	for i := range list.Items {
+		operation, err := decodeOperation(&list.Items[i])
+		if err != nil || operation.FinishedAt == nil || now.Sub(*operation.FinishedAt) < operationRetention {
+			continue
+		}
+		err = configMaps.Delete(ctx, list.Items[i].Name, metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			log.Errorf("Could not delete operation record %s: %v", list.Items[i].Name, err)
+		}
+	}
+}
+
+// StartOperation runs a release operation in the background and returns its record
+func (p *Proxy) StartOperation(ctx context.Context, operationType, name, namespace string, vo ValidationObject, run OperationFunc) (*Operation, error) {
+	// The namespace of the record is created before the release is installed
+	if err := ensureNamespace(ctx, namespace, vo); err != nil {
+		return nil, err
+	}
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+
+	// The release is locked before the operation is started so that conflicts are reported to the caller.
+	// The operation passes the lock on to the proxy calls of run and releases it when it is finished.
+	lock, err := p.acquireReleaseLock(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	locked := func(ctx context.Context) (*release.Release, error) {
+		defer lock.release(ctx)
+		lockCtx, cancel := lock.withContext(ctx)
+		defer cancel()
+		return run(lockCtx)
+	}
+	operation, err := startOperation(ctx, clientset.CoreV1().ConfigMaps(namespace), operationType, name, namespace, locked)
+	if err != nil {
+		lock.release(ctx)
+		return nil, err
+	}
+	return operation, nil
+}
+
+// GetOperation returns the record of an operation
+func (p *Proxy) GetOperation(ctx context.Context, id string, vo ValidationObject) (*Operation, error) {
+	namespace, _, err := parseOperationID(id)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+	return getOperation(ctx, clientset.CoreV1().ConfigMaps(namespace), id, time.Now())
+}
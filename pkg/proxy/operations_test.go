@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+// testValidation accesses a fake cluster whose releases are stored in memory and whose resources are
+// not created
+type testValidation struct {
+	cluster   string
+	releases  *storage.Storage
+	clientset *fake.Clientset
+}
+
+func newTestValidation() *testValidation {
+	memory := driver.NewMemory()
+	memory.SetNamespace("default")
+	return &testValidation{releases: storage.Init(memory), clientset: fake.NewSimpleClientset()}
+}
+
+func (v *testValidation) initActionConfig(namespace string) *action.Configuration {
+	return &action.Configuration{
+		KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Releases:     v.releases,
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+}
+
+func (v *testValidation) getClientSet(namespace string) (kubernetes.Interface, error) {
+	return v.clientset, nil
+}
+
+func (v *testValidation) clusterKey() string {
+	return v.cluster
+}
+
+func operationTestContext() context.Context {
+	nullLogger, _ := test.NewNullLogger()
+	return context.WithValue(context.TODO(), logUtils.LoggerKey{}, &logUtils.Logger{Entry: logrus.NewEntry(nullLogger)})
+}
+
+// waitForOperation polls the record of the operation until it is finished
+func waitForOperation(t *testing.T, configMaps typedCoreV1.ConfigMapInterface, id string) *Operation {
+	for i := 0; i < 100; i++ {
+		operation, err := getOperation(context.TODO(), configMaps, id, time.Now())
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if operation.Phase == OperationSucceeded || operation.Phase == OperationFailed {
+			return operation
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Operation %s did not finish", id)
+	return nil
+}
+
+func TestOperation(t *testing.T) {
+	testCases := []struct {
+		name             string
+		run              OperationFunc
+		expectedPhase    OperationPhase
+		expectedRevision int
+		expectedError    string
+		expectedLogs     []string
+	}{
+		{
+			"succeeded",
+			func(ctx context.Context) (*release.Release, error) {
+				config := &action.Configuration{}
+				setOperationLog(ctx, config)
+				config.Log("creating %d resource(s)", 2)
+				return &release.Release{Name: "foo", Version: 3}, nil
+			},
+			OperationSucceeded, 3, "", []string{"creating 2 resource(s)"},
+		},
+		{
+			"failed",
+			func(ctx context.Context) (*release.Release, error) {
+				return nil, errors.New("timed out waiting for the condition")
+			},
+			OperationFailed, 0, "timed out waiting for the condition", []string{},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+
+			operation, err := startOperation(operationTestContext(), configMaps, OperationInstall, "foo", "default", tc.run)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if operation.Phase != OperationQueued {
+				t.Errorf("got: %q, want: %q", operation.Phase, OperationQueued)
+			}
+			if namespace, _, err := parseOperationID(operation.ID); err != nil || namespace != "default" {
+				t.Errorf("got: %q, want: %q", namespace, "default")
+			}
+
+			operation = waitForOperation(t, configMaps, operation.ID)
+			if operation.Phase != tc.expectedPhase {
+				t.Errorf("got: %q, want: %q", operation.Phase, tc.expectedPhase)
+			}
+			if operation.Revision != tc.expectedRevision {
+				t.Errorf("got: %d, want: %d", operation.Revision, tc.expectedRevision)
+			}
+			if operation.Error != tc.expectedError {
+				t.Errorf("got: %q, want: %q", operation.Error, tc.expectedError)
+			}
+			if operation.StartedAt == nil || operation.FinishedAt == nil {
+				t.Errorf("got: %v - %v, want: start and finish time", operation.StartedAt, operation.FinishedAt)
+			}
+			if len(operation.Logs) != len(tc.expectedLogs) || (len(tc.expectedLogs) > 0 && operation.Logs[0] != tc.expectedLogs[0]) {
+				t.Errorf("got: %q, want: %q", operation.Logs, tc.expectedLogs)
+			}
+		})
+	}
+}
+
+func newOperationRecord(t *testing.T, operation Operation) *corev1.ConfigMap {
+	data, err := json.Marshal(operation)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, name, err := parseOperationID(operation.ID)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: operation.Namespace,
+			Labels:    map[string]string{operationLabel: "true"},
+		},
+		Data: map[string]string{operationDataKey: string(data)},
+	}
+}
+
+func TestGetInterruptedOperation(t *testing.T) {
+	now := time.Now()
+	running := Operation{ID: "default.1", Namespace: "default", Phase: OperationRunning, UpdatedAt: now.Add(-operationHeartbeatInterval)}
+	interrupted := Operation{ID: "default.2", Namespace: "default", Phase: OperationRunning, UpdatedAt: now.Add(-2 * operationStaleAfter)}
+	configMaps := fake.NewSimpleClientset(newOperationRecord(t, running), newOperationRecord(t, interrupted)).CoreV1().ConfigMaps("default")
+
+	operation, err := getOperation(context.TODO(), configMaps, running.ID, now)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if operation.Phase != OperationRunning {
+		t.Errorf("got: %q, want: %q", operation.Phase, OperationRunning)
+	}
+
+	operation, err = getOperation(context.TODO(), configMaps, interrupted.ID, now)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if operation.Phase != OperationFailed {
+		t.Errorf("got: %q, want: %q", operation.Phase, OperationFailed)
+	}
+}
+
+func TestGetOperationErrors(t *testing.T) {
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+	testCases := []struct {
+		id           string
+		expectedType errorUtils.HTTPErrorType
+	}{
+		{"default", errorUtils.BadRequest},
+		{"default.a.b", errorUtils.BadRequest},
+		{"default.1", errorUtils.NotFound},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.id, func(t *testing.T) {
+			_, err := getOperation(context.TODO(), configMaps, tc.id, time.Now())
+			if err == nil {
+				t.Fatalf("got: nil, want: error")
+			}
+			if got, _ := errorUtils.GetHTTPErrorType(err); got != tc.expectedType {
+				t.Errorf("got: %d, want: %d", got, tc.expectedType)
+			}
+		})
+	}
+}
+
+func TestCleanupOperations(t *testing.T) {
+	now := time.Now()
+	finishedLongAgo := now.Add(-2 * operationRetention)
+	finishedRecently := now.Add(-time.Minute)
+	clientset := fake.NewSimpleClientset(
+		newOperationRecord(t, Operation{ID: "default.1", Namespace: "default", Phase: OperationSucceeded, FinishedAt: &finishedLongAgo}),
+		newOperationRecord(t, Operation{ID: "default.2", Namespace: "default", Phase: OperationFailed, FinishedAt: &finishedRecently}),
+		newOperationRecord(t, Operation{ID: "default.3", Namespace: "default", Phase: OperationRunning, UpdatedAt: now}),
+	)
+	configMaps := clientset.CoreV1().ConfigMaps("default")
+
+	cleanupOperations(operationTestContext(), configMaps, now)
+
+	list, err := configMaps.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	remaining := []string{}
+	for _, configMap := range list.Items {
+		remaining = append(remaining, configMap.Name)
+	}
+	if len(remaining) != 2 || remaining[0] != operationNamePrefix+"2" || remaining[1] != operationNamePrefix+"3" {
+		t.Errorf("got: %q, want: %q", remaining, []string{operationNamePrefix + "2", operationNamePrefix + "3"})
+	}
+}
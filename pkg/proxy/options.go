@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"time"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+const (
+	// DefaultReleaseTimeout is the time helm waits for resources and hooks if no timeout is requested
+	DefaultReleaseTimeout = 5 * time.Minute
+	// DefaultMaxReleaseTimeout is the default of the longest timeout which can be requested
+	DefaultMaxReleaseTimeout = 30 * time.Minute
+)
+
+// ReleaseOptions are the helm options of installs and upgrades
+type ReleaseOptions struct {
+	// Wait waits until all resources are ready before the release is marked as deployed
+	Wait bool `json:"wait,omitempty"`
+	// Atomic uninstalls a failed install and rolls back a failed upgrade, it implies Wait
+	Atomic bool `json:"atomic,omitempty"`
+	// TimeoutSeconds limits the time waiting for resources and hooks
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+	// DisableHooks skips the hooks of the chart
+	DisableHooks bool `json:"disableHooks,omitempty"`
+	// Force replaces resources which cannot be patched, it only applies to upgrades
+	Force bool `json:"force,omitempty"`
+	// Patches are applied to the rendered resources and stored with the revision. Upgrades without
+	// patches reuse the patches of the current revision, an empty list removes them.
+	Patches []ReleasePatch `json:"patches,omitempty"`
+	// ReuseValues merges the values of the request into the values of the current revision, it only
+	// applies to upgrades
+	ReuseValues bool `json:"reuseValues,omitempty"`
+	// ResetValues drops the values of the current revision, even if the request contains no values.
+	// It only applies to upgrades.
+	ResetValues bool `json:"resetValues,omitempty"`
+}
+
+// Validate checks that the options do not exceed the limits of the server and sets the default
+// timeout if none is requested. There is no limit if maxTimeout is 0.
+func (o *ReleaseOptions) Validate(maxTimeout time.Duration) error {
+	if o.TimeoutSeconds < 0 {
+		return errorUtils.BadRequest.NewErrorf("Invalid timeout of %d seconds", o.TimeoutSeconds)
+	}
+	if o.TimeoutSeconds == 0 {
+		timeout := DefaultReleaseTimeout
+		if maxTimeout > 0 && maxTimeout < timeout {
+			timeout = maxTimeout
+		}
+		o.TimeoutSeconds = int64(timeout / time.Second)
+	}
+	if o.ReuseValues && o.ResetValues {
+		return errorUtils.BadRequest.NewError("Values cannot be both reused and reset")
+	}
+	if maxTimeout > 0 && o.Timeout() > maxTimeout {
+		return errorUtils.UnprocessableEntity.NewErrorf("Timeout of %d seconds exceeds the maximum of %s", o.TimeoutSeconds, maxTimeout)
+	}
+	return validatePatches(o.Patches)
+}
+
+// Timeout returns the time helm waits for resources and hooks
+func (o *ReleaseOptions) Timeout() time.Duration {
+	if o.TimeoutSeconds <= 0 {
+		return DefaultReleaseTimeout
+	}
+	return time.Duration(o.TimeoutSeconds) * time.Second
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestValidateReleaseOptions(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		timeoutSeconds         int64
+		maxTimeout             time.Duration
+		expectedTimeoutSeconds int64
+		expectedType           errorUtils.HTTPErrorType
+	}{
+		{"default timeout", 0, DefaultMaxReleaseTimeout, 300, 0},
+		{"default timeout above the maximum", 0, time.Minute, 60, 0},
+		{"requested timeout", 600, DefaultMaxReleaseTimeout, 600, 0},
+		{"no maximum", 86400, 0, 86400, 0},
+		{"timeout above the maximum", 3600, DefaultMaxReleaseTimeout, 3600, errorUtils.UnprocessableEntity},
+		{"negative timeout", -1, DefaultMaxReleaseTimeout, -1, errorUtils.BadRequest},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			options := &ReleaseOptions{TimeoutSeconds: tc.timeoutSeconds}
+			err := options.Validate(tc.maxTimeout)
+			if tc.expectedType != 0 {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				if got, _ := errorUtils.GetHTTPErrorType(err); got != tc.expectedType {
+					t.Errorf("got: %d, want: %d", got, tc.expectedType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if options.TimeoutSeconds != tc.expectedTimeoutSeconds {
+				t.Errorf("got: %d, want: %d", options.TimeoutSeconds, tc.expectedTimeoutSeconds)
+			}
+		})
+	}
+}
+
+func TestValidateReuseAndResetValues(t *testing.T) {
+	options := &ReleaseOptions{ReuseValues: true, ResetValues: true}
+	err := options.Validate(DefaultMaxReleaseTimeout)
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.BadRequest {
+		t.Errorf("got: %d, want: %d", got, errorUtils.BadRequest)
+	}
+}
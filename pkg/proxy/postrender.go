@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/postrender"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resid"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+const (
+	// releasePatchesLabel marks the config maps containing the patches of the revisions of a release
+	releasePatchesLabel      = "hub.k8s.sap.com/release-patches"
+	releasePatchesNamePrefix = "hub-patches-"
+
+	kustomizeDir = "/release"
+)
+
+// ReleasePatch is a kustomize patch of the resources of a release
+type ReleasePatch struct {
+	// Patch is either a strategic merge patch or a JSON6902 patch, both as YAML or JSON
+	Patch string `json:"patch"`
+	// Target selects the resources to patch. It is required for JSON6902 patches, strategic merge
+	// patches without target are applied to the resource with their kind and name.
+	Target *PatchTarget `json:"target,omitempty"`
+}
+
+// PatchTarget selects the resources a patch is applied to
+type PatchTarget struct {
+	Group              string `json:"group,omitempty"`
+	Version            string `json:"version,omitempty"`
+	Kind               string `json:"kind,omitempty"`
+	Namespace          string `json:"namespace,omitempty"`
+	Name               string `json:"name,omitempty"`
+	LabelSelector      string `json:"labelSelector,omitempty"`
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+}
+
+// validatePatches checks that the patches are not empty, whether they apply is only known when the
+// release is rendered
+func validatePatches(patches []ReleasePatch) error {
+	for i, patch := range patches {
+		if strings.TrimSpace(patch.Patch) == "" {
+			return errorUtils.BadRequest.NewErrorf("Patch %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// patchPostRenderer applies kustomize patches to the manifest rendered by helm
+type patchPostRenderer struct {
+	patches []ReleasePatch
+}
+
+// newPatchPostRenderer returns the post renderer of the patches, it is nil if there are no patches
+func newPatchPostRenderer(patches []ReleasePatch) postrender.PostRenderer {
+	if len(patches) == 0 {
+		return nil
+	}
+	return &patchPostRenderer{patches: patches}
+}
+
+// Run builds a kustomization of the rendered manifest with the patches in memory
+func (r *patchPostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if strings.TrimSpace(renderedManifests.String()) == "" {
+		return renderedManifests, nil
+	}
+
+	kustomization := types.Kustomization{
+		Resources: []string{"resources.yaml"},
+	}
+	for _, patch := range r.patches {
+		kustomizePatch := types.Patch{Patch: patch.Patch}
+		if patch.Target != nil {
+			kustomizePatch.Target = &types.Selector{
+				Gvk:                resid.Gvk{Group: patch.Target.Group, Version: patch.Target.Version, Kind: patch.Target.Kind},
+				Namespace:          patch.Target.Namespace,
+				Name:               patch.Target.Name,
+				LabelSelector:      patch.Target.LabelSelector,
+				AnnotationSelector: patch.Target.AnnotationSelector,
+			}
+		}
+		kustomization.Patches = append(kustomization.Patches, kustomizePatch)
+	}
+	kustomizationYaml, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not marshal kustomization")
+	}
+
+	fs := filesys.MakeFsInMemory()
+	if err := fs.WriteFile(filepath.Join(kustomizeDir, "resources.yaml"), renderedManifests.Bytes()); err != nil {
+		return nil, errors.Wrap(err, "Could not write manifest for kustomization")
+	}
+	if err := fs.WriteFile(filepath.Join(kustomizeDir, "kustomization.yaml"), kustomizationYaml); err != nil {
+		return nil, errors.Wrap(err, "Could not write kustomization")
+	}
+
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, kustomizeDir)
+	if err != nil {
+		return nil, errorUtils.UnprocessableEntity.New(errors.Wrap(err, "Could not apply patches"))
+	}
+	manifest, err := resources.AsYaml()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not marshal patched manifest")
+	}
+	return bytes.NewBuffer(manifest), nil
+}
+
+// getReleasePatches returns the patches of a revision of a release, nil if it has none
+func getReleasePatches(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, name string, revision int) ([]ReleasePatch, error) {
+	configMap, err := configMaps.Get(ctx, releasePatchesNamePrefix+name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not get patches of release %s", name)
+	}
+	data, ok := configMap.Data[strconv.Itoa(revision)]
+	if !ok {
+		return nil, nil
+	}
+	patches := []ReleasePatch{}
+	if err := json.Unmarshal([]byte(data), &patches); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse patches of release %s", name)
+	}
+	return patches, nil
+}
+
+// saveReleasePatches stores the patches of a revision of a release. The patches of revisions which
+// are no longer in the history of the release with at most maxHistory revisions are dropped.
+func saveReleasePatches(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, name, namespace string, revision, maxHistory int, patches []ReleasePatch) error {
+	configMap, err := configMaps.Get(ctx, releasePatchesNamePrefix+name, metav1.GetOptions{})
+	exists := err == nil
+	if k8sErrors.IsNotFound(err) {
+		if len(patches) == 0 {
+			return nil
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      releasePatchesNamePrefix + name,
+				Namespace: namespace,
+				Labels:    map[string]string{releasePatchesLabel: "true"},
+			},
+			Data: map[string]string{},
+		}
+	} else if err != nil {
+		return errors.Wrapf(err, "Could not get patches of release %s", name)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+
+	for key := range configMap.Data {
+		if stored, err := strconv.Atoi(key); err != nil || (maxHistory > 0 && stored <= revision-maxHistory) {
+			delete(configMap.Data, key)
+		}
+	}
+	if len(patches) > 0 {
+		data, err := json.Marshal(patches)
+		if err != nil {
+			return errors.Wrap(err, "Could not marshal patches")
+		}
+		configMap.Data[strconv.Itoa(revision)] = string(data)
+	} else {
+		delete(configMap.Data, strconv.Itoa(revision))
+	}
+
+	if exists {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	} else {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	return errors.Wrapf(err, "Could not store patches of release %s", name)
+}
+
+// dropReleasePatches removes the patches of pruned revisions of a release
+func dropReleasePatches(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, name string, revisions []*release.Release) error {
+	configMap, err := configMaps.Get(ctx, releasePatchesNamePrefix+name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Could not get patches of release %s", name)
+	}
+	changed := false
+	for _, rev := range revisions {
+		if _, ok := configMap.Data[strconv.Itoa(rev.Version)]; ok {
+			delete(configMap.Data, strconv.Itoa(rev.Version))
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "Could not store patches of release %s", name)
+}
+
+// deleteReleasePatches deletes the patches of all revisions of a release
+func deleteReleasePatches(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, name string) error {
+	err := configMaps.Delete(ctx, releasePatchesNamePrefix+name, metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return errors.Wrapf(err, "Could not delete patches of release %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+const renderedManifest = `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: foo:1.0.0
+---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+spec:
+  type: ClusterIP
+`
+
+func TestPatchPostRenderer(t *testing.T) {
+	patches := []ReleasePatch{
+		{
+			Patch: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    team: bar
+`,
+		},
+		{
+			Patch: `
+- op: add
+  path: /spec/template/spec/tolerations
+  value:
+  - {key: dedicated, operator: Exists}
+`,
+			Target: &PatchTarget{Kind: "Deployment", Name: "foo"},
+		},
+	}
+
+	out, err := newPatchPostRenderer(patches).Run(bytes.NewBufferString(renderedManifest))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, expected := range []string{"team: bar", "key: dedicated", "kind: Service", "image: foo:1.0.0"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("got: %s, want: manifest containing %q", out.String(), expected)
+		}
+	}
+}
+
+func TestPatchPostRendererWithInvalidPatch(t *testing.T) {
+	patches := []ReleasePatch{{Patch: "- op: remove\n  path: /spec/missing\n", Target: &PatchTarget{Kind: "Service"}}}
+
+	_, err := newPatchPostRenderer(patches).Run(bytes.NewBufferString(renderedManifest))
+	if err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.UnprocessableEntity {
+		t.Errorf("got: %d, want: %d", got, errorUtils.UnprocessableEntity)
+	}
+}
+
+func TestReleasePatches(t *testing.T) {
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+	patches := []ReleasePatch{{Patch: "metadata: {labels: {team: bar}}", Target: &PatchTarget{Kind: "Deployment"}}}
+
+	// Releases without patches do not need a config map
+	if err := saveReleasePatches(context.TODO(), configMaps, "foo", "default", 1, DefaultReleaseMaxHistory, nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := configMaps.Get(context.TODO(), releasePatchesNamePrefix+"foo", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: config map, want: no config map")
+	}
+
+	for revision := 2; revision <= 2+DefaultReleaseMaxHistory; revision++ {
+		if err := saveReleasePatches(context.TODO(), configMaps, "foo", "default", revision, DefaultReleaseMaxHistory, patches); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	latest, err := getReleasePatches(context.TODO(), configMaps, "foo", 2+DefaultReleaseMaxHistory)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(latest) != 1 || latest[0].Patch != patches[0].Patch || latest[0].Target.Kind != "Deployment" {
+		t.Errorf("got: %+v, want: %+v", latest, patches)
+	}
+
+	// The patches of revisions which are no longer in the history are dropped
+	pruned, err := getReleasePatches(context.TODO(), configMaps, "foo", 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if pruned != nil {
+		t.Errorf("got: %+v, want: no patches", pruned)
+	}
+
+	if err := deleteReleasePatches(context.TODO(), configMaps, "foo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := deleteReleasePatches(context.TODO(), configMaps, "foo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// Patches can change the kind and namespace of resources, the manifest checked for the permissions
+// of the user has to contain the patched resources
+func TestPreviewReleaseAppliesPatches(t *testing.T) {
+	ch := &chart.Chart{
+		Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "foo", Version: "1.0.0"},
+		Templates: []*chart.File{{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\n  namespace: {{ .Release.Namespace }}\n")}},
+	}
+	patches := []ReleasePatch{{
+		Patch:  "- op: replace\n  path: /kind\n  value: Secret\n- op: replace\n  path: /metadata/namespace\n  value: kube-system\n",
+		Target: &PatchTarget{Kind: "ConfigMap", Name: "foo"},
+	}}
+	vo := newTestValidation()
+	p := &Proxy{}
+
+	for _, action := range []string{"install", "upgrade"} {
+		preview, err := p.PreviewRelease(operationTestContext(), "foo", "default", "", ch, ReleaseOptions{Patches: patches}, vo)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if preview.Action != action {
+			t.Errorf("got: %s, want: %s", preview.Action, action)
+		}
+		for _, expected := range []string{"kind: Secret", "namespace: kube-system"} {
+			if !strings.Contains(preview.Manifest, expected) {
+				t.Errorf("got: %s, want: manifest containing %q", preview.Manifest, expected)
+			}
+		}
+		if action == "install" {
+			current := &release.Release{Name: "foo", Namespace: "default", Version: 1, Chart: ch, Info: &release.Info{Status: release.StatusDeployed}}
+			if err := vo.releases.Create(current); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+}
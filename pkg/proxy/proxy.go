@@ -19,40 +19,47 @@ package proxy
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	grpcStatus "google.golang.org/grpc/status"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/yaml"
 
+	hubv1 "github.com/gardener/potter-hub/pkg/external/hubcontroller/api/v1"
+	"github.com/gardener/potter-hub/pkg/health"
 	logUtils "github.com/gardener/potter-hub/pkg/log"
 )
 
-// nolint
-var (
-	appMutex map[string]*sync.Mutex
-)
-
-// nolint
-func init() {
-	appMutex = make(map[string]*sync.Mutex)
-}
-
 // Proxy contains all the elements to contact Tiller and the K8s API
 type Proxy struct {
+	// LockHolderIdentity identifies this process in the locks of releases, the hostname is used if it is empty
+	LockHolderIdentity string
+	// LockWaitTimeout is the time an operation waits for the lock of a release before it fails with a conflict
+	LockWaitTimeout time.Duration
+	// ImagePullSecrets copies registry credentials into the namespaces of releases, it is disabled if nil
+	ImagePullSecrets *ImagePullSecretManager
+	// Retention is the retention policy of release histories, namespaces can override it with labels
+	Retention RetentionPolicy
+	// Targets remembers the namespaces of operations for the release pruner and the propagation of
+	// registry credentials, both are disabled if nil
+	Targets *TargetNamespaces
 }
 
 // NewProxy creates a Proxy
 func NewProxy() *Proxy {
-	return &Proxy{}
+	return &Proxy{
+		Retention: RetentionPolicy{MaxHistory: DefaultReleaseMaxHistory},
+	}
 }
 
 // AppOverview represents the basics of a release
@@ -111,8 +118,10 @@ func (p *Proxy) ResolveManifest(ctx context.Context, namespace, values string, c
 	install.DryRun = true
 	install.ReleaseName = "dummyrlsname"
 	install.Namespace = namespace
-	// TODO: add values override (?)
-	valuesMap := make(map[string]interface{})
+	valuesMap, err := getValueMap(values)
+	if err != nil {
+		return "", err
+	}
 
 	resDry, err := install.Run(ch, valuesMap)
 
@@ -134,6 +143,212 @@ func (p *Proxy) ResolveManifestFromRelease(ctx context.Context, namespace, relea
 	return strings.TrimLeft(rel.Manifest, "\n"), nil
 }
 
+// ReleasePreview is the result of a dry run of the installation or upgrade of a release
+type ReleasePreview struct {
+	// Action is "install" if the release does not exist yet, "upgrade" otherwise
+	Action   string `json:"action"`
+	Manifest string `json:"manifest"`
+	// CurrentRevision is the revision of the release the diff is computed against
+	CurrentRevision int `json:"currentRevision,omitempty"`
+	// Diff contains the resources which are changed by an upgrade
+	Diff []ResourceDiff `json:"diff,omitempty"`
+	// EffectiveValues are the values the chart is rendered with, including its defaults
+	EffectiveValues map[string]interface{} `json:"effectiveValues,omitempty"`
+}
+
+// PreviewRelease renders the chart with the values as the release would be installed or upgraded,
+// without changing the release. The manifest of an upgrade is diffed against the current revision.
+// The patches of the current revision are applied if the options contain no patches.
+func (p *Proxy) PreviewRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*ReleasePreview, error) {
+	config := vo.initActionConfig(namespace)
+
+	valuesMap, err := getValueMap(values)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := action.NewGet(config).Run(name)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, errors.New(prettyError(err).Error())
+	}
+	if current != nil && namespace != "" && current.Namespace != namespace {
+		return nil, errors.Errorf("Release %q not found in namespace %q", name, namespace)
+	}
+
+	if current == nil {
+		install := action.NewInstall(config)
+		install.DryRun = true
+		install.ReleaseName = name
+		install.Namespace = namespace
+		install.PostRenderer = newPatchPostRenderer(options.Patches)
+		rel, err := install.Run(ch, valuesMap)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not run install dry run")
+		}
+		effectiveValues, err := EffectiveValues(rel)
+		if err != nil {
+			return nil, err
+		}
+		return &ReleasePreview{
+			Action:          "install",
+			Manifest:        strings.TrimLeft(rel.Manifest, "\n"),
+			EffectiveValues: effectiveValues,
+		}, nil
+	}
+
+	patches, err := p.releasePatches(ctx, vo, current, options.Patches)
+	if err != nil {
+		return nil, err
+	}
+	upgrade := action.NewUpgrade(config)
+	upgrade.DryRun = true
+	upgrade.Namespace = namespace
+	upgrade.ReuseValues = options.ReuseValues
+	upgrade.ResetValues = options.ResetValues
+	upgrade.PostRenderer = newPatchPostRenderer(patches)
+	rel, err := upgrade.Run(name, ch, valuesMap)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not run upgrade dry run")
+	}
+	manifest := strings.TrimLeft(rel.Manifest, "\n")
+	diff, err := DiffManifests(current.Manifest, manifest, revisionLabel(current.Version), "preview")
+	if err != nil {
+		return nil, err
+	}
+	effectiveValues, err := EffectiveValues(rel)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleasePreview{
+		Action:          "upgrade",
+		Manifest:        manifest,
+		CurrentRevision: current.Version,
+		Diff:            diff,
+		EffectiveValues: effectiveValues,
+	}, nil
+}
+
+// ReleaseRevision is a revision in the history of a release
+type ReleaseRevision struct {
+	Revision      int            `json:"revision"`
+	Status        release.Status `json:"status"`
+	Chart         string         `json:"chart"`
+	ChartVersion  string         `json:"chartVersion"`
+	AppVersion    string         `json:"appVersion,omitempty"`
+	Description   string         `json:"description"`
+	FirstDeployed time.Time      `json:"firstDeployed"`
+	LastDeployed  time.Time      `json:"lastDeployed"`
+	Deleted       *time.Time     `json:"deleted,omitempty"`
+}
+
+// GetReleaseHistory returns the revisions of a release, the oldest first
+func (p *Proxy) GetReleaseHistory(ctx context.Context, name, namespace string, vo ValidationObject) ([]ReleaseRevision, error) {
+	config := vo.initActionConfig(namespace)
+
+	releases, err := action.NewHistory(config).Run(name)
+	if err != nil {
+		return nil, errors.New(prettyError(err).Error())
+	}
+	releaseutil.SortByRevision(releases)
+
+	history := []ReleaseRevision{}
+	for _, rel := range releases {
+		if namespace != "" && rel.Namespace != namespace {
+			continue
+		}
+		history = append(history, newReleaseRevision(rel))
+	}
+	if len(history) == 0 {
+		return nil, errors.Errorf("Release %q not found in namespace %q", name, namespace)
+	}
+	return history, nil
+}
+
+func newReleaseRevision(rel *release.Release) ReleaseRevision {
+	revision := ReleaseRevision{Revision: rel.Version}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		revision.Chart = rel.Chart.Metadata.Name
+		revision.ChartVersion = rel.Chart.Metadata.Version
+		revision.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	if rel.Info != nil {
+		revision.Status = rel.Info.Status
+		revision.Description = rel.Info.Description
+		revision.FirstDeployed = rel.Info.FirstDeployed.Time
+		revision.LastDeployed = rel.Info.LastDeployed.Time
+		if !rel.Info.Deleted.IsZero() {
+			deleted := rel.Info.Deleted.Time
+			revision.Deleted = &deleted
+		}
+	}
+	return revision
+}
+
+// RevisionDiff contains the changes between two revisions of a release
+type RevisionDiff struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Manifest contains the resources which differ between the revisions
+	Manifest []ResourceDiff `json:"manifest"`
+	// Values is the unified diff of the values supplied by the user, empty if they are equal
+	Values string `json:"values"`
+}
+
+// DiffReleaseRevisions returns the differences of the manifests and values of two revisions of a
+// release. The current revision is used if a revision is 0.
+func (p *Proxy) DiffReleaseRevisions(ctx context.Context, name, namespace string, from, to int, vo ValidationObject) (*RevisionDiff, error) {
+	fromRelease, err := p.getReleaseRevision(vo, name, namespace, from)
+	if err != nil {
+		return nil, err
+	}
+	toRelease, err := p.getReleaseRevision(vo, name, namespace, to)
+	if err != nil {
+		return nil, err
+	}
+
+	fromLabel, toLabel := revisionLabel(fromRelease.Version), revisionLabel(toRelease.Version)
+	manifestDiff, err := DiffManifests(fromRelease.Manifest, toRelease.Manifest, fromLabel, toLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	fromValues, err := yaml.Marshal(fromRelease.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not marshal values")
+	}
+	toValues, err := yaml.Marshal(toRelease.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not marshal values")
+	}
+	valuesDiff, err := unifiedDiff(string(fromValues), string(toValues), fromLabel, toLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevisionDiff{
+		From:     fromRelease.Version,
+		To:       toRelease.Version,
+		Manifest: manifestDiff,
+		Values:   valuesDiff,
+	}, nil
+}
+
+// getReleaseRevision returns a revision of a release, the current revision if it is 0
+func (p *Proxy) getReleaseRevision(vo ValidationObject, name, namespace string, revision int) (*release.Release, error) {
+	config := vo.initActionConfig(namespace)
+
+	get := action.NewGet(config)
+	get.Version = revision
+	rls, err := get.Run(name)
+	if err != nil {
+		return nil, errors.New(prettyError(err).Error())
+	}
+	if namespace != "" && rls.Namespace != namespace {
+		return nil, errors.Errorf("Release %q not found in namespace %q", name, namespace)
+	}
+	return rls, nil
+}
+
 // Apply the same filtering than helm CLI
 // Ref: https://github.com/helm/helm/blob/d3b69c1fc1ac62f1cc40f93fcd0cba275c0596de/cmd/helm/list.go#L173
 func filterList(rels []*release.Release) []*release.Release {
@@ -194,58 +409,61 @@ func (p *Proxy) ListReleases(ctx context.Context, namespace string, releaseListL
 	return appList, nil
 }
 
-func lock(name string) {
-	if appMutex[name] == nil {
-		appMutex[name] = &sync.Mutex{}
-	}
-	appMutex[name].Lock()
-}
-
-func unlock(name string) {
-	appMutex[name].Unlock()
-}
-
 // CreateRelease creates a tiller release
-func (p *Proxy) CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, vo ValidationObject) (*release.Release, error) {
-	lock(name)
-	defer unlock(name)
+func (p *Proxy) CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*release.Release, error) {
 
 	log := logUtils.GetLogger(ctx)
 
 	log.Printf("Installing release %s into namespace %s", name, namespace)
 
+	// The lock of the release is created in its namespace
 	err := ensureNamespace(ctx, namespace, vo)
 	if err != nil {
 		return nil, err
 	}
+	ctx, unlock, err := p.lockRelease(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	p.rememberTarget(vo, namespace)
 
 	config := vo.initActionConfig(namespace)
+	setOperationLog(ctx, config)
+	guardReleaseLock(ctx, config)
 	log.Printf("Got action config")
 
 	install := action.NewInstall(config)
 	install.Namespace = namespace
 	install.ReleaseName = name
+	install.Wait = options.Wait
+	install.Atomic = options.Atomic
+	install.Timeout = options.Timeout()
+	install.DisableHooks = options.DisableHooks
+	install.PostRenderer = newPatchPostRenderer(options.Patches)
 
 	valOpts, err := getValueMap(values)
 	if err != nil {
 		return nil, err
 	}
 
+	// The image pull secrets have to exist before the pods of the release are created. Only the secrets
+	// acquired here are released if the install fails, the name might be used by an existing release.
+	acquired, err := p.syncImagePullSecrets(ctx, vo, &release.Release{Name: name, Namespace: namespace, Chart: ch, Config: valOpts}, false)
+	if err != nil {
+		p.undoImagePullSecrets(ctx, vo, namespace, name, acquired)
+		return nil, err
+	}
+
 	log.Printf("Installing chart %s", name)
 	res, err := install.Run(ch, valOpts)
 	if err != nil {
+		p.undoImagePullSecrets(ctx, vo, namespace, name, acquired)
 		return nil, errors.Wrapf(err, "Unable to create the release")
 	}
-
-	hubsec, enabled := os.LookupEnv("HUBSEC_DOCKERCONFIGJSON")
-	log.Printf("Secret configured: %t", enabled)
-	if enabled {
-		log.Printf("Secret configured: %s", hubsec)
-		imageSecret := newImageSecret(ctx, res, hubsec, vo)
-		err = imageSecret.createOrUpdateImageSecret(ctx)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Unable to create image pull secret")
-		}
+	policy := p.retentionPolicy(ctx, vo, namespace)
+	if err := p.storeReleasePatches(ctx, vo, res, options.Patches, policy.MaxHistory); err != nil {
+		return nil, err
 	}
 
 	log.Printf("%s successfully installed in %s", name, namespace)
@@ -261,107 +479,290 @@ func getValueMap(values string) (map[string]interface{}, error) {
 	return valuesMap, nil
 }
 
+// EffectiveValues returns the values a release is rendered with, the values supplied by the user
+// coalesced with the defaults of the chart
+func EffectiveValues(rel *release.Release) (map[string]interface{}, error) {
+	if rel.Chart == nil {
+		return rel.Config, nil
+	}
+	values, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not compute values of release %s", rel.Name)
+	}
+	return values, nil
+}
+
 // UpdateRelease upgrades a tiller release
-func (p *Proxy) UpdateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, vo ValidationObject) (*release.Release, error) {
-	lock(name)
-	defer unlock(name)
+func (p *Proxy) UpdateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*release.Release, error) {
+	ctx, unlock, err := p.lockRelease(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	p.rememberTarget(vo, namespace)
 
 	log := logUtils.GetLogger(ctx)
 
 	// Check if the release already exists
-	_, err := p.getRelease(vo, name, namespace)
+	current, err := p.getRelease(vo, name, namespace)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Updating release %s", name)
 
+	patches, err := p.releasePatches(ctx, vo, current, options.Patches)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := p.retentionPolicy(ctx, vo, namespace)
 	config := vo.initActionConfig(namespace)
+	setOperationLog(ctx, config)
+	guardReleaseLock(ctx, config)
 
 	upgrade := action.NewUpgrade(config)
 	upgrade.Namespace = namespace
-	upgrade.MaxHistory = 10
+	upgrade.MaxHistory = policy.MaxHistory
+	upgrade.Wait = options.Wait
+	upgrade.Atomic = options.Atomic
+	upgrade.Timeout = options.Timeout()
+	upgrade.DisableHooks = options.DisableHooks
+	upgrade.Force = options.Force
+	upgrade.ReuseValues = options.ReuseValues
+	upgrade.ResetValues = options.ResetValues
+	upgrade.PostRenderer = newPatchPostRenderer(patches)
 
 	valOpts, err := getValueMap(values)
 	if err != nil {
 		return nil, err
 	}
 
+	// The secrets no longer requested are only released if the upgrade succeeds
+	requested, err := getValueMap(values)
+	if err != nil {
+		return nil, err
+	}
+	if options.ReuseValues {
+		requested = chartutil.CoalesceTables(requested, current.Config)
+	}
+	acquired, err := p.syncImagePullSecrets(ctx, vo, &release.Release{Name: name, Namespace: namespace, Chart: ch, Config: requested}, false)
+	if err != nil {
+		p.undoImagePullSecrets(ctx, vo, namespace, name, acquired)
+		return nil, err
+	}
+
 	rel, err := upgrade.Run(name, ch, valOpts)
 
 	if err != nil {
+		p.undoImagePullSecrets(ctx, vo, namespace, name, acquired)
 		return nil, errors.Wrap(err, "Unable to update the release")
 	}
+	if err := p.storeReleasePatches(ctx, vo, rel, patches, policy.MaxHistory); err != nil {
+		return nil, err
+	}
+	if _, err := p.syncImagePullSecrets(ctx, vo, rel, true); err != nil {
+		return nil, err
+	}
+	p.pruneRelease(ctx, vo, name, namespace, policy)
 	return rel, err
 }
 
 // RollbackRelease rolls back to a specific revision
 func (p *Proxy) RollbackRelease(ctx context.Context, name, namespace string, revision int32, vo ValidationObject) (*release.Release, error) {
-	lock(name)
-	defer unlock(name)
+	ctx, unlock, err := p.lockRelease(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	p.rememberTarget(vo, namespace)
 	// Check if the release already exists
 	config := vo.initActionConfig(namespace)
+	guardReleaseLock(ctx, config)
 
-	_, err := p.getRelease(vo, name, namespace)
+	current, err := p.getRelease(vo, name, namespace)
 	if err != nil {
 		return nil, err
 	}
 
+	// The new revision has the manifest and therefore the patches of the revision rolled back to
+	target := int(revision)
+	if target == 0 {
+		target = current.Version - 1
+	}
+	patches, err := p.releasePatches(ctx, vo, &release.Release{Name: name, Namespace: namespace, Version: target}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := p.retentionPolicy(ctx, vo, namespace)
 	rollbackCommand := action.NewRollback(config)
 	rollbackCommand.Version = int(revision)
+	rollbackCommand.MaxHistory = policy.MaxHistory
 	err = rollbackCommand.Run(name)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Unable to rollback the release")
 	}
-	return p.getRelease(vo, name, namespace)
+	rel, err := p.getRelease(vo, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.storeReleasePatches(ctx, vo, rel, patches, policy.MaxHistory); err != nil {
+		return nil, err
+	}
+	if _, err := p.syncImagePullSecrets(ctx, vo, rel, true); err != nil {
+		return nil, err
+	}
+	p.pruneRelease(ctx, vo, name, namespace, policy)
+	return rel, nil
+}
+
+// releasePatches returns the requested patches, or the patches of the revision of the release if
+// none are requested
+func (p *Proxy) releasePatches(ctx context.Context, vo ValidationObject, rel *release.Release, requested []ReleasePatch) ([]ReleasePatch, error) {
+	if requested != nil {
+		return requested, nil
+	}
+	clientset, err := vo.getClientSet(rel.Namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", rel.Namespace)
+	}
+	return getReleasePatches(ctx, clientset.CoreV1().ConfigMaps(rel.Namespace), rel.Name, rel.Version)
+}
+
+// syncImagePullSecrets ensures the image pull secrets requested by the values of the release, see
+// ImagePullSecretManager. If prune is set, the secrets which are no longer requested are released.
+// It returns the secrets the release did not use before.
+func (p *Proxy) syncImagePullSecrets(ctx context.Context, vo ValidationObject, rel *release.Release, prune bool) ([]string, error) {
+	if p.ImagePullSecrets == nil {
+		return nil, nil
+	}
+	clientset, err := vo.getClientSet(rel.Namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", rel.Namespace)
+	}
+	return p.ImagePullSecrets.syncRelease(ctx, clientset, rel, prune)
+}
+
+// undoImagePullSecrets releases the image pull secrets acquired by a failed operation. Failures are
+// only logged since the operation already failed.
+func (p *Proxy) undoImagePullSecrets(ctx context.Context, vo ValidationObject, namespace, name string, acquired []string) {
+	if p.ImagePullSecrets == nil || len(acquired) == 0 {
+		return
+	}
+	clientset, err := vo.getClientSet(namespace)
+	if err == nil {
+		err = p.ImagePullSecrets.releaseSome(ctx, clientset, namespace, name, acquired)
+	}
+	if err != nil {
+		logUtils.GetLogger(ctx).Errorf("Could not release image pull secrets of release %s: %v", name, err)
+	}
+}
+
+// releaseImagePullSecrets releases the image pull secrets used by a release
+func (p *Proxy) releaseImagePullSecrets(ctx context.Context, vo ValidationObject, namespace, name string) error {
+	if p.ImagePullSecrets == nil {
+		return nil
+	}
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+	return p.ImagePullSecrets.releaseAll(ctx, clientset, namespace, name)
+}
+
+// PropagateImagePullSecrets updates the image pull secrets in the remembered target namespaces whose
+// registry credentials changed, see TargetNamespaces
+func (p *Proxy) PropagateImagePullSecrets(ctx context.Context) {
+	if p.ImagePullSecrets == nil {
+		return
+	}
+	p.forEachTarget(ctx, "propagate registry credentials", func(ctx context.Context, target targetNamespace) error {
+		clientset, err := target.vo.getClientSet(target.namespace)
+		if err != nil {
+			return errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", target.namespace)
+		}
+		return p.ImagePullSecrets.Propagate(ctx, clientset, target.namespace)
+	})
+}
+
+// StartImagePullSecretPropagation propagates changed registry credentials to the remembered target
+// namespaces in the interval until the context is done
+func (p *Proxy) StartImagePullSecretPropagation(ctx context.Context, interval time.Duration) {
+	runInInterval(ctx, interval, p.PropagateImagePullSecrets)
+}
+
+// storeReleasePatches stores the patches applied to the revision of the release
+func (p *Proxy) storeReleasePatches(ctx context.Context, vo ValidationObject, rel *release.Release, patches []ReleasePatch, maxHistory int) error {
+	clientset, err := vo.getClientSet(rel.Namespace)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", rel.Namespace)
+	}
+	return saveReleasePatches(ctx, clientset.CoreV1().ConfigMaps(rel.Namespace), rel.Name, rel.Namespace, rel.Version, maxHistory, patches)
 }
 
 // GetRelease returns the info of a release
 func (p *Proxy) GetRelease(ctx context.Context, name, namespace string, vo ValidationObject) (*release.Release, error) {
-	lock(name)
-	defer unlock(name)
 	return p.getRelease(vo, name, namespace)
 }
 
-// DeleteRelease deletes a release
-func (p *Proxy) DeleteRelease(ctx context.Context, name, namespace string, keepHistory bool, vo ValidationObject) error {
-	lock(name)
-	defer unlock(name)
+// DeleteRelease deletes a release. Its history is kept if keepHistory is true, the retention policy
+// of the namespace decides if keepHistory is nil.
+func (p *Proxy) DeleteRelease(ctx context.Context, name, namespace string, keepHistory *bool, vo ValidationObject) error {
+	ctx, unlock, err := p.lockRelease(ctx, vo, namespace, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	p.rememberTarget(vo, namespace)
 
 	log := logUtils.GetLogger(ctx)
 
 	log.Printf("Deleting release %s in namespace %s", name, namespace)
 	// Validate that the release actually belongs to the namespace
-	_, err := p.getRelease(vo, name, namespace)
+	_, err = p.getRelease(vo, name, namespace)
 	if err != nil {
 		return err
 	}
 
+	policy := p.retentionPolicy(ctx, vo, namespace)
+	keep := policy.KeepUninstalled
+	if keepHistory != nil {
+		keep = *keepHistory
+	}
+
 	config := vo.initActionConfig(namespace)
+	guardReleaseLock(ctx, config)
 	uninstall := action.NewUninstall(config)
-	uninstall.KeepHistory = keepHistory
+	uninstall.KeepHistory = keep
 
-	rel, uninstallErr := uninstall.Run(name)
+	res, uninstallErr := uninstall.Run(name)
 	if uninstallErr != nil {
 		return errors.Wrap(uninstallErr, "Unable to delete the release")
 	}
-
-	hubsec, enabled := os.LookupEnv("HUBSEC_DOCKERCONFIGJSON")
-	log.Printf("Secret configured: %t", enabled)
-	if enabled {
-		log.Printf("Secret configured: %s", hubsec)
-		overviews, listErr := p.ListReleases(ctx, namespace, 0, "all", vo)
-		if listErr != nil {
-			return errors.Wrap(listErr, "Unable to list release to check if imagepullsecret has to be deleted")
+	// A history kept on request is excluded from pruning, unlike a history kept by the policy
+	if keepHistory != nil && *keepHistory {
+		clientset, err := vo.getClientSet(namespace)
+		if err == nil {
+			err = markHistoryKept(ctx, clientset, res.Release)
 		}
-		if len(overviews) == 0 {
-			imageSecrets := newImageSecret(ctx, rel.Release, hubsec, vo)
-			err = imageSecrets.deleteImageSecret(ctx)
-			if err != nil {
-				return errors.Wrap(err, "Unable to delete image pull secret")
-			}
+		if err != nil {
+			log.Errorf("Could not keep the history of release %s in namespace %s: %v", name, namespace, err)
+		}
+	}
+	if !keep {
+		clientset, err := vo.getClientSet(namespace)
+		if err != nil {
+			return errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+		}
+		if err := deleteReleasePatches(ctx, clientset.CoreV1().ConfigMaps(namespace), name); err != nil {
+			return err
 		}
 	}
 
+	if err := p.releaseImagePullSecrets(ctx, vo, namespace, name); err != nil {
+		return err
+	}
+
 	log.Printf("%s successfully deleted in %s", name, namespace)
 
 	return err
@@ -418,10 +819,18 @@ type TillerClient interface {
 	GetReleaseStatus(ctx context.Context, namespace string, relName string, vo ValidationObject) (release.Status, error)
 	ResolveManifest(ctx context.Context, namespace, values string, ch *chart.Chart, vo ValidationObject) (string, error)
 	ResolveManifestFromRelease(ctx context.Context, namespace string, releaseName string, revision int32, vo ValidationObject) (string, error)
+	PreviewRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*ReleasePreview, error)
+	GetReleaseHistory(ctx context.Context, name, namespace string, vo ValidationObject) ([]ReleaseRevision, error)
+	GetReleaseHealth(ctx context.Context, rel *release.Release, requirements hubv1.ReadyRequirements, vo ValidationObject) (*health.ReleaseHealth, error)
+	DiffReleaseRevisions(ctx context.Context, name, namespace string, from, to int, vo ValidationObject) (*RevisionDiff, error)
+	StartOperation(ctx context.Context, operationType, name, namespace string, vo ValidationObject, run OperationFunc) (*Operation, error)
+	GetOperation(ctx context.Context, id string, vo ValidationObject) (*Operation, error)
+	RunReleaseTests(ctx context.Context, name, namespace string, timeout time.Duration, vo ValidationObject) (*TestRun, error)
+	GetReleaseTestRun(ctx context.Context, name, namespace string, vo ValidationObject) (*TestRun, error)
 	ListReleases(ctx context.Context, namespace string, releaseListLimit int, status string, vo ValidationObject) ([]AppOverview, error)
-	CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, vo ValidationObject) (*release.Release, error)
-	UpdateRelease(ctx context.Context, name, namespace string, values string, ch *chart.Chart, vo ValidationObject) (*release.Release, error)
+	CreateRelease(ctx context.Context, name, namespace, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*release.Release, error)
+	UpdateRelease(ctx context.Context, name, namespace string, values string, ch *chart.Chart, options ReleaseOptions, vo ValidationObject) (*release.Release, error)
 	RollbackRelease(ctx context.Context, name, namespace string, revision int32, vo ValidationObject) (*release.Release, error)
 	GetRelease(ctx context.Context, name, namespace string, vo ValidationObject) (*release.Release, error)
-	DeleteRelease(ctx context.Context, name, namespace string, keepHistory bool, vo ValidationObject) error
+	DeleteRelease(ctx context.Context, name, namespace string, keepHistory *bool, vo ValidationObject) error
 }
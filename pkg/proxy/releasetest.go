@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+const (
+	// Phases of a test run
+	TestRunSucceeded = "succeeded"
+	TestRunFailed    = "failed"
+
+	// testRunLabel marks the config maps containing the results of test runs
+	testRunLabel      = "hub.k8s.sap.com/test-run"
+	testRunDataKey    = "testrun.json"
+	testRunNamePrefix = "hub-test-"
+
+	// maxTestLogBytes limits the logs kept per test pod so that the results fit into a config map,
+	// the beginning of longer logs is dropped
+	maxTestLogBytes = 64 * 1024
+)
+
+// TestRun is the result of running the test hooks of a release
+type TestRun struct {
+	ReleaseName string     `json:"releaseName"`
+	Namespace   string     `json:"namespace"`
+	Revision    int        `json:"revision"`
+	Phase       string     `json:"phase"`
+	StartedAt   time.Time  `json:"startedAt"`
+	CompletedAt time.Time  `json:"completedAt"`
+	Error       string     `json:"error,omitempty"`
+	Hooks       []TestHook `json:"hooks"`
+}
+
+// TestHook is the result of a test hook of a release
+type TestHook struct {
+	Name        string            `json:"name"`
+	Kind        string            `json:"kind"`
+	Phase       release.HookPhase `json:"phase"`
+	StartedAt   *time.Time        `json:"startedAt,omitempty"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+	// Logs are the logs of the test pod, they are empty for other kinds of hooks
+	Logs string `json:"logs,omitempty"`
+	// LogError is set if the logs of the test pod could not be read, e.g. because the pod was deleted
+	LogError string `json:"logError,omitempty"`
+}
+
+// RunReleaseTests runs the test hooks of a release, waits until they are finished or the timeout
+// is exceeded and stores the result. Failed tests are reported in the result, not as an error. The
+// release is locked while the test hooks run, since they update the release.
+func (p *Proxy) RunReleaseTests(ctx context.Context, name, namespace string, timeout time.Duration, vo ValidationObject) (*TestRun, error) {
+	ctx, unlock, err := p.lockRelease(ctx, vo, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	config := vo.initActionConfig(namespace)
+	guardReleaseLock(ctx, config)
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+
+	tester := action.NewReleaseTesting(config)
+	tester.Namespace = namespace
+	tester.Timeout = timeout
+
+	startedAt := time.Now().UTC()
+	rel, runErr := tester.Run(name)
+	if rel == nil {
+		return nil, errors.New(prettyError(runErr).Error())
+	}
+
+	run := newTestRun(ctx, rel, clientset.CoreV1().Pods(namespace), runErr)
+	run.StartedAt = startedAt
+	run.CompletedAt = time.Now().UTC()
+
+	if err := saveTestRun(ctx, clientset.CoreV1().ConfigMaps(namespace), run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// GetReleaseTestRun returns the result of the most recent test run of a release
+func (p *Proxy) GetReleaseTestRun(ctx context.Context, name, namespace string, vo ValidationObject) (*TestRun, error) {
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", namespace)
+	}
+	return getTestRun(ctx, clientset.CoreV1().ConfigMaps(namespace), name)
+}
+
+// newTestRun collects the results and pod logs of the test hooks of a tested release
+func newTestRun(ctx context.Context, rel *release.Release, pods typedCoreV1.PodInterface, runErr error) *TestRun {
+	run := &TestRun{
+		ReleaseName: rel.Name,
+		Namespace:   rel.Namespace,
+		Revision:    rel.Version,
+		Phase:       TestRunSucceeded,
+		Hooks:       []TestHook{},
+	}
+	if runErr != nil {
+		run.Phase = TestRunFailed
+		run.Error = runErr.Error()
+	}
+
+	for _, hook := range rel.Hooks {
+		if !isTestHook(hook) {
+			continue
+		}
+		testHook := TestHook{
+			Name:  hook.Name,
+			Kind:  hook.Kind,
+			Phase: hook.LastRun.Phase,
+		}
+		if !hook.LastRun.StartedAt.IsZero() {
+			startedAt := hook.LastRun.StartedAt.Time
+			testHook.StartedAt = &startedAt
+		}
+		if !hook.LastRun.CompletedAt.IsZero() {
+			completedAt := hook.LastRun.CompletedAt.Time
+			testHook.CompletedAt = &completedAt
+		}
+		if hook.LastRun.Phase != release.HookPhaseSucceeded {
+			run.Phase = TestRunFailed
+		}
+		if hook.Kind == "Pod" && hook.LastRun.Phase != release.HookPhaseUnknown {
+			logs, err := readPodLogs(ctx, pods, hook.Name)
+			if err != nil {
+				testHook.LogError = err.Error()
+			}
+			testHook.Logs = logs
+		}
+		run.Hooks = append(run.Hooks, testHook)
+	}
+	return run
+}
+
+func isTestHook(hook *release.Hook) bool {
+	for _, event := range hook.Events {
+		if event == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
+
+// readPodLogs returns the end of the logs of a pod
+func readPodLogs(ctx context.Context, pods typedCoreV1.PodInterface, name string) (string, error) {
+	stream, err := pods.GetLogs(name, &corev1.PodLogOptions{}).Stream(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "Could not get logs of pod %s", name)
+	}
+	defer stream.Close()
+
+	logs, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return "", errors.Wrapf(err, "Could not read logs of pod %s", name)
+	}
+	if len(logs) > maxTestLogBytes {
+		logs = logs[len(logs)-maxTestLogBytes:]
+	}
+	return string(logs), nil
+}
+
+// saveTestRun stores the result of a test run, replacing the result of the previous run
+func saveTestRun(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, run *TestRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal test run")
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testRunNamePrefix + run.ReleaseName,
+			Namespace: run.Namespace,
+			Labels:    map[string]string{testRunLabel: "true"},
+		},
+		Data: map[string]string{testRunDataKey: string(data)},
+	}
+
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if k8sErrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	return errors.Wrapf(err, "Could not store test run of release %s", run.ReleaseName)
+}
+
+func getTestRun(ctx context.Context, configMaps typedCoreV1.ConfigMapInterface, name string) (*TestRun, error) {
+	configMap, err := configMaps.Get(ctx, testRunNamePrefix+name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil, errorUtils.NotFound.NewErrorf("No test run of release %q found", name)
+		}
+		return nil, errors.Wrapf(err, "Could not get test run of release %s", name)
+	}
+	run := &TestRun{}
+	if err := json.Unmarshal([]byte(configMap.Data[testRunDataKey]), run); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse test run of release %s", name)
+	}
+	return run, nil
+}
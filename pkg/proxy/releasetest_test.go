@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	helmTime "helm.sh/helm/v3/pkg/time"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+)
+
+func TestNewTestRun(t *testing.T) {
+	now := helmTime.Now()
+	rel := &release.Release{
+		Name:      "foo",
+		Namespace: "default",
+		Version:   2,
+		Hooks: []*release.Hook{
+			{Name: "foo-pre-install", Kind: "Job", Events: []release.HookEvent{release.HookPreInstall}},
+			{
+				Name:    "foo-test-connection",
+				Kind:    "Pod",
+				Events:  []release.HookEvent{release.HookTest},
+				LastRun: release.HookExecution{StartedAt: now, CompletedAt: now, Phase: release.HookPhaseSucceeded},
+			},
+			{
+				Name:    "foo-test-config",
+				Kind:    "ConfigMap",
+				Events:  []release.HookEvent{release.HookTest},
+				LastRun: release.HookExecution{StartedAt: now, CompletedAt: now, Phase: release.HookPhaseSucceeded},
+			},
+		},
+	}
+	pods := fake.NewSimpleClientset().CoreV1().Pods("default")
+
+	run := newTestRun(context.TODO(), rel, pods, nil)
+	if run.Phase != TestRunSucceeded || run.Revision != 2 {
+		t.Errorf("got: %s revision %d, want: %s revision %d", run.Phase, run.Revision, TestRunSucceeded, 2)
+	}
+	if len(run.Hooks) != 2 {
+		t.Fatalf("got: %d hooks, want: 2 hooks", len(run.Hooks))
+	}
+	// The fake clientset returns "fake logs" for all pods
+	if run.Hooks[0].Logs != "fake logs" || run.Hooks[0].StartedAt == nil {
+		t.Errorf("got: %+v, want: hook with logs and start time", run.Hooks[0])
+	}
+	if run.Hooks[1].Logs != "" {
+		t.Errorf("got: %q, want: no logs", run.Hooks[1].Logs)
+	}
+
+	rel.Hooks[1].LastRun.Phase = release.HookPhaseFailed
+	run = newTestRun(context.TODO(), rel, pods, errors.New("pod foo-test-connection failed"))
+	if run.Phase != TestRunFailed || run.Error != "pod foo-test-connection failed" {
+		t.Errorf("got: %s %q, want: %s with error", run.Phase, run.Error, TestRunFailed)
+	}
+}
+
+func TestSaveTestRun(t *testing.T) {
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+
+	_, err := getTestRun(context.TODO(), configMaps, "foo")
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.NotFound {
+		t.Errorf("got: %v, want: not found", err)
+	}
+
+	for revision := 1; revision <= 2; revision++ {
+		run := &TestRun{ReleaseName: "foo", Namespace: "default", Revision: revision, Phase: TestRunSucceeded, Hooks: []TestHook{}}
+		if err := saveTestRun(context.TODO(), configMaps, run); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	run, err := getTestRun(context.TODO(), configMaps, "foo")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if run.Revision != 2 {
+		t.Errorf("got: %d, want: %d", run.Revision, 2)
+	}
+}
+
+func TestRunReleaseTestsLocksRelease(t *testing.T) {
+	ctx := operationTestContext()
+	vo := newTestValidation()
+	rel := &release.Release{Name: "foo", Namespace: "default", Version: 1, Info: &release.Info{Status: release.StatusDeployed}}
+	if err := vo.releases.Create(rel); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	leases := vo.clientset.CoordinationV1().Leases("default")
+	p := &Proxy{LockWaitTimeout: 10 * time.Millisecond}
+
+	lock, err := acquireReleaseLock(ctx, leases, "default", "foo", "other", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, err = p.RunReleaseTests(ctx, "foo", "default", time.Minute, vo)
+	if got, _ := errorUtils.GetHTTPErrorType(err); got != errorUtils.Conflict {
+		t.Errorf("got: %v, want: conflict", err)
+	}
+	lock.release(ctx)
+
+	run, err := p.RunReleaseTests(ctx, "foo", "default", time.Minute, vo)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if run.Phase != TestRunSucceeded {
+		t.Errorf("got: %s, want: %s", run.Phase, TestRunSucceeded)
+	}
+	if _, err := leases.Get(ctx, releaseLockNamePrefix+"foo", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: lease of foo, want: released lock")
+	}
+}
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	errorUtils "github.com/gardener/potter-hub/pkg/errors"
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+const (
+	// DefaultReleaseMaxHistory is the default number of revisions kept of a release
+	DefaultReleaseMaxHistory = 10
+
+	// Labels of namespaces overriding the retention policy of the backend for the releases in the namespace
+	ReleaseMaxHistoryLabel       = "hub.k8s.sap.com/release-max-history"
+	ReleaseMaxAgeLabel           = "hub.k8s.sap.com/release-max-age"
+	KeepUninstalledReleasesLabel = "hub.k8s.sap.com/keep-uninstalled-releases"
+
+	// KeepHistoryLabel marks the stored revision of a release whose history was kept on request when it
+	// was uninstalled, the history is excluded from pruning
+	KeepHistoryLabel = "hub.k8s.sap.com/keep-history"
+)
+
+// RetentionPolicy limits the history kept of releases
+type RetentionPolicy struct {
+	// MaxHistory is the maximum number of revisions of a release, 0 for no limit
+	MaxHistory int
+	// MaxAge is the age after which superseded revisions and the history of uninstalled releases
+	// are deleted, 0 for no limit
+	MaxAge time.Duration
+	// KeepUninstalled keeps the history of uninstalled releases unless the uninstall requests otherwise
+	KeepUninstalled bool
+}
+
+// retentionPolicy returns the retention policy of the releases in a namespace, which is the policy
+// of the proxy overridden by the labels of the namespace. Invalid labels are ignored.
+func (p *Proxy) retentionPolicy(ctx context.Context, vo ValidationObject, namespace string) RetentionPolicy {
+	log := logUtils.GetLogger(ctx)
+	policy := p.Retention
+
+	clientset, err := vo.getClientSet(namespace)
+	if err != nil {
+		log.Warnf("Could not read retention policy of namespace %s: %v", namespace, err)
+		return policy
+	}
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			log.Warnf("Could not read retention policy of namespace %s: %v", namespace, err)
+		}
+		return policy
+	}
+	policy, errs := policy.withLabels(ns.Labels)
+	for _, err := range errs {
+		log.Warnf("Ignoring retention policy of namespace %s: %v", namespace, err)
+	}
+	return policy
+}
+
+// withLabels returns the policy overridden by the labels of a namespace
+func (r RetentionPolicy) withLabels(labels map[string]string) (RetentionPolicy, []error) {
+	errs := []error{}
+	if value, ok := labels[ReleaseMaxHistoryLabel]; ok {
+		maxHistory, err := strconv.Atoi(value)
+		if err == nil && maxHistory >= 0 {
+			r.MaxHistory = maxHistory
+		} else {
+			errs = append(errs, errors.Errorf("invalid value %q of label %s", value, ReleaseMaxHistoryLabel))
+		}
+	}
+	if value, ok := labels[ReleaseMaxAgeLabel]; ok {
+		maxAge, err := time.ParseDuration(value)
+		if err == nil && maxAge >= 0 {
+			r.MaxAge = maxAge
+		} else {
+			errs = append(errs, errors.Errorf("invalid value %q of label %s", value, ReleaseMaxAgeLabel))
+		}
+	}
+	if value, ok := labels[KeepUninstalledReleasesLabel]; ok {
+		keepUninstalled, err := strconv.ParseBool(value)
+		if err == nil {
+			r.KeepUninstalled = keepUninstalled
+		} else {
+			errs = append(errs, errors.Errorf("invalid value %q of label %s", value, KeepUninstalledReleasesLabel))
+		}
+	}
+	return r, errs
+}
+
+// revisionsToPrune returns the revisions of the history of a release which are not retained by the
+// policy. The current revision and deployed revisions of installed releases are always retained,
+// the history of uninstalled releases is pruned entirely if it is not kept or older than the max age.
+func (r RetentionPolicy) revisionsToPrune(history []*release.Release, now time.Time) []*release.Release {
+	if len(history) == 0 {
+		return nil
+	}
+	revisions := make([]*release.Release, len(history))
+	copy(revisions, history)
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Version < revisions[j].Version })
+	current := revisions[len(revisions)-1]
+
+	if current.Info != nil && current.Info.Status == release.StatusUninstalled {
+		deleted := current.Info.Deleted.Time
+		if deleted.IsZero() {
+			deleted = current.Info.LastDeployed.Time
+		}
+		if !r.KeepUninstalled || (r.MaxAge > 0 && now.Sub(deleted) > r.MaxAge) {
+			return revisions
+		}
+	}
+
+	prunable := []*release.Release{}
+	for _, rev := range revisions[:len(revisions)-1] {
+		if rev.Info != nil && rev.Info.Status == release.StatusDeployed {
+			continue
+		}
+		prunable = append(prunable, rev)
+	}
+
+	pruned := []*release.Release{}
+	excess := 0
+	if r.MaxHistory > 0 && len(revisions) > r.MaxHistory {
+		excess = len(revisions) - r.MaxHistory
+	}
+	for i, rev := range prunable {
+		expired := r.MaxAge > 0 && rev.Info != nil && now.Sub(rev.Info.LastDeployed.Time) > r.MaxAge
+		if i < excess || expired {
+			pruned = append(pruned, rev)
+		}
+	}
+	return pruned
+}
+
+// PruneReleaseHistories deletes the revisions of the releases in the remembered target namespaces which
+// are not retained by the policy, see TargetNamespaces
+func (p *Proxy) PruneReleaseHistories(ctx context.Context) {
+	p.forEachTarget(ctx, "prune release histories", func(ctx context.Context, target targetNamespace) error {
+		clientset, err := target.vo.getClientSet(target.namespace)
+		if err != nil {
+			return errors.Wrapf(err, "Error creating kubernetes client for namespace %s.", target.namespace)
+		}
+		policy := p.retentionPolicy(ctx, target.vo, target.namespace)
+		return p.pruneNamespace(ctx, target.vo.initActionConfig(target.namespace).Releases, clientset, target.namespace, policy)
+	})
+}
+
+// StartReleasePruner prunes the histories of the releases in the remembered target namespaces in the
+// interval until the context is done
+func (p *Proxy) StartReleasePruner(ctx context.Context, interval time.Duration) {
+	runInInterval(ctx, interval, p.PruneReleaseHistories)
+}
+
+// pruneNamespace prunes the histories of the releases in a namespace. Releases locked by other
+// operations and releases whose history was kept on request are skipped.
+func (p *Proxy) pruneNamespace(ctx context.Context, releases *storage.Storage, clientset kubernetes.Interface, namespace string, policy RetentionPolicy) error {
+	log := logUtils.GetLogger(ctx)
+
+	kept, err := keptHistories(ctx, clientset, namespace)
+	if err != nil {
+		return err
+	}
+	revisions, err := releases.ListReleases()
+	if err != nil {
+		return errors.Wrap(err, "Could not list releases")
+	}
+	histories := map[string][]*release.Release{}
+	current := map[string]int{}
+	for _, rev := range revisions {
+		histories[rev.Name] = append(histories[rev.Name], rev)
+		if rev.Version > current[rev.Name] {
+			current[rev.Name] = rev.Version
+		}
+	}
+
+	now := time.Now()
+	for name, history := range histories {
+		if kept[name] == current[name] || len(policy.revisionsToPrune(history, now)) == 0 {
+			continue
+		}
+		err := p.pruneReleaseHistory(ctx, releases, clientset, name, namespace, policy)
+		if errorType, ok := errorUtils.GetHTTPErrorType(err); ok && errorType == errorUtils.Conflict {
+			log.Infof("Skipping pruning of locked release %s in namespace %s", name, namespace)
+			continue
+		}
+		if err != nil {
+			log.Errorf("Could not prune history of release %s in namespace %s: %v", name, namespace, err)
+		}
+	}
+	return nil
+}
+
+// pruneRelease prunes the history of a release after an operation created a new revision of it, the
+// history of the other releases in the namespace is left to the release pruner. Failures are only
+// logged, they do not fail the operation.
+func (p *Proxy) pruneRelease(ctx context.Context, vo ValidationObject, name, namespace string, policy RetentionPolicy) {
+	clientset, err := vo.getClientSet(namespace)
+	if err == nil {
+		err = p.pruneReleaseHistory(ctx, vo.initActionConfig(namespace).Releases, clientset, name, namespace, policy)
+	}
+	if err != nil {
+		logUtils.GetLogger(ctx).Errorf("Could not prune history of release %s in namespace %s: %v", name, namespace, err)
+	}
+}
+
+// pruneReleaseHistory deletes the revisions of a release which are not retained by the policy
+func (p *Proxy) pruneReleaseHistory(ctx context.Context, releases *storage.Storage, clientset kubernetes.Interface, name, namespace string, policy RetentionPolicy) error {
+	unlock, err := p.tryLockRelease(ctx, clientset.CoordinationV1().Leases(namespace), namespace, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := releases.History(name)
+	if err != nil {
+		return errors.Wrapf(err, "Could not get history of release %s", name)
+	}
+	pruned := policy.revisionsToPrune(history, time.Now())
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	for _, rev := range pruned {
+		if _, err := releases.Delete(name, rev.Version); err != nil {
+			return errors.Wrapf(err, "Could not delete revision %d of release %s", rev.Version, name)
+		}
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	if len(pruned) == len(history) {
+		err = deleteReleasePatches(ctx, configMaps, name)
+	} else {
+		err = dropReleasePatches(ctx, configMaps, name, pruned)
+	}
+	if err != nil {
+		return err
+	}
+	logUtils.GetLogger(ctx).Infof("Pruned %d revisions of release %s in namespace %s", len(pruned), name, namespace)
+	return nil
+}
+
+// releaseStorageKey returns the name of the object storing a revision of a release, see the storage of helm
+func releaseStorageKey(name string, version int) string {
+	return fmt.Sprintf("%s.%s.v%d", storage.HelmStorageType, name, version)
+}
+
+// markHistoryKept labels the stored current revision of an uninstalled release whose history was kept
+// on request. A new revision of the release is not labeled and ends the exclusion from pruning.
+func markHistoryKept(ctx context.Context, clientset kubernetes.Interface, rel *release.Release) error {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, KeepHistoryLabel))
+	key := releaseStorageKey(rel.Name, rel.Version)
+	var err error
+	switch os.Getenv("HELM_DRIVER") {
+	case "secret", "secrets", "":
+		_, err = clientset.CoreV1().Secrets(rel.Namespace).Patch(ctx, key, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "configmap", "configmaps":
+		_, err = clientset.CoreV1().ConfigMaps(rel.Namespace).Patch(ctx, key, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	return errors.Wrapf(err, "Could not mark the history of release %s as kept", rel.Name)
+}
+
+// keptHistories returns the revisions of the releases in a namespace whose history was kept on request
+// by release name
+func keptHistories(ctx context.Context, clientset kubernetes.Interface, namespace string) (map[string]int, error) {
+	options := metav1.ListOptions{LabelSelector: KeepHistoryLabel + "=true,owner=helm"}
+	objects := []metav1.ObjectMeta{}
+	switch os.Getenv("HELM_DRIVER") {
+	case "secret", "secrets", "":
+		secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, options)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not list kept release histories")
+		}
+		for _, secret := range secrets.Items {
+			objects = append(objects, secret.ObjectMeta)
+		}
+	case "configmap", "configmaps":
+		configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, options)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not list kept release histories")
+		}
+		for _, configMap := range configMaps.Items {
+			objects = append(objects, configMap.ObjectMeta)
+		}
+	}
+
+	kept := map[string]int{}
+	for _, object := range objects {
+		version, err := strconv.Atoi(object.Labels["version"])
+		if err != nil {
+			continue
+		}
+		kept[object.Labels["name"]] = version
+	}
+	return kept, nil
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	helmTime "helm.sh/helm/v3/pkg/time"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRetentionPolicyWithLabels(t *testing.T) {
+	defaults := RetentionPolicy{MaxHistory: DefaultReleaseMaxHistory}
+	testCases := []struct {
+		name     string
+		labels   map[string]string
+		expected RetentionPolicy
+		errs     int
+	}{
+		{"no labels", nil, defaults, 0},
+		{
+			"all labels",
+			map[string]string{ReleaseMaxHistoryLabel: "3", ReleaseMaxAgeLabel: "720h", KeepUninstalledReleasesLabel: "true"},
+			RetentionPolicy{MaxHistory: 3, MaxAge: 720 * time.Hour, KeepUninstalled: true},
+			0,
+		},
+		{"unlimited history", map[string]string{ReleaseMaxHistoryLabel: "0"}, RetentionPolicy{}, 0},
+		{
+			"invalid labels",
+			map[string]string{ReleaseMaxHistoryLabel: "-1", ReleaseMaxAgeLabel: "30d", KeepUninstalledReleasesLabel: "yes"},
+			defaults,
+			3,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			policy, errs := defaults.withLabels(tc.labels)
+			if policy != tc.expected {
+				t.Errorf("got: %+v, want: %+v", policy, tc.expected)
+			}
+			if len(errs) != tc.errs {
+				t.Errorf("got: %d errors, want: %d", len(errs), tc.errs)
+			}
+		})
+	}
+}
+
+func revision(version int, status release.Status, age time.Duration, now time.Time) *release.Release {
+	return &release.Release{
+		Name:    "foo",
+		Version: version,
+		Info: &release.Info{
+			Status:       status,
+			LastDeployed: helmTime.Time{Time: now.Add(-age)},
+		},
+	}
+}
+
+func versions(revisions []*release.Release) []int {
+	result := []int{}
+	for _, rev := range revisions {
+		result = append(result, rev.Version)
+	}
+	return result
+}
+
+func TestRevisionsToPrune(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	history := []*release.Release{
+		revision(4, release.StatusFailed, 1*day, now),
+		revision(1, release.StatusSuperseded, 10*day, now),
+		revision(2, release.StatusSuperseded, 5*day, now),
+		revision(3, release.StatusDeployed, 2*day, now),
+	}
+	uninstalled := revision(5, release.StatusUninstalled, 3*day, now)
+	uninstalled.Info.Deleted = helmTime.Time{Time: now.Add(-2 * day)}
+
+	testCases := []struct {
+		name     string
+		policy   RetentionPolicy
+		history  []*release.Release
+		expected []int
+	}{
+		{"no limits", RetentionPolicy{}, history, []int{}},
+		{"max history", RetentionPolicy{MaxHistory: 3}, history, []int{1}},
+		{"max history below the deployed revision", RetentionPolicy{MaxHistory: 1}, history, []int{1, 2}},
+		{"max age", RetentionPolicy{MaxAge: 3 * day}, history, []int{1, 2}},
+		{"uninstalled", RetentionPolicy{}, append(history, uninstalled), []int{1, 2, 3, 4, 5}},
+		{"kept uninstalled", RetentionPolicy{KeepUninstalled: true, MaxHistory: 4}, append(history, uninstalled), []int{1}},
+		{"expired uninstalled", RetentionPolicy{KeepUninstalled: true, MaxAge: day}, append(history, uninstalled), []int{1, 2, 3, 4, 5}},
+		{"empty history", RetentionPolicy{MaxHistory: 1}, nil, []int{}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := versions(tc.policy.revisionsToPrune(tc.history, now))
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got: %v, want: %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDropReleasePatches(t *testing.T) {
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("default")
+	patches := []ReleasePatch{{Patch: "metadata: {labels: {team: bar}}"}}
+	for revision := 1; revision <= 3; revision++ {
+		if err := saveReleasePatches(context.TODO(), configMaps, "foo", "default", revision, 0, patches); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	pruned := []*release.Release{{Name: "foo", Version: 1}, {Name: "foo", Version: 2}}
+	if err := dropReleasePatches(context.TODO(), configMaps, "foo", pruned); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	configMap, err := configMaps.Get(context.TODO(), releasePatchesNamePrefix+"foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(configMap.Data) != 1 || configMap.Data["3"] == "" {
+		t.Errorf("got: %v, want: patches of revision 3", configMap.Data)
+	}
+
+	// Releases without patches are skipped
+	if err := dropReleasePatches(context.TODO(), configMaps, "bar", pruned); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// The releases of the remembered namespaces are pruned with the credentials of the latest operation
+// in them, histories kept on request are skipped
+func TestPruneReleaseHistoriesOfTargetNamespaces(t *testing.T) {
+	ctx := operationTestContext()
+	now := time.Now()
+	vo := newTestValidation()
+	releases := vo.releases
+	clientset := vo.clientset
+	configMaps := clientset.CoreV1().ConfigMaps("default")
+
+	history := map[string][]release.Status{
+		"foo":  {release.StatusSuperseded, release.StatusSuperseded, release.StatusSuperseded, release.StatusDeployed},
+		"bar":  {release.StatusSuperseded, release.StatusSuperseded, release.StatusDeployed},
+		"baz":  {release.StatusSuperseded, release.StatusUninstalled},
+		"qux":  {release.StatusSuperseded, release.StatusUninstalled},
+		"quux": {release.StatusSuperseded, release.StatusUninstalled},
+	}
+	for name, statuses := range history {
+		for i, status := range statuses {
+			rev := revision(i+1, status, 0, now)
+			rev.Name = name
+			rev.Namespace = "default"
+			if err := releases.Create(rev); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if err := saveReleasePatches(ctx, configMaps, name, "default", i+1, 0, []ReleasePatch{{Patch: "metadata: {labels: {team: foo}}"}}); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	// The history of qux was kept on request, the one of quux before its last revision
+	for name, version := range map[string]int{"qux": 2, "quux": 1} {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:   releaseStorageKey(name, version),
+			Labels: map[string]string{"owner": "helm", "name": name, "version": strconv.Itoa(version)},
+		}}
+		if _, err := clientset.CoreV1().Secrets("default").Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := markHistoryKept(ctx, clientset, &release.Release{Name: name, Namespace: "default", Version: version}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	// bar is locked by another operation
+	lock, err := acquireReleaseLock(ctx, clientset.CoordinationV1().Leases("default"), "default", "bar", "other", 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer lock.release(ctx)
+
+	// The credentials of the other namespace are rejected
+	rejected := newTestValidation()
+	rejected.cluster = "other"
+	rejected.clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8sErrors.NewForbidden(corev1.Resource("secrets"), "", errors.New("forbidden"))
+	})
+
+	p := &Proxy{Retention: RetentionPolicy{MaxHistory: 2}, Targets: NewTargetNamespaces(10, time.Hour)}
+	p.rememberTarget(vo, "default")
+	p.rememberTarget(rejected, "other")
+	p.PruneReleaseHistories(ctx)
+
+	expected := map[string][]int{"foo": {3, 4}, "bar": {1, 2, 3}, "baz": {}, "qux": {1, 2}, "quux": {}}
+	for name, want := range expected {
+		revisions, err := releases.History(name)
+		if err != nil && len(want) > 0 {
+			t.Fatalf("%+v", err)
+		}
+		got := versions(revisions)
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v, want: %v revisions of %s", got, want, name)
+		}
+	}
+
+	configMap, err := configMaps.Get(ctx, releasePatchesNamePrefix+"foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(configMap.Data) != 2 || configMap.Data["3"] == "" || configMap.Data["4"] == "" {
+		t.Errorf("got: %v, want: patches of revisions 3 and 4", configMap.Data)
+	}
+	if _, err := configMaps.Get(ctx, releasePatchesNamePrefix+"baz", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: patches of baz, want: deleted patches")
+	}
+	if _, err := clientset.CoordinationV1().Leases("default").Get(ctx, releaseLockNamePrefix+"foo", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: lease of foo, want: released lock")
+	}
+
+	targets := p.Targets.list()
+	if len(targets) != 1 || targets[0].namespace != "default" {
+		t.Errorf("got: %v, want: only the namespace default remembered", targets)
+	}
+}
+
+func TestTargetNamespaces(t *testing.T) {
+	now := time.Now()
+	targets := NewTargetNamespaces(2, time.Hour)
+	targets.now = func() time.Time { return now }
+	vo := newTestValidation()
+
+	namespaces := func() []string {
+		result := []string{}
+		for _, target := range targets.list() {
+			result = append(result, target.namespace)
+		}
+		return result
+	}
+
+	targets.remember(vo, "foo")
+	targets.remember(vo, "bar")
+	targets.remember(vo, "foo")
+	targets.remember(vo, "baz")
+	if got, want := namespaces(), []string{"baz", "foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	// A namespace used again after it was listed is not forgotten
+	listed := targets.list()
+	now = now.Add(time.Minute)
+	targets.remember(vo, "baz")
+	for _, target := range listed {
+		targets.forget(target)
+	}
+	if got, want := namespaces(), []string{"baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	// The namespaces of different clusters are remembered separately
+	other := newTestValidation()
+	other.cluster = "other"
+	targets.remember(other, "baz")
+	if got, want := namespaces(), []string{"baz", "baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := namespaces(); len(got) != 0 {
+		t.Errorf("got: %v, want: expired namespaces forgotten", got)
+	}
+}
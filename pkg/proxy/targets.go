@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+
+	logUtils "github.com/gardener/potter-hub/pkg/log"
+)
+
+const (
+	// DefaultMaxTargetNamespaces is the default number of namespaces remembered for background jobs
+	DefaultMaxTargetNamespaces = 1000
+	// DefaultTargetNamespaceTTL is the default time a namespace is remembered after its last operation
+	DefaultTargetNamespaceTTL = 24 * time.Hour
+
+	// targetJobTimeout limits the time a job of the backend spends in a namespace
+	targetJobTimeout = 5 * time.Minute
+)
+
+// TargetNamespaces remembers the namespaces of the target clusters in which releases were operated,
+// together with the credentials of the latest operation in them. The backend has no credentials of
+// its own for the target clusters, jobs like the release pruner and the propagation of registry
+// credentials maintain the remembered namespaces instead. The least recently used namespaces are
+// forgotten if there are too many, as well as namespaces without operations for the ttl and namespaces
+// whose credentials were rejected.
+type TargetNamespaces struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[targetKey]*list.Element
+	order      *list.List
+	now        func() time.Time
+}
+
+type targetKey struct {
+	cluster   string
+	namespace string
+}
+
+// targetNamespace is a remembered namespace with the credentials to access it
+type targetNamespace struct {
+	key       targetKey
+	namespace string
+	vo        ValidationObject
+	seen      time.Time
+}
+
+// NewTargetNamespaces creates TargetNamespaces remembering at most maxEntries namespaces for the ttl
+func NewTargetNamespaces(maxEntries int, ttl time.Duration) *TargetNamespaces {
+	return &TargetNamespaces{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    map[targetKey]*list.Element{},
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// remember records an operation in the namespace with the credentials
+func (t *TargetNamespaces) remember(vo ValidationObject, namespace string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := targetKey{cluster: vo.clusterKey(), namespace: namespace}
+	target := &targetNamespace{key: key, namespace: namespace, vo: vo, seen: t.now()}
+	if elem, ok := t.entries[key]; ok {
+		elem.Value = target
+		t.order.MoveToFront(elem)
+		return
+	}
+	t.entries[key] = t.order.PushFront(target)
+	for t.maxEntries > 0 && t.order.Len() > t.maxEntries {
+		t.remove(t.order.Back())
+	}
+}
+
+// list returns the remembered namespaces, the most recently used first. Expired namespaces are forgotten.
+func (t *TargetNamespaces) list() []targetNamespace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	targets := []targetNamespace{}
+	for elem := t.order.Front(); elem != nil; {
+		next := elem.Next()
+		target := elem.Value.(*targetNamespace)
+		if t.ttl > 0 && now.Sub(target.seen) > t.ttl {
+			t.remove(elem)
+		} else {
+			targets = append(targets, *target)
+		}
+		elem = next
+	}
+	return targets
+}
+
+// forget removes the namespace unless it was used by another operation since it was listed
+func (t *TargetNamespaces) forget(target targetNamespace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[target.key]; ok && elem.Value.(*targetNamespace).seen.Equal(target.seen) {
+		t.remove(elem)
+	}
+}
+
+func (t *TargetNamespaces) remove(elem *list.Element) {
+	t.order.Remove(elem)
+	delete(t.entries, elem.Value.(*targetNamespace).key)
+}
+
+// forEachTarget runs a job of the backend in each remembered namespace with the credentials of the
+// latest operation in it. Namespaces whose credentials are rejected are forgotten until the next
+// operation in them, other failures are only logged.
+func (p *Proxy) forEachTarget(ctx context.Context, job string, run func(context.Context, targetNamespace) error) {
+	if p.Targets == nil {
+		return
+	}
+	for _, target := range p.Targets.list() {
+		jobCtx, cancel := context.WithTimeout(ctx, targetJobTimeout)
+		err := run(jobCtx, target)
+		cancel()
+		if cause := errors.Cause(err); k8sErrors.IsUnauthorized(cause) || k8sErrors.IsForbidden(cause) {
+			p.Targets.forget(target)
+		}
+		if err != nil {
+			logUtils.GetLogger(ctx).Errorf("Could not %s in namespace %s: %v", job, target.namespace, err)
+		}
+	}
+}
+
+// runInInterval runs a job of the backend in the interval until the context is done
+func runInInterval(ctx context.Context, interval time.Duration, run func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run(ctx)
+		}
+	}
+}
+
+// rememberTarget records an operation in the namespace for the background jobs
+func (p *Proxy) rememberTarget(vo ValidationObject, namespace string) {
+	if p.Targets != nil {
+		p.Targets.remember(vo, namespace)
+	}
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/kubeapps/common/response"
 	"github.com/pkg/errors"
-	"golang.org/x/oauth2/google"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -125,17 +124,3 @@ func DecodeBasicAuthCredentials(base64EncodedBasicAuthCredentials string) (strin
 	password := splittedCredentials[1]
 	return username, password, nil
 }
-
-// GetGCloudAccessToken receives and returns an access token from a google service account json string. Returns the access token or error.
-func GetGCloudAccessToken(gcloudServiceAccountJSON string) (string, error) {
-	jwtConfig, err := google.JWTConfigFromJSON([]byte(gcloudServiceAccountJSON), "https://www.googleapis.com/auth/devstorage.read_only")
-	if err != nil {
-		return "", errors.Wrap(err, "Couldn't create Google Service Account object")
-	}
-	tokenSource := jwtConfig.TokenSource(context.TODO())
-	token, err := tokenSource.Token()
-	if err != nil {
-		return "", errors.Wrap(err, "Couldn't fetch token from token source")
-	}
-	return token.AccessToken, nil
-}